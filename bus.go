@@ -0,0 +1,53 @@
+//go:build linux && go1.12
+
+package socketcan
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"golang.org/x/sys/unix"
+)
+
+// Bus is the frame level view of a CAN interface the protocol packages run on.
+// *Can satisfies it, tests and simulators may provide their own.
+type Bus interface {
+	SendFrame(f *canframe.Frame) (n int, err error)
+	RcvFrame() (canframe.Frame, error)
+	SetRecvTimeout(timeout time.Duration) error
+}
+
+// ErrTimeout is returned by RcvFrameUntil() when the deadline passed before a frame arrived.
+var ErrTimeout = errors.New("timeout")
+
+// IsTimeout reports whether err means a receive timed out,
+// either ErrTimeout or the EAGAIN RcvFrame() returns after SetRecvTimeout().
+func IsTimeout(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EWOULDBLOCK)
+}
+
+// RcvFrameUntil() will block until a frame arrived or the deadline passed.
+// A zero deadline blocks without limit.
+func RcvFrameUntil(bus Bus, deadline time.Time) (canframe.Frame, error) {
+	var timeout time.Duration
+	if !deadline.IsZero() {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return canframe.Frame{}, ErrTimeout
+		}
+		// A zero timeval means no timeout at all.
+		if timeout < time.Millisecond {
+			timeout = time.Millisecond
+		}
+	}
+	if err := bus.SetRecvTimeout(timeout); err != nil {
+		return canframe.Frame{}, err
+	}
+
+	f, err := bus.RcvFrame()
+	if err != nil && IsTimeout(err) {
+		return f, ErrTimeout
+	}
+	return f, err
+}
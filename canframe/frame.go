@@ -2,6 +2,12 @@ package canframe
 
 const FRAME_MAX_DATA_LEN = 8
 
+// FD_FRAME_MAX_DATA_LEN is the payload limit of CAN FD frames.
+const FD_FRAME_MAX_DATA_LEN = 64
+
+// XL_FRAME_MAX_DATA_LEN is the payload limit of CAN XL frames.
+const XL_FRAME_MAX_DATA_LEN = 2048
+
 type Frame struct {
 	// ID is the CAN ID
 	ID uint32 `json:"id,omitempty"`
@@ -13,4 +19,20 @@ type Frame struct {
 	IsRemote bool `json:"is_remote,omitempty"`
 	// Whether a error frame or not.
 	IsError bool `json:"is_error,omitempty"`
+	// Whether a CAN FD frame or not, its data may be up to 64 bytes.
+	IsFD bool `json:"is_fd,omitempty"`
+	// Bit rate switch of a CAN FD frame.
+	BRS bool `json:"brs,omitempty"`
+	// Error state indicator of a CAN FD frame.
+	ESI bool `json:"esi,omitempty"`
+	// Whether a CAN XL frame or not, its ID is the 11 bit priority and its data 1 to 2048 bytes.
+	IsXL bool `json:"is_xl,omitempty"`
+	// Virtual CAN network ID of a CAN XL frame.
+	VCID uint8 `json:"vcid,omitempty"`
+	// SDU type of a CAN XL frame, telling what its data is.
+	SDT uint8 `json:"sdt,omitempty"`
+	// Simple extended content flag of a CAN XL frame.
+	SEC bool `json:"sec,omitempty"`
+	// Acceptance field of a CAN XL frame.
+	AF uint32 `json:"af,omitempty"`
 }
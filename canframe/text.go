@@ -0,0 +1,188 @@
+package canframe
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// String formats f in the can-utils notation: 123#DEADBEEF, 12345678#R, 123##1AABB for CAN FD with flags 1,
+// and 45123#81:00:12345678#AABB for CAN XL with VCID 45, priority 123, flags 81, SDT 00 and AF 12345678.
+// Error frames have the error flag 20000000 in their 8 digit ID.
+func (f Frame) String() string {
+	var b strings.Builder
+	if f.IsXL {
+		flags := xlXLF
+		if f.SEC {
+			flags |= xlSEC
+		}
+		fmt.Fprintf(&b, "%02X%03X#%02X:%02X:%08X#", f.VCID, f.ID&sffMask, flags, f.SDT, f.AF)
+		b.WriteString(strings.ToUpper(hex.EncodeToString(f.Data)))
+		return b.String()
+	}
+	switch {
+	case f.IsError:
+		fmt.Fprintf(&b, "%08X", f.ID&effMask|errFlag)
+	case f.IsExtended:
+		fmt.Fprintf(&b, "%08X", f.ID&effMask)
+	default:
+		fmt.Fprintf(&b, "%03X", f.ID&sffMask)
+	}
+	switch {
+	case f.IsFD:
+		flags := 0
+		if f.BRS {
+			flags |= fdBRS
+		}
+		if f.ESI {
+			flags |= fdESI
+		}
+		fmt.Fprintf(&b, "##%X", flags)
+	case f.IsRemote:
+		b.WriteString("#R")
+		if len(f.Data) > 0 && len(f.Data) <= FRAME_MAX_DATA_LEN {
+			fmt.Fprintf(&b, "%d", len(f.Data))
+		}
+		return b.String()
+	default:
+		b.WriteByte('#')
+	}
+	b.WriteString(strings.ToUpper(hex.EncodeToString(f.Data)))
+	return b.String()
+}
+
+// MarshalText formats f like String().
+func (f Frame) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText parses the notation of String(), like cansend does.
+// Data bytes may be separated by dots, a classic frame may end with _ and a DLC beyond 8, which is dropped.
+func (f *Frame) UnmarshalText(text []byte) error {
+	var nf Frame
+	s := string(text)
+	id, rest, ok := strings.Cut(s, "#")
+	if !ok {
+		return fmt.Errorf("invalid frame %q", s)
+	}
+	if len(id) == 5 {
+		if !nf.unmarshalXL(id, rest) {
+			return fmt.Errorf("invalid CAN XL frame %q", s)
+		}
+		*f = nf
+		return nil
+	}
+	v, err := strconv.ParseUint(id, 16, 32)
+	switch {
+	case err != nil, len(id) == 3 && v > sffMask:
+		return fmt.Errorf("invalid frame id %q", id)
+	case len(id) == 3:
+		nf.ID = uint32(v)
+	case len(id) == 8 && v&errFlag != 0:
+		nf.ID, nf.IsError = uint32(v)&effMask&^errFlag, true
+	case len(id) == 8:
+		nf.ID, nf.IsExtended = uint32(v)&effMask, true
+	default:
+		return fmt.Errorf("invalid frame id %q", id)
+	}
+
+	max := FRAME_MAX_DATA_LEN
+	switch {
+	case strings.HasPrefix(rest, "#"):
+		if len(rest) < 2 {
+			return fmt.Errorf("missing CAN FD flags in %q", s)
+		}
+		flags, err := strconv.ParseUint(rest[1:2], 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid CAN FD flags in %q", s)
+		}
+		nf.IsFD, nf.BRS, nf.ESI = true, flags&fdBRS != 0, flags&fdESI != 0
+		rest = rest[2:]
+		max = FD_FRAME_MAX_DATA_LEN
+	case strings.HasPrefix(rest, "R"):
+		nf.IsRemote = true
+		n := 0
+		if len(rest) > 1 {
+			if n, err = strconv.Atoi(rest[1:]); err != nil || len(rest) > 2 || n > FRAME_MAX_DATA_LEN {
+				return fmt.Errorf("invalid remote frame length in %q", s)
+			}
+		}
+		nf.Data = make([]byte, n)
+		*f = nf
+		return nil
+	}
+
+	if i := strings.IndexByte(rest, '_'); i >= 0 && !nf.IsFD {
+		rest = rest[:i]
+	}
+	data, err := hex.DecodeString(strings.ReplaceAll(rest, ".", ""))
+	if err != nil || len(data) > max {
+		return fmt.Errorf("invalid frame data in %q", s)
+	}
+	nf.Data = data
+	*f = nf
+	return nil
+}
+
+// Set parses a frame given on the command line, so that a *Frame is a flag.Value.
+func (f *Frame) Set(s string) error {
+	return f.UnmarshalText([]byte(s))
+}
+
+// MarshalJSON keeps frames JSON objects, rather than the text of MarshalText.
+func (f Frame) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFrame(f))
+}
+
+// UnmarshalJSON reads the JSON objects of MarshalJSON.
+func (f *Frame) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*jsonFrame)(f))
+}
+
+// Text private.
+
+const (
+	sffMask = 0x7FF
+	effMask = 0x1FFFFFFF
+	errFlag = 0x20000000
+
+	fdBRS = 0x01
+	fdESI = 0x02
+
+	xlSEC = 0x01
+	xlXLF = 0x80
+)
+
+// unmarshalXL parses the VCID and priority id and the flags:sdt:af#data rest of a CAN XL frame.
+func (f *Frame) unmarshalXL(id, rest string) bool {
+	v, err := strconv.ParseUint(id, 16, 32)
+	if err != nil || v&0xFFF > sffMask {
+		return false
+	}
+	hdr, data, ok := strings.Cut(rest, "#")
+	fields := strings.Split(hdr, ":")
+	if !ok || len(fields) != 3 || len(fields[0]) != 2 || len(fields[1]) != 2 || len(fields[2]) != 8 {
+		return false
+	}
+	var vals [3]uint64
+	for i, field := range fields {
+		if vals[i], err = strconv.ParseUint(field, 16, 32); err != nil {
+			return false
+		}
+	}
+	if vals[0]&xlXLF == 0 {
+		return false
+	}
+	f.Data, err = hex.DecodeString(strings.ReplaceAll(data, ".", ""))
+	if err != nil || len(f.Data) < 1 || len(f.Data) > XL_FRAME_MAX_DATA_LEN {
+		return false
+	}
+	f.IsXL, f.ID, f.VCID = true, uint32(v&sffMask), uint8(v>>12)
+	f.SEC, f.SDT, f.AF = vals[0]&xlSEC != 0, uint8(vals[1]), uint32(vals[2])
+	return true
+}
+
+// jsonFrame is a Frame without methods, for the default JSON encoding.
+type jsonFrame Frame
@@ -0,0 +1,58 @@
+package canframe
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTextRoundTrip(t *testing.T) {
+	for _, f := range []Frame{
+		{ID: 0x123, Data: []byte{0xDE, 0xAD, 0xBE, 0xEF}},
+		{ID: 0x7FF, Data: []byte{}},
+		{ID: 0x12345678, IsExtended: true, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{ID: 0x123, IsRemote: true, Data: make([]byte, 3)},
+		{ID: 0x12345678, IsExtended: true, IsRemote: true, Data: []byte{}},
+		{ID: 0x456, IsFD: true, BRS: true, ESI: true, Data: bytes.Repeat([]byte{0x55}, 64)},
+		{ID: 0x4, IsError: true, Data: []byte{0, 8, 0, 0, 0, 0, 0, 0}},
+		{ID: 0x123, IsXL: true, VCID: 0x45, SDT: 0x03, SEC: true, AF: 0x12345678, Data: bytes.Repeat([]byte{0xAB}, 2048)},
+		{ID: 0x7FF, IsXL: true, Data: []byte{1}},
+	} {
+		var got Frame
+		if err := got.UnmarshalText([]byte(f.String())); err != nil {
+			t.Errorf("%s: %v", f, err)
+		} else if !reflect.DeepEqual(got, f) {
+			t.Errorf("%s: got %+v, want %+v", f, got, f)
+		}
+	}
+}
+
+func TestUnmarshalText(t *testing.T) {
+	for s, want := range map[string]Frame{
+		"123#11.22.33":                  {ID: 0x123, Data: []byte{0x11, 0x22, 0x33}},
+		"123#1122334455667788_C":        {ID: 0x123, Data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}},
+		"123#R":                         {ID: 0x123, IsRemote: true, Data: []byte{}},
+		"00000123##1":                   {ID: 0x123, IsExtended: true, IsFD: true, BRS: true, Data: []byte{}},
+		"45123#81:00:12345678#11.22.33": {ID: 0x123, IsXL: true, VCID: 0x45, SEC: true, AF: 0x12345678, Data: []byte{0x11, 0x22, 0x33}},
+	} {
+		var got Frame
+		if err := got.UnmarshalText([]byte(s)); err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", s, got, want)
+		}
+	}
+}
+
+func TestUnmarshalTextInvalid(t *testing.T) {
+	for _, s := range []string{
+		"", "123", "12#11", "1234#11", "FFF#11", "800#11", "123#1", "123#112233445566778899",
+		"123#R9", "123#R12", "123#Rx", "123##", "123##X11", "123#GG",
+		"45123#01:00:12345678#11", "45123#80:00:12345678#", "45800#80:00:12345678#11", "45123#80:00:1234#11", "45123#80:00:12345678",
+	} {
+		var f Frame
+		if err := f.UnmarshalText([]byte(s)); err == nil {
+			t.Errorf("%q parsed as %s", s, f)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+// Package asc reads and writes Vector ASC log files.
+package asc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/canlog"
+)
+
+// Reader reads the CAN and CAN FD frames and error frames of an ASC file, other events are skipped.
+// Channel numbers become interface names by canlog.ChannelName().
+// Timestamps count from the measurement start in the header, from the Unix epoch if it has none.
+type Reader struct {
+	sc       *bufio.Scanner
+	line     int
+	dec      bool
+	relative bool
+	start    time.Time
+	last     time.Duration
+}
+
+func (my *Reader) Init(r io.Reader) *Reader {
+	my.sc = bufio.NewScanner(r)
+	my.sc.Buffer(nil, 1<<20)
+	my.line = 0
+	my.dec = false
+	my.relative = false
+	my.start = time.Unix(0, 0)
+	my.last = 0
+	return my
+}
+
+// Read returns the next frame, io.EOF at the end of the file.
+func (my *Reader) Read() (canlog.Record, error) {
+	for my.sc.Scan() {
+		my.line++
+		r, ok, err := my.parse(strings.Fields(my.sc.Text()))
+		if err != nil {
+			return r, fmt.Errorf("asc: line %d: %w", my.line, err)
+		}
+		if ok {
+			return r, nil
+		}
+	}
+	if err := my.sc.Err(); err != nil {
+		return canlog.Record{}, err
+	}
+	return canlog.Record{}, io.EOF
+}
+
+// Writer writes an ASC file.
+type Writer struct {
+	w       *bufio.Writer
+	started bool
+	last    time.Time
+	// Start is the measurement start, the time of the first record if zero.
+	Start time.Time
+	// Dec writes IDs and data in decimal, rather than hex.
+	Dec bool
+	// Relative writes the time since the previous record, rather than since Start.
+	Relative bool
+}
+
+func (my *Writer) Init(w io.Writer) *Writer {
+	my.w = bufio.NewWriter(w)
+	my.started = false
+	return my
+}
+
+// Write writes one record, channels are taken from interface names by canlog.Channel().
+// ASC has no CAN XL frames, writing one is an error.
+func (my *Writer) Write(r *canlog.Record) error {
+	if r.Frame.IsXL {
+		return fmt.Errorf("asc: no CAN XL frames")
+	}
+	if !my.started {
+		if my.Start.IsZero() {
+			my.Start = r.Time
+		}
+		my.header()
+	}
+	ts := r.Time.Sub(my.Start)
+	if my.Relative {
+		ts = r.Time.Sub(my.last)
+	}
+	my.last = r.Time
+
+	f := &r.Frame
+	ch := canlog.Channel(r.Interface)
+	dir := "Rx"
+	if r.Tx {
+		dir = "Tx"
+	}
+	fmt.Fprintf(my.w, "%11.6f ", ts.Seconds())
+	switch {
+	case f.IsFD:
+		flags := edlFlag
+		brs, esi := 0, 0
+		if f.BRS {
+			brs, flags = 1, flags|brsFlag
+		}
+		if f.ESI {
+			esi, flags = 1, flags|esiFlag
+		}
+		if f.IsError {
+			fmt.Fprintf(my.w, "CANFD %3d %-4s ErrorFrame\n", ch, dir)
+			break
+		}
+		fmt.Fprintf(my.w, "CANFD %3d %-4s %8s %d %d %x %2d%s %8d %4d %8x %8d %8d %8d %8d %8d\n",
+			ch, dir, my.id(f), brs, esi, fdDLC(len(f.Data)), len(f.Data), my.data(f.Data), 0, 0, flags, 0, 0, 0, 0, 0)
+	case f.IsError:
+		fmt.Fprintf(my.w, "%-2d ErrorFrame\n", ch)
+	case f.IsRemote:
+		fmt.Fprintf(my.w, "%-2d %-15s %-4s r %x\n", ch, my.id(f), dir, len(f.Data))
+	default:
+		fmt.Fprintf(my.w, "%-2d %-15s %-4s d %x%s\n", ch, my.id(f), dir, len(f.Data), my.data(f.Data))
+	}
+	return my.w.Flush()
+}
+
+// Close ends the trigger block, it does not close the underlying writer.
+func (my *Writer) Close() error {
+	if !my.started {
+		if my.Start.IsZero() {
+			my.Start = time.Now()
+		}
+		my.header()
+	}
+	fmt.Fprintf(my.w, "End TriggerBlock\n")
+	return my.w.Flush()
+}
+
+// ASC private.
+
+// Vector's CAN FD flags.
+const (
+	edlFlag = 0x1000
+	brsFlag = 0x2000
+	esiFlag = 0x4000
+)
+
+// dateLayouts are the header date formats, fractional seconds parse without being in the layout.
+var dateLayouts = []string{"Mon Jan 2 03:04:05 pm 2006", "Mon Jan 2 15:04:05 2006"}
+
+const writeLayout = "Mon Jan 02 03:04:05.000 pm 2006"
+
+// parse parses the fields of a line, ok tells whether it was a frame.
+func (my *Reader) parse(f []string) (r canlog.Record, ok bool, err error) {
+	if len(f) == 0 {
+		return r, false, nil
+	}
+	switch f[0] {
+	case "date":
+		my.date(f[1:])
+		return r, false, nil
+	case "base":
+		// base hex  timestamps absolute
+		my.dec = len(f) > 1 && f[1] == "dec"
+		my.relative = len(f) > 3 && f[3] == "relative"
+		return r, false, nil
+	case "Begin":
+		if len(f) > 2 {
+			my.date(f[2:])
+		}
+		return r, false, nil
+	}
+	secs, err := strconv.ParseFloat(f[0], 64)
+	if err != nil || len(f) < 3 {
+		// Other header lines and comments.
+		return r, false, nil
+	}
+	ts := time.Duration(math.Round(secs * 1e9))
+	if my.relative {
+		ts += my.last
+	}
+	my.last = ts
+	r.Time = my.start.Add(ts)
+
+	if f[1] == "CANFD" {
+		return my.fd(r, f[2:])
+	}
+	ch, err := strconv.Atoi(f[1])
+	if err != nil {
+		// Events like "Start of measurement".
+		return r, false, nil
+	}
+	r.Interface = canlog.ChannelName(ch)
+	if f[2] == "ErrorFrame" {
+		r.Frame.IsError = true
+		return r, true, nil
+	}
+	if len(f) < 5 || !my.parseID(&r.Frame, f[2]) || (f[3] != "Rx" && f[3] != "Tx") {
+		// Statistics, status and other events of the channel.
+		return r, false, nil
+	}
+	r.Tx = f[3] == "Tx"
+	switch f[4] {
+	case "r":
+		r.Frame.IsRemote = true
+		n := 0
+		if len(f) > 5 {
+			if n, err = my.dlc(f[5]); err != nil || n > canframe.FRAME_MAX_DATA_LEN {
+				return r, false, fmt.Errorf("invalid dlc %q", f[5])
+			}
+		}
+		r.Frame.Data = make([]byte, n)
+	case "d":
+		if len(f) < 6 {
+			return r, false, fmt.Errorf("missing dlc")
+		}
+		n, err := my.dlc(f[5])
+		if err != nil {
+			return r, false, fmt.Errorf("invalid dlc %q", f[5])
+		}
+		// DLCs beyond 8 still carry 8 bytes.
+		if n > canframe.FRAME_MAX_DATA_LEN {
+			n = canframe.FRAME_MAX_DATA_LEN
+		}
+		if r.Frame.Data, err = my.bytes(f[6:], n); err != nil {
+			return r, false, err
+		}
+	default:
+		return r, false, nil
+	}
+	return r, true, nil
+}
+
+// fd parses the fields after CANFD:
+// channel dir id [name] brs esi dlc length data... duration bits flags crc timings...
+func (my *Reader) fd(r canlog.Record, f []string) (canlog.Record, bool, error) {
+	if len(f) < 3 {
+		return r, false, fmt.Errorf("short CANFD line")
+	}
+	ch, err := strconv.Atoi(f[0])
+	if err != nil {
+		return r, false, fmt.Errorf("invalid channel %q", f[0])
+	}
+	r.Interface = canlog.ChannelName(ch)
+	r.Tx = f[1] == "Tx"
+	r.Frame.IsFD = true
+	if f[2] == "ErrorFrame" {
+		r.Frame.IsError = true
+		return r, true, nil
+	}
+	if !my.parseID(&r.Frame, f[2]) {
+		return r, false, fmt.Errorf("invalid id %q", f[2])
+	}
+	f = f[3:]
+	if len(f) > 0 && f[0] != "0" && f[0] != "1" {
+		// The symbolic name.
+		f = f[1:]
+	}
+	if len(f) < 4 {
+		return r, false, fmt.Errorf("short CANFD line")
+	}
+	r.Frame.BRS, r.Frame.ESI = f[0] == "1", f[1] == "1"
+	n, err := strconv.Atoi(f[3])
+	if err != nil || n > canframe.FD_FRAME_MAX_DATA_LEN {
+		return r, false, fmt.Errorf("invalid data length %q", f[3])
+	}
+	if r.Frame.Data, err = my.bytes(f[4:], n); err != nil {
+		return r, false, err
+	}
+	return r, true, nil
+}
+
+func (my *Reader) date(f []string) {
+	s := strings.Join(f, " ")
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			my.start = t
+			return
+		}
+	}
+}
+
+// parseID parses an ID of the file's base, extended ones end with x.
+func (my *Reader) parseID(f *canframe.Frame, s string) bool {
+	ext := strings.HasSuffix(s, "x") || strings.HasSuffix(s, "X")
+	s = strings.TrimRight(s, "xX")
+	base := 16
+	if my.dec {
+		base = 10
+	}
+	id, err := strconv.ParseUint(s, base, 29)
+	if err != nil || (!ext && id > 0x7FF) {
+		return false
+	}
+	f.ID, f.IsExtended = uint32(id), ext
+	return true
+}
+
+func (my *Reader) dlc(s string) (int, error) {
+	n, err := strconv.ParseUint(s, 16, 4)
+	return int(n), err
+}
+
+// bytes parses n data bytes of the file's base.
+func (my *Reader) bytes(f []string, n int) ([]byte, error) {
+	if len(f) < n {
+		return nil, fmt.Errorf("%d of %d data bytes", len(f), n)
+	}
+	base := 16
+	if my.dec {
+		base = 10
+	}
+	data := make([]byte, n)
+	for i := range data {
+		b, err := strconv.ParseUint(f[i], base, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data byte %q", f[i])
+		}
+		data[i] = byte(b)
+	}
+	return data, nil
+}
+
+func (my *Writer) header() {
+	my.started = true
+	my.last = my.Start
+	base, stamps := "hex", "absolute"
+	if my.Dec {
+		base = "dec"
+	}
+	if my.Relative {
+		stamps = "relative"
+	}
+	date := my.Start.Format(writeLayout)
+	fmt.Fprintf(my.w, "date %s\nbase %s  timestamps %s\ninternal events logged\n", date, base, stamps)
+	fmt.Fprintf(my.w, "Begin Triggerblock %s\n%11.6f Start of measurement\n", date, 0.0)
+}
+
+func (my *Writer) id(f *canframe.Frame) string {
+	s := strconv.FormatUint(uint64(f.ID), 10)
+	if !my.Dec {
+		s = strings.ToUpper(strconv.FormatUint(uint64(f.ID), 16))
+	}
+	if f.IsExtended {
+		s += "x"
+	}
+	return s
+}
+
+// data formats bytes with a leading space each.
+func (my *Writer) data(data []byte) string {
+	var b strings.Builder
+	for _, d := range data {
+		if my.Dec {
+			fmt.Fprintf(&b, " %d", d)
+		} else {
+			fmt.Fprintf(&b, " %02X", d)
+		}
+	}
+	return b.String()
+}
+
+// fdDLC returns the CAN FD DLC of a payload length.
+func fdDLC(n int) int {
+	for dlc, l := range []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 16, 20, 24, 32, 48, 64} {
+		if l >= n {
+			return dlc
+		}
+	}
+	return 15
+}
@@ -0,0 +1,86 @@
+package asc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/canlog"
+	"github.com/lion187chen/socketcan-go/canlog/internal/logtest"
+)
+
+// logged returns what the records read back as, error frames keep channel and time only.
+func logged(recs []canlog.Record) []canlog.Record {
+	var want []canlog.Record
+	for _, r := range recs {
+		if r.Frame.IsError {
+			r.Tx = false
+			r.Frame = canframe.Frame{IsError: true, IsFD: r.Frame.IsFD}
+		}
+		want = append(want, r)
+	}
+	return want
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		dec, relative bool
+	}{
+		{"hex absolute", false, false},
+		{"dec relative", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recs := logtest.Records()
+			var b bytes.Buffer
+			w := new(Writer).Init(&b)
+			w.Dec, w.Relative = tc.dec, tc.relative
+			logtest.WriteAll(t, w, recs)
+			logtest.Check(t, logtest.ReadAll(t, new(Reader).Init(&b)), logged(recs))
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	const log = `date Mon May 6 07:08:09.010 am 2024
+base hex  timestamps absolute
+internal events logged
+// version 13.0.0
+Begin Triggerblock Mon May 6 07:08:09.010 am 2024
+   0.000000 Start of measurement
+   0.001000 1  Statistic: D 0 R 0 XD 0 XR 0 E 0 O 0 B 0.00%
+   0.002000 1  123             Rx   d 3 01 02 03  Length = 0 BitCount = 0 ID = 291
+   0.003000 2  1ABCDEF0x       Tx   d 8 01 02 03 04 05 06 07 08
+   0.004000 1  7FF             Rx   r 2
+   0.005000 CANFD   1 Rx        456  Engine                           1 0 9 12 55 55 55 55 55 55 55 55 55 55 55 55        0    0   3000        0        0        0        0        0
+   0.006000 2  ErrorFrame
+End TriggerBlock
+`
+	t0 := time.Date(2024, 5, 6, 7, 8, 9, 10_000_000, time.Local)
+	want := []canlog.Record{
+		{Time: t0.Add(2 * time.Millisecond), Interface: "can0", Frame: canframe.Frame{ID: 0x123, Data: []byte{1, 2, 3}}},
+		{Time: t0.Add(3 * time.Millisecond), Interface: "can1", Tx: true,
+			Frame: canframe.Frame{ID: 0x1ABCDEF0, IsExtended: true, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+		{Time: t0.Add(4 * time.Millisecond), Interface: "can0", Frame: canframe.Frame{ID: 0x7FF, IsRemote: true, Data: make([]byte, 2)}},
+		{Time: t0.Add(5 * time.Millisecond), Interface: "can0",
+			Frame: canframe.Frame{ID: 0x456, IsFD: true, BRS: true, Data: bytes.Repeat([]byte{0x55}, 12)}},
+		{Time: t0.Add(6 * time.Millisecond), Interface: "can1", Frame: canframe.Frame{IsError: true}},
+	}
+	logtest.Check(t, logtest.ReadAll(t, new(Reader).Init(strings.NewReader(log))), want)
+}
+
+func TestReadInvalid(t *testing.T) {
+	for _, line := range []string{
+		"0.001 1 123 Rx d 4 01 02",
+		"0.001 1 123 Rx d 2 01 GG",
+		"0.001 1 123 Rx r X",
+		"0.001 CANFD 1 Rx 123 0 0 f 65",
+		"0.001 CANFD x Rx 123",
+	} {
+		if _, err := new(Reader).Init(strings.NewReader(line)).Read(); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+// Package blf reads and writes Vector BLF binary log files.
+package blf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/canlog"
+)
+
+// Object types.
+const (
+	CAN_MESSAGE       = 1
+	LOG_CONTAINER     = 10
+	CAN_ERROR_EXT     = 73
+	CAN_MESSAGE2      = 86
+	CAN_FD_MESSAGE    = 100
+	CAN_FD_MESSAGE_64 = 101
+)
+
+// ErrFormat is returned for data that is no BLF file or broken.
+var ErrFormat = errors.New("blf: invalid format")
+
+// Reader reads the CAN objects of a BLF file, decompressing one log container at a time.
+// Objects of other buses and events are skipped. Channels become interface names by canlog.ChannelName().
+type Reader struct {
+	r       *bufio.Reader
+	started bool
+	start   time.Time
+	// buf holds uncompressed objects not read yet, one may continue in the next container.
+	buf []byte
+	eof bool
+}
+
+func (my *Reader) Init(r io.Reader) *Reader {
+	my.r = bufio.NewReader(r)
+	my.started = false
+	my.buf = nil
+	my.eof = false
+	return my
+}
+
+// Start returns the measurement start of the file header, known after the first Read().
+func (my *Reader) Start() time.Time {
+	return my.start
+}
+
+// Read returns the next frame, io.EOF at the end of the file.
+func (my *Reader) Read() (canlog.Record, error) {
+	if !my.started {
+		if err := my.header(); err != nil {
+			return canlog.Record{}, err
+		}
+	}
+	for {
+		if err := my.align(); err != nil {
+			return canlog.Record{}, err
+		}
+		if len(my.buf) >= objHeaderBaseLen {
+			size := int(binary.LittleEndian.Uint32(my.buf[8:]))
+			if size < objHeaderBaseLen {
+				return canlog.Record{}, ErrFormat
+			}
+			if len(my.buf) >= size {
+				obj := my.buf[:size]
+				my.buf = my.buf[size:]
+				r, ok, err := my.object(obj)
+				if err != nil || ok {
+					return r, err
+				}
+				continue
+			}
+		}
+		if my.eof {
+			if len(my.buf) >= 4 {
+				return canlog.Record{}, io.ErrUnexpectedEOF
+			}
+			return canlog.Record{}, io.EOF
+		}
+		if err := my.fill(); err != nil {
+			return canlog.Record{}, err
+		}
+	}
+}
+
+// Writer writes a BLF file, compressing objects in log containers.
+// The file header gets its object count and stop time on Close() if the underlying writer is an io.WriteSeeker.
+type Writer struct {
+	w       io.Writer
+	start   time.Time
+	last    time.Time
+	started bool
+	// buf collects objects for the next container.
+	buf          bytes.Buffer
+	written      int64
+	uncompressed int64
+	count        uint32
+}
+
+func (my *Writer) Init(w io.Writer) *Writer {
+	my.w = w
+	my.started = false
+	my.buf.Reset()
+	my.written = 0
+	my.uncompressed = 0
+	my.count = 0
+	return my
+}
+
+// Write adds a record, the file's measurement start is the time of the first one.
+// CAN XL frames are not supported, writing one is an error.
+func (my *Writer) Write(r *canlog.Record) error {
+	if r.Frame.IsXL {
+		return errors.New("blf: no CAN XL frames")
+	}
+	if !my.started {
+		if err := my.begin(r.Time); err != nil {
+			return err
+		}
+	}
+	if r.Time.After(my.last) {
+		my.last = r.Time
+	}
+	ts := r.Time.Sub(my.start)
+	if ts < 0 {
+		ts = 0
+	}
+
+	f := &r.Frame
+	ch := uint16(canlog.Channel(r.Interface))
+	id := f.ID
+	if f.IsExtended {
+		id |= extFlag
+	}
+	var typ uint32
+	var body []byte
+	switch {
+	case f.IsError:
+		typ = CAN_ERROR_EXT
+		body = make([]byte, 32)
+		binary.LittleEndian.PutUint16(body[0:], ch)
+		binary.LittleEndian.PutUint16(body[2:], uint16(len(f.Data)))
+		body[10] = byte(len(f.Data))
+		binary.LittleEndian.PutUint32(body[16:], f.ID)
+		copy(body[24:32], f.Data)
+	case f.IsFD:
+		typ = CAN_FD_MESSAGE_64
+		n := len(f.Data)
+		if n > canframe.FD_FRAME_MAX_DATA_LEN {
+			n = canframe.FD_FRAME_MAX_DATA_LEN
+		}
+		// The object keeps a multiple of 4 bytes.
+		body = make([]byte, fd64Len+(n+3)/4*4)
+		body[0] = byte(ch)
+		body[1] = byte(fdDLC(n))
+		body[2] = byte(n)
+		binary.LittleEndian.PutUint32(body[4:], id)
+		flags := uint32(fd64EDL)
+		if f.BRS {
+			flags |= fd64BRS
+		}
+		if f.ESI {
+			flags |= fd64ESI
+		}
+		binary.LittleEndian.PutUint32(body[12:], flags)
+		if r.Tx {
+			body[34] = 1
+		}
+		copy(body[fd64Len:], f.Data[:n])
+	default:
+		typ = CAN_MESSAGE
+		body = make([]byte, 16)
+		binary.LittleEndian.PutUint16(body[0:], ch)
+		if r.Tx {
+			body[2] |= dirTx
+		}
+		if f.IsRemote {
+			body[2] |= remoteFlag
+		}
+		n := len(f.Data)
+		if n > canframe.FRAME_MAX_DATA_LEN {
+			n = canframe.FRAME_MAX_DATA_LEN
+		}
+		body[3] = byte(n)
+		binary.LittleEndian.PutUint32(body[4:], id)
+		if !f.IsRemote {
+			copy(body[8:16], f.Data[:n])
+		}
+	}
+
+	hdr := make([]byte, objHeaderV1Len)
+	copy(hdr, "LOBJ")
+	binary.LittleEndian.PutUint16(hdr[4:], objHeaderV1Len)
+	binary.LittleEndian.PutUint16(hdr[6:], 1)
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(objHeaderV1Len+len(body)))
+	binary.LittleEndian.PutUint32(hdr[12:], typ)
+	binary.LittleEndian.PutUint32(hdr[16:], timeOneNanos)
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(ts))
+	my.buf.Write(hdr)
+	my.buf.Write(body)
+	my.count++
+	if my.buf.Len() >= containerLen {
+		return my.flush()
+	}
+	return nil
+}
+
+// Close writes the objects left, it does not close the underlying writer.
+func (my *Writer) Close() error {
+	if !my.started {
+		if err := my.begin(time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := my.flush(); err != nil {
+		return err
+	}
+	ws, ok := my.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	if _, err := ws.Seek(-my.written, io.SeekCurrent); err != nil {
+		return err
+	}
+	if _, err := ws.Write(my.header()); err != nil {
+		return err
+	}
+	_, err := ws.Seek(my.written-fileHeaderLen, io.SeekCurrent)
+	return err
+}
+
+// BLF private.
+
+const (
+	fileHeaderLen    = 144
+	objHeaderBaseLen = 16
+	objHeaderV1Len   = 32
+	containerLen     = 128 << 10
+
+	timeTenMicros = 1
+	timeOneNanos  = 2
+
+	extFlag    = 0x80000000
+	dirTx      = 0x01
+	remoteFlag = 0x80
+
+	fdEDL = 0x01
+	fdBRS = 0x02
+	fdESI = 0x04
+
+	fd64Len    = 40
+	fd64Remote = 0x0010
+	fd64EDL    = 0x1000
+	fd64BRS    = 0x2000
+	fd64ESI    = 0x4000
+)
+
+var fdLens = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 16, 20, 24, 32, 48, 64}
+
+// header reads the file header.
+func (my *Reader) header() error {
+	my.started = true
+	hdr := make([]byte, fileHeaderLen)
+	if _, err := io.ReadFull(my.r, hdr); err != nil {
+		return err
+	}
+	if string(hdr[0:4]) != "LOGG" {
+		return ErrFormat
+	}
+	size := binary.LittleEndian.Uint32(hdr[4:])
+	if size < fileHeaderLen {
+		return ErrFormat
+	}
+	if _, err := my.r.Discard(int(size) - fileHeaderLen); err != nil {
+		return err
+	}
+	my.start = systemTime(hdr[40:56])
+	return nil
+}
+
+// align skips the padding between objects.
+func (my *Reader) align() error {
+	for skip := 0; len(my.buf) >= 4 && string(my.buf[:4]) != "LOBJ"; skip++ {
+		if skip == 3 {
+			return ErrFormat
+		}
+		my.buf = my.buf[1:]
+	}
+	return nil
+}
+
+// fill reads the next top level object: a container is decompressed, other objects are taken as they are.
+func (my *Reader) fill() error {
+	for skip := 0; ; skip++ {
+		b, err := my.r.Peek(4)
+		if len(b) < 4 {
+			if err == io.EOF {
+				// Maybe after trailing padding.
+				my.eof = true
+				return nil
+			}
+			return err
+		}
+		if string(b) == "LOBJ" {
+			break
+		}
+		if skip == 3 {
+			return ErrFormat
+		}
+		my.r.Discard(1)
+	}
+	hdr := make([]byte, objHeaderBaseLen)
+	if _, err := io.ReadFull(my.r, hdr); err != nil {
+		return err
+	}
+	size := int(binary.LittleEndian.Uint32(hdr[8:]))
+	if size < objHeaderBaseLen {
+		return ErrFormat
+	}
+	data := make([]byte, size-objHeaderBaseLen)
+	if _, err := io.ReadFull(my.r, data); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(hdr[12:]) != LOG_CONTAINER {
+		my.buf = append(append(my.buf, hdr...), data...)
+		return nil
+	}
+	if len(data) < 16 {
+		return ErrFormat
+	}
+	method := binary.LittleEndian.Uint16(data[0:])
+	payload := data[16:]
+	switch method {
+	case 0:
+		my.buf = append(my.buf, payload...)
+	case 2:
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("blf: %w", err)
+		}
+		out, err := io.ReadAll(zr)
+		if err != nil {
+			return fmt.Errorf("blf: %w", err)
+		}
+		my.buf = append(my.buf, out...)
+	default:
+		return fmt.Errorf("blf: unknown compression method %d", method)
+	}
+	return nil
+}
+
+// object converts an object, ok tells whether it was a frame.
+func (my *Reader) object(obj []byte) (r canlog.Record, ok bool, err error) {
+	hdrLen := int(binary.LittleEndian.Uint16(obj[4:]))
+	version := binary.LittleEndian.Uint16(obj[6:])
+	typ := binary.LittleEndian.Uint32(obj[12:])
+	switch typ {
+	case CAN_MESSAGE, CAN_MESSAGE2, CAN_FD_MESSAGE, CAN_FD_MESSAGE_64, CAN_ERROR_EXT:
+	default:
+		return r, false, nil
+	}
+	if (version != 1 && version != 2) || hdrLen > len(obj) || hdrLen < objHeaderV1Len {
+		return r, false, ErrFormat
+	}
+	// Both header versions have flags and timestamp at the same offsets.
+	flags := binary.LittleEndian.Uint32(obj[16:])
+	ts := binary.LittleEndian.Uint64(obj[24:])
+	if flags&timeTenMicros != 0 {
+		ts *= 10000
+	}
+	r.Time = my.start.Add(time.Duration(ts))
+	body := obj[hdrLen:]
+	f := &r.Frame
+
+	switch typ {
+	case CAN_MESSAGE, CAN_MESSAGE2:
+		if len(body) < 16 {
+			return r, false, ErrFormat
+		}
+		r.Interface = canlog.ChannelName(int(binary.LittleEndian.Uint16(body[0:])))
+		r.Tx = body[2]&dirTx != 0
+		f.IsRemote = body[2]&remoteFlag != 0
+		setID(f, binary.LittleEndian.Uint32(body[4:]))
+		n := int(body[3])
+		if n > canframe.FRAME_MAX_DATA_LEN {
+			n = canframe.FRAME_MAX_DATA_LEN
+		}
+		f.Data = make([]byte, n)
+		if !f.IsRemote {
+			copy(f.Data, body[8:8+n])
+		}
+	case CAN_FD_MESSAGE:
+		if len(body) < 84 {
+			return r, false, ErrFormat
+		}
+		r.Interface = canlog.ChannelName(int(binary.LittleEndian.Uint16(body[0:])))
+		r.Tx = body[2]&dirTx != 0
+		f.IsRemote = body[2]&remoteFlag != 0
+		setID(f, binary.LittleEndian.Uint32(body[4:]))
+		fd := body[13]
+		f.IsFD, f.BRS, f.ESI = fd&fdEDL != 0, fd&fdBRS != 0, fd&fdESI != 0
+		n := int(body[14])
+		if n > canframe.FD_FRAME_MAX_DATA_LEN {
+			return r, false, ErrFormat
+		}
+		if !f.IsFD && n > canframe.FRAME_MAX_DATA_LEN {
+			n = canframe.FRAME_MAX_DATA_LEN
+		}
+		f.Data = make([]byte, n)
+		if !f.IsRemote {
+			copy(f.Data, body[20:20+n])
+		}
+	case CAN_FD_MESSAGE_64:
+		if len(body) < fd64Len {
+			return r, false, ErrFormat
+		}
+		r.Interface = canlog.ChannelName(int(body[0]))
+		setID(f, binary.LittleEndian.Uint32(body[4:]))
+		fl := binary.LittleEndian.Uint32(body[12:])
+		f.IsRemote = fl&fd64Remote != 0
+		f.IsFD, f.BRS, f.ESI = fl&fd64EDL != 0, fl&fd64BRS != 0, fl&fd64ESI != 0
+		r.Tx = body[34] != 0
+		n := int(body[2])
+		if n > canframe.FD_FRAME_MAX_DATA_LEN || fd64Len+n > len(body) {
+			return r, false, ErrFormat
+		}
+		if f.IsRemote {
+			f.Data = make([]byte, fdLens[body[1]&0x0F])
+			break
+		}
+		f.Data = append([]byte(nil), body[fd64Len:fd64Len+n]...)
+	case CAN_ERROR_EXT:
+		if len(body) < 32 {
+			return r, false, ErrFormat
+		}
+		r.Interface = canlog.ChannelName(int(binary.LittleEndian.Uint16(body[0:])))
+		f.IsError = true
+		f.ID = binary.LittleEndian.Uint32(body[16:]) &^ extFlag
+		n := int(body[10])
+		if n > canframe.FRAME_MAX_DATA_LEN {
+			n = canframe.FRAME_MAX_DATA_LEN
+		}
+		f.Data = append([]byte(nil), body[24:24+n]...)
+	}
+	return r, true, nil
+}
+
+func setID(f *canframe.Frame, id uint32) {
+	f.ID, f.IsExtended = id&^extFlag, id&extFlag != 0
+}
+
+// begin writes the file header, start is truncated to the milliseconds it keeps.
+func (my *Writer) begin(start time.Time) error {
+	my.started = true
+	my.start = start.Truncate(time.Millisecond)
+	my.last = my.start
+	_, err := my.w.Write(my.header())
+	my.written += fileHeaderLen
+	my.uncompressed += fileHeaderLen
+	return err
+}
+
+func (my *Writer) header() []byte {
+	hdr := make([]byte, fileHeaderLen)
+	copy(hdr, "LOGG")
+	binary.LittleEndian.PutUint32(hdr[4:], fileHeaderLen)
+	// Binary log format 4.7.
+	hdr[12], hdr[13] = 4, 7
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(my.written))
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(my.uncompressed))
+	binary.LittleEndian.PutUint32(hdr[32:], my.count)
+	putSystemTime(hdr[40:], my.start)
+	putSystemTime(hdr[56:], my.last)
+	return hdr
+}
+
+// flush writes the collected objects as a zlib compressed container.
+func (my *Writer) flush() error {
+	if my.buf.Len() == 0 {
+		return nil
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(my.buf.Bytes())
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	size := objHeaderBaseLen + 16 + z.Len()
+	obj := make([]byte, objHeaderBaseLen+16, size+size%4)
+	copy(obj, "LOBJ")
+	binary.LittleEndian.PutUint16(obj[4:], objHeaderBaseLen)
+	binary.LittleEndian.PutUint16(obj[6:], 1)
+	binary.LittleEndian.PutUint32(obj[8:], uint32(size))
+	binary.LittleEndian.PutUint32(obj[12:], LOG_CONTAINER)
+	binary.LittleEndian.PutUint16(obj[16:], 2)
+	binary.LittleEndian.PutUint32(obj[24:], uint32(my.buf.Len()))
+	obj = append(obj, z.Bytes()...)
+	// Containers are followed by size % 4 bytes of padding.
+	obj = append(obj, make([]byte, size%4)...)
+	my.uncompressed += int64(my.buf.Len())
+	my.buf.Reset()
+	n, err := my.w.Write(obj)
+	my.written += int64(n)
+	return err
+}
+
+// fdDLC returns the CAN FD DLC of a payload length.
+func fdDLC(n int) int {
+	for dlc, l := range fdLens {
+		if l >= n {
+			return dlc
+		}
+	}
+	return 15
+}
+
+// systemTime converts a Windows SYSTEMTIME in local time.
+func systemTime(b []byte) time.Time {
+	v := func(i int) int { return int(binary.LittleEndian.Uint16(b[2*i:])) }
+	if v(0) == 0 {
+		return time.Unix(0, 0)
+	}
+	return time.Date(v(0), time.Month(v(1)), v(3), v(4), v(5), v(6), v(7)*int(time.Millisecond), time.Local)
+}
+
+func putSystemTime(b []byte, t time.Time) {
+	t = t.In(time.Local)
+	for i, v := range []int{t.Year(), int(t.Month()), int(t.Weekday()), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond() / int(time.Millisecond)} {
+		binary.LittleEndian.PutUint16(b[2*i:], uint16(v))
+	}
+}
@@ -0,0 +1,54 @@
+package blf
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lion187chen/socketcan-go/canlog/internal/logtest"
+)
+
+func TestRoundTripBuffer(t *testing.T) {
+	recs := logtest.Records()
+	var b bytes.Buffer
+	logtest.WriteAll(t, new(Writer).Init(&b), recs)
+	logtest.Check(t, logtest.ReadAll(t, new(Reader).Init(&b)), recs)
+}
+
+func TestRoundTripFile(t *testing.T) {
+	recs := logtest.Records()
+	path := filepath.Join(t.TempDir(), "test.blf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logtest.WriteAll(t, new(Writer).Init(f), recs)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header gets the file size and object count on Close().
+	if size := int64(data[16]) | int64(data[17])<<8 | int64(data[18])<<16 | int64(data[19])<<24; size != int64(len(data)) {
+		t.Errorf("header file size %d, file has %d bytes", size, len(data))
+	}
+	if count := int(data[32]) | int(data[33])<<8; count != len(recs) {
+		t.Errorf("header object count %d, want %d", count, len(recs))
+	}
+	r := new(Reader).Init(bytes.NewReader(data))
+	got := logtest.ReadAll(t, r)
+	if !r.Start().Equal(recs[0].Time) {
+		t.Errorf("start %v, want %v", r.Start(), recs[0].Time)
+	}
+	logtest.Check(t, got, recs)
+}
+
+func TestInvalid(t *testing.T) {
+	if _, err := new(Reader).Init(bytes.NewReader(bytes.Repeat([]byte("not blf "), 32))).Read(); err != ErrFormat {
+		t.Errorf("got %v, want ErrFormat", err)
+	}
+}
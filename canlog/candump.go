@@ -0,0 +1,102 @@
+package canlog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Writer writes a candump -L log, "(1436509052.249713) can0 123#DEADBEEF" lines.
+type Writer struct {
+	w io.Writer
+}
+
+func (my *Writer) Init(w io.Writer) *Writer {
+	my.w = w
+	return my
+}
+
+// Write writes one line, records without interface get can0.
+func (my *Writer) Write(r *Record) error {
+	_, err := io.WriteString(my.w, FormatLine(r)+"\n")
+	return err
+}
+
+// Reader reads a candump -L log.
+type Reader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func (my *Reader) Init(r io.Reader) *Reader {
+	my.sc = bufio.NewScanner(r)
+	my.line = 0
+	return my
+}
+
+// Read returns the next record, io.EOF at the end of the log. Blank lines are skipped.
+func (my *Reader) Read() (Record, error) {
+	for my.sc.Scan() {
+		my.line++
+		if strings.TrimSpace(my.sc.Text()) == "" {
+			continue
+		}
+		r, err := ParseLine(my.sc.Text())
+		if err != nil {
+			return r, fmt.Errorf("line %d: %w", my.line, err)
+		}
+		return r, nil
+	}
+	if err := my.sc.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+// FormatLine formats a record as a candump -L line, without newline.
+func FormatLine(r *Record) string {
+	ifName := r.Interface
+	if ifName == "" {
+		ifName = "can0"
+	}
+	us := r.Time.UnixMicro()
+	return fmt.Sprintf("(%010d.%06d) %s %s", us/1e6, us%1e6, ifName, r.Frame.String())
+}
+
+// ParseLine parses a candump -L line. Fields after the frame, like the R or T of candump -x, are ignored.
+func ParseLine(line string) (Record, error) {
+	var r Record
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[0], "(") || !strings.HasSuffix(fields[0], ")") {
+		return r, fmt.Errorf("invalid candump line %q", line)
+	}
+	t, err := parseTime(fields[0][1 : len(fields[0])-1])
+	if err != nil {
+		return r, err
+	}
+	r.Time = t
+	r.Interface = fields[1]
+	err = r.Frame.UnmarshalText([]byte(fields[2]))
+	return r, err
+}
+
+// Candump private.
+
+// parseTime parses seconds with a fraction, like 1436509052.249713.
+func parseTime(s string) (time.Time, error) {
+	sec, frac, _ := strings.Cut(s, ".")
+	secs, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil || len(frac) > 9 {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	var ns int64
+	if frac != "" {
+		if ns, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+		}
+	}
+	return time.Unix(secs, ns), nil
+}
@@ -0,0 +1,55 @@
+package canlog_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/canlog"
+	"github.com/lion187chen/socketcan-go/canlog/internal/logtest"
+)
+
+func TestCandumpRoundTrip(t *testing.T) {
+	recs := logtest.Records()
+	recs = append(recs, canlog.Record{Time: recs[0].Time.Add(time.Second), Interface: "vcan3",
+		Frame: canframe.Frame{ID: 0x123, IsXL: true, VCID: 0x45, SDT: 0x03, SEC: true, AF: 0x12345678, Data: bytes.Repeat([]byte{0xAB}, 2048)}})
+	var b bytes.Buffer
+	logtest.WriteAll(t, new(canlog.Writer).Init(&b), recs)
+	// candump -L keeps no direction.
+	for i := range recs {
+		recs[i].Tx = false
+	}
+	logtest.Check(t, logtest.ReadAll(t, new(canlog.Reader).Init(&b)), recs)
+}
+
+func TestCandumpRead(t *testing.T) {
+	const log = `(1436509052.249713) can0 123#DEADBEEF
+(1436509052.250000) vcan1 12345678#R R
+
+(1436509052.5) can0 456##3AABB T
+`
+	want := []canlog.Record{
+		{Time: time.Unix(1436509052, 249713000), Interface: "can0", Frame: canframe.Frame{ID: 0x123, Data: []byte{0xDE, 0xAD, 0xBE, 0xEF}}},
+		{Time: time.Unix(1436509052, 250000000), Interface: "vcan1",
+			Frame: canframe.Frame{ID: 0x12345678, IsExtended: true, IsRemote: true, Data: []byte{}}},
+		{Time: time.Unix(1436509052, 500000000), Interface: "can0",
+			Frame: canframe.Frame{ID: 0x456, IsFD: true, BRS: true, ESI: true, Data: []byte{0xAA, 0xBB}}},
+	}
+	logtest.Check(t, logtest.ReadAll(t, new(canlog.Reader).Init(strings.NewReader(log))), want)
+}
+
+func TestCandumpInvalid(t *testing.T) {
+	for _, line := range []string{
+		"1436509052.249713 can0 123#DEADBEEF",
+		"(1436509052.249713) can0",
+		"(x) can0 123#DEADBEEF",
+		"(1436509052.249713) can0 FFF#11",
+		"(1436509052.249713) can0 123#1",
+	} {
+		if _, err := canlog.ParseLine(line); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
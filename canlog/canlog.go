@@ -0,0 +1,99 @@
+// Package canlog reads and writes CAN bus captures, in the candump -L format of can-utils here,
+// and in the formats of other tools in its subpackages.
+package canlog
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// Record is a logged frame.
+type Record struct {
+	Time time.Time
+	// Interface is the channel the frame was seen on, like can0.
+	Interface string
+	Frame     canframe.Frame
+	// Tx marks frames sent by the logging node, rather than received.
+	Tx bool
+}
+
+// Channel returns the channel number, counted from 1, that formats like ASC use for an interface:
+// its trailing number plus one, can0 is 1, vcan3 is 4. Names without number are channel 1.
+func Channel(ifName string) int {
+	i := len(ifName)
+	for i > 0 && ifName[i-1] >= '0' && ifName[i-1] <= '9' {
+		i--
+	}
+	n, err := strconv.Atoi(ifName[i:])
+	if err != nil {
+		return 1
+	}
+	return n + 1
+}
+
+// ChannelName returns the interface name of a channel number, channel 1 is can0.
+func ChannelName(ch int) string {
+	return "can" + strconv.Itoa(ch-1)
+}
+
+// RecordReader is a log being read, Read returns io.EOF at its end.
+type RecordReader interface {
+	Read() (Record, error)
+}
+
+// RecordWriter is a log being written.
+type RecordWriter interface {
+	Write(r *Record) error
+}
+
+// Logger writes the frames received on a bus to a log.
+type Logger struct {
+	bus    socketcan.Bus
+	ifName string
+	w      RecordWriter
+	stop   chan struct{}
+}
+
+// ifName is the interface name the records get.
+func (my *Logger) Init(bus socketcan.Bus, ifName string, w RecordWriter) *Logger {
+	my.bus = bus
+	my.ifName = ifName
+	my.w = w
+	my.stop = make(chan struct{})
+	return my
+}
+
+// Run will block logging frames until Stop() was called, the bus or the log failed.
+func (my *Logger) Run() error {
+	for {
+		select {
+		case <-my.stop:
+			return nil
+		default:
+		}
+
+		f, err := socketcan.RcvFrameUntil(my.bus, time.Now().Add(logTick))
+		if socketcan.IsTimeout(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := my.w.Write(&Record{Time: time.Now(), Interface: my.ifName, Frame: f}); err != nil {
+			return err
+		}
+	}
+}
+
+// Stop makes Run() return.
+func (my *Logger) Stop() {
+	close(my.stop)
+}
+
+// Logger private.
+
+// logTick is how often Run() looks for Stop().
+const logTick = 50 * time.Millisecond
@@ -0,0 +1,87 @@
+// Package logtest has the records and helpers the tests of the log formats share.
+package logtest
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/canlog"
+)
+
+// Records returns a log of classic, extended, remote, CAN FD and error frames on two interfaces, in both directions.
+// Times are in time.Local, which formats of wall clock times like BLF write.
+func Records() []canlog.Record {
+	t0 := time.Date(2024, 5, 6, 7, 8, 9, 10_000_000, time.Local)
+	return []canlog.Record{
+		{Time: t0, Interface: "can0", Frame: canframe.Frame{ID: 0x123, Data: []byte{0xDE, 0xAD, 0xBE, 0xEF}}},
+		{Time: t0.Add(1500 * time.Microsecond), Interface: "can1", Tx: true,
+			Frame: canframe.Frame{ID: 0x1ABCDEF0, IsExtended: true, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+		{Time: t0.Add(2 * time.Millisecond), Interface: "can0", Frame: canframe.Frame{ID: 0x7FF, IsRemote: true, Data: make([]byte, 3)}},
+		{Time: t0.Add(3 * time.Millisecond), Interface: "can0",
+			Frame: canframe.Frame{ID: 0x456, IsFD: true, BRS: true, Data: bytes.Repeat([]byte{0x55}, 12)}},
+		{Time: t0.Add(4 * time.Millisecond), Interface: "can1", Tx: true,
+			Frame: canframe.Frame{ID: 0x18DAF110, IsExtended: true, IsFD: true, ESI: true, Data: bytes.Repeat([]byte{0xAA}, 64)}},
+		{Time: t0.Add(5 * time.Millisecond), Interface: "can0", Frame: canframe.Frame{ID: 0x4, IsError: true, Data: []byte{0, 8, 0, 0, 0, 0, 0, 0}}},
+	}
+}
+
+// Filter returns the records keep is true for.
+func Filter(recs []canlog.Record, keep func(r *canlog.Record) bool) []canlog.Record {
+	var kept []canlog.Record
+	for i := range recs {
+		if keep(&recs[i]) {
+			kept = append(kept, recs[i])
+		}
+	}
+	return kept
+}
+
+// WriteAll writes recs to w, and closes w if it is an io.Closer.
+func WriteAll(t *testing.T, w canlog.RecordWriter, recs []canlog.Record) {
+	t.Helper()
+	for i := range recs {
+		if err := w.Write(&recs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c, ok := w.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// ReadAll reads r up to io.EOF.
+func ReadAll(t *testing.T, r canlog.RecordReader) []canlog.Record {
+	t.Helper()
+	var recs []canlog.Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// Check compares records read with those written, times by the instant they denote.
+func Check(t *testing.T, got, want []canlog.Record) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("read %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !got[i].Time.Equal(want[i].Time) || got[i].Interface != want[i].Interface || got[i].Tx != want[i].Tx ||
+			!reflect.DeepEqual(got[i].Frame, want[i].Frame) {
+			t.Errorf("record %d: got %v %s %v %+v, want %v %s %v %+v", i,
+				got[i].Time, got[i].Interface, got[i].Tx, got[i].Frame, want[i].Time, want[i].Interface, want[i].Tx, want[i].Frame)
+		}
+	}
+}
@@ -0,0 +1,1111 @@
+// Package mdf reads and writes ASAM MDF 4 files of CAN frames, in the layout of the ASAM bus logging convention:
+// a CAN_DataFrame channel group with a Timestamp master channel and a CAN_DataFrame structure of
+// BusChannel, ID, IDE, DLC, DataLength, DataBytes, Dir, EDL, BRS and ESI.
+package mdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/canlog"
+)
+
+// ErrFormat is returned for data that is no MDF 4 file or broken.
+var ErrFormat = errors.New("mdf: invalid format")
+
+// ErrTooLarge is returned for signal data or compressed blocks beyond the 64 MiB the reader holds in memory.
+var ErrTooLarge = errors.New("mdf: data too large to read into memory")
+
+// Reader reads the CAN_DataFrame channel groups of an MDF 4 file, one data group after the other.
+// Data may be in DT, DL, HL and deflate compressed DZ blocks, DataBytes in fixed arrays or VLSD channels.
+// Other channel groups are skipped. Bus channels become interface names by canlog.ChannelName().
+type Reader struct {
+	r       io.ReadSeeker
+	base    int64
+	size    int64
+	started bool
+	start   time.Time
+	unfin   uint16
+	dgs     []*dataGroup
+	next    int
+	// data is the record stream of the current data group.
+	data *bufio.Reader
+	dg   *dataGroup
+}
+
+// Init needs random access, r starts at the file's beginning.
+func (my *Reader) Init(r io.ReadSeeker) *Reader {
+	my.r = r
+	my.started = false
+	my.dgs = nil
+	my.next = 0
+	my.data = nil
+	return my
+}
+
+// Start returns the measurement start of the file header, known after the first Read().
+func (my *Reader) Start() time.Time {
+	return my.start
+}
+
+// Read returns the next frame, io.EOF at the end of the file.
+func (my *Reader) Read() (canlog.Record, error) {
+	if !my.started {
+		if err := my.open(); err != nil {
+			return canlog.Record{}, err
+		}
+	}
+	for {
+		if my.data == nil {
+			if my.next >= len(my.dgs) {
+				return canlog.Record{}, io.EOF
+			}
+			my.dg = my.dgs[my.next]
+			my.next++
+			frags, err := my.fragments(my.dg.data, "DT")
+			if err != nil {
+				return canlog.Record{}, err
+			}
+			my.data = bufio.NewReader(&stream{my: my, frags: frags})
+		}
+		r, ok, err := my.record()
+		if err == io.EOF {
+			my.data = nil
+			continue
+		}
+		if err != nil || ok {
+			return r, err
+		}
+	}
+}
+
+// Writer writes an MDF 4.10 file of one CAN_DataFrame channel group, with DataBytes of 64 bytes.
+// Remote and error frames are not written, the convention keeps them in groups of their own.
+// The file is finalized on Close() if the underlying writer is an io.WriteSeeker,
+// otherwise it stays marked as unfinalized, which readers like this one and asammdf handle.
+type Writer struct {
+	w       io.Writer
+	bw      *bufio.Writer
+	started bool
+	start   time.Time
+	written int64
+	count   uint64
+	// dt and cg are the offsets of the blocks Close() completes.
+	dt  int64
+	cg  int64
+	rec [recLen]byte
+}
+
+func (my *Writer) Init(w io.Writer) *Writer {
+	my.w = w
+	my.bw = bufio.NewWriter(w)
+	my.started = false
+	my.written = 0
+	my.count = 0
+	return my
+}
+
+// Write adds a data frame, the file's measurement start is the time of the first one.
+// CAN XL frames are not supported, writing one is an error.
+func (my *Writer) Write(r *canlog.Record) error {
+	if r.Frame.IsXL {
+		return errors.New("mdf: no CAN XL frames")
+	}
+	if !my.started {
+		my.begin(r.Time)
+	}
+	f := &r.Frame
+	if f.IsRemote || f.IsError {
+		return nil
+	}
+	rec := my.rec[:]
+	clear(rec)
+	binary.LittleEndian.PutUint64(rec[0:], math.Float64bits(r.Time.Sub(my.start).Seconds()))
+	rec[offBus] = byte(canlog.Channel(r.Interface))
+	id := f.ID & 0x1FFFFFFF
+	if f.IsExtended {
+		id |= 1 << 31
+	} else {
+		id &= 0x7FF
+	}
+	binary.LittleEndian.PutUint32(rec[offID:], id)
+	n := len(f.Data)
+	max := canframe.FRAME_MAX_DATA_LEN
+	if f.IsFD {
+		max = canframe.FD_FRAME_MAX_DATA_LEN
+	}
+	if n > max {
+		n = max
+	}
+	flags := byte(fdDLC(n))
+	if f.IsFD {
+		flags |= edlBit
+	}
+	if f.BRS {
+		flags |= brsBit
+	}
+	if f.ESI {
+		flags |= esiBit
+	}
+	if r.Tx {
+		flags |= dirBit
+	}
+	rec[offFlags] = flags
+	rec[offLen] = byte(n)
+	copy(rec[offData:], f.Data[:n])
+	my.bw.Write(rec)
+	my.written += recLen
+	my.count++
+	return nil
+}
+
+// Close flushes the records and finalizes the file, it does not close the underlying writer.
+func (my *Writer) Close() error {
+	if !my.started {
+		my.begin(time.Now())
+	}
+	if err := my.bw.Flush(); err != nil {
+		return err
+	}
+	ws, ok := my.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	var b [8]byte
+	patch := func(at int64, data []byte) error {
+		if _, err := ws.Seek(at-my.written, io.SeekCurrent); err != nil {
+			return err
+		}
+		if _, err := ws.Write(data); err != nil {
+			return err
+		}
+		_, err := ws.Seek(my.written-at-int64(len(data)), io.SeekCurrent)
+		return err
+	}
+	binary.LittleEndian.PutUint64(b[:], uint64(my.written-my.dt))
+	if err := patch(my.dt+8, b[:]); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(b[:], my.count)
+	if err := patch(my.cg+cgCycleCount, b[:]); err != nil {
+		return err
+	}
+	if err := patch(0, []byte(finId)); err != nil {
+		return err
+	}
+	return patch(idUnfinFlags, []byte{0, 0})
+}
+
+// MDF private.
+
+const (
+	finId   = "MDF     "
+	unfinId = "UnFinMF "
+
+	idLen        = 64
+	idUnfinFlags = 60
+	// Cycle counters and the length of the last DT block need updating.
+	unfinCycles = 0x01
+	unfinDTLen  = 0x04
+
+	blockHeaderLen = 24
+	// cgCycleCount is where cg_cycle_count is in a CG block.
+	cgCycleCount = blockHeaderLen + 6*8 + 8
+
+	cgVLSD = 0x01
+	// Flags of bus event groups.
+	cgBusEvent      = 0x02
+	cgPlainBusEvent = 0x04
+
+	cnFixed  = 0
+	cnVLSD   = 1
+	cnMaster = 2
+	syncTime = 1
+
+	typeUintLE    = 0
+	typeUintBE    = 1
+	typeIntLE     = 2
+	typeIntBE     = 3
+	typeFloatLE   = 4
+	typeFloatBE   = 5
+	typeByteArray = 10
+
+	siBus    = 2
+	busCAN   = 2
+	ccLinear = 1
+
+	dzHeaderLen = 24
+
+	// maxBlock limits the data held in memory: blocks, uncompressed DZ blocks and signal data.
+	maxBlock = 64 << 20
+)
+
+// Record layout of the written group: Timestamp, then the CAN_DataFrame structure.
+const (
+	offBus   = 8
+	offID    = 9
+	offFlags = 13
+	offLen   = 14
+	offData  = 15
+	recLen   = offData + canframe.FD_FRAME_MAX_DATA_LEN
+
+	// Bits of the flags byte after the 4 bit DLC.
+	edlBit = 0x10
+	brsBit = 0x20
+	esiBit = 0x40
+	dirBit = 0x80
+)
+
+var fdLens = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 16, 20, 24, 32, 48, 64}
+
+// fdDLC returns the CAN FD DLC of a payload length.
+func fdDLC(n int) int {
+	for dlc, l := range fdLens {
+		if l >= n {
+			return dlc
+		}
+	}
+	return 15
+}
+
+// blocks builds the blocks before the data, links are set by offset within it.
+type blocks struct {
+	b []byte
+}
+
+// add appends a block of n links and returns its offset.
+func (my *blocks) add(id string, n int, data []byte) int64 {
+	off := int64(len(my.b))
+	l := blockHeaderLen + 8*n + len(data)
+	l = (l + 7) &^ 7
+	hdr := make([]byte, l)
+	copy(hdr, "##"+id)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(l))
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(n))
+	copy(hdr[blockHeaderLen+8*n:], data)
+	my.b = append(my.b, hdr...)
+	return off
+}
+
+func (my *blocks) link(block int64, i int, target int64) {
+	binary.LittleEndian.PutUint64(my.b[block+blockHeaderLen+8*int64(i):], uint64(target))
+}
+
+// text appends a TX, or with id MD, block.
+func (my *blocks) text(id, s string) int64 {
+	return my.add(id, 0, append([]byte(s), 0))
+}
+
+// channel describes a channel to write.
+type channel struct {
+	name     string
+	typ      byte
+	dataType byte
+	byteOff  uint32
+	bitOff   byte
+	bits     uint32
+}
+
+// cn appends a CN block.
+func (my *blocks) cn(c channel, next, composition, unit int64) int64 {
+	data := make([]byte, 72)
+	data[0] = c.typ
+	if c.typ == cnMaster {
+		data[1] = syncTime
+	}
+	data[2] = c.dataType
+	data[3] = c.bitOff
+	binary.LittleEndian.PutUint32(data[4:], c.byteOff)
+	binary.LittleEndian.PutUint32(data[8:], c.bits)
+	off := my.add("CN", 8, data)
+	my.link(off, 0, next)
+	my.link(off, 1, composition)
+	my.link(off, 2, my.text("TX", c.name))
+	my.link(off, 6, unit)
+	return off
+}
+
+// begin writes all blocks but the records, and the header of the DT block they go in.
+func (my *Writer) begin(start time.Time) {
+	my.started = true
+	my.start = start
+	b := &blocks{b: make([]byte, idLen)}
+	copy(b.b, unfinId)
+	copy(b.b[8:], "4.10    ")
+	copy(b.b[16:], "sktcango")
+	binary.LittleEndian.PutUint16(b.b[28:], 410)
+	binary.LittleEndian.PutUint16(b.b[idUnfinFlags:], unfinCycles|unfinDTLen)
+
+	hdData := make([]byte, 32)
+	binary.LittleEndian.PutUint64(hdData[0:], uint64(start.UnixNano()))
+	hd := b.add("HD", 6, hdData)
+	fhData := make([]byte, 16)
+	binary.LittleEndian.PutUint64(fhData[0:], uint64(time.Now().UnixNano()))
+	fh := b.add("FH", 2, fhData)
+	b.link(fh, 1, b.text("MD", "<FHcomment><TX>CAN bus log</TX><tool_id>socketcan-go</tool_id>"+
+		"<tool_vendor>socketcan-go</tool_vendor><tool_version>1.0</tool_version></FHcomment>"))
+	b.link(hd, 1, fh)
+
+	siData := make([]byte, 8)
+	siData[0], siData[1] = siBus, busCAN
+	si := b.add("SI", 3, siData)
+	b.link(si, 0, b.text("TX", "CAN"))
+	b.link(si, 1, b.text("TX", "CAN"))
+
+	members := []channel{
+		{"CAN_DataFrame.BusChannel", cnFixed, typeUintLE, offBus, 0, 8},
+		{"CAN_DataFrame.ID", cnFixed, typeUintLE, offID, 0, 29},
+		{"CAN_DataFrame.IDE", cnFixed, typeUintLE, offID + 3, 7, 1},
+		{"CAN_DataFrame.DLC", cnFixed, typeUintLE, offFlags, 0, 4},
+		{"CAN_DataFrame.EDL", cnFixed, typeUintLE, offFlags, 4, 1},
+		{"CAN_DataFrame.BRS", cnFixed, typeUintLE, offFlags, 5, 1},
+		{"CAN_DataFrame.ESI", cnFixed, typeUintLE, offFlags, 6, 1},
+		{"CAN_DataFrame.Dir", cnFixed, typeUintLE, offFlags, 7, 1},
+		{"CAN_DataFrame.DataLength", cnFixed, typeUintLE, offLen, 0, 8},
+		{"CAN_DataFrame.DataBytes", cnFixed, typeByteArray, offData, 0, 8 * canframe.FD_FRAME_MAX_DATA_LEN},
+	}
+	var next int64
+	for i := len(members) - 1; i >= 0; i-- {
+		next = b.cn(members[i], next, 0, 0)
+	}
+	frame := b.cn(channel{"CAN_DataFrame", cnFixed, typeByteArray, offBus, 0, 8 * (recLen - offBus)}, 0, next, 0)
+	master := b.cn(channel{"Timestamp", cnMaster, typeFloatLE, 0, 0, 64}, frame, 0, b.text("TX", "s"))
+
+	cgData := make([]byte, 32)
+	binary.LittleEndian.PutUint16(cgData[16:], cgBusEvent|cgPlainBusEvent)
+	binary.LittleEndian.PutUint16(cgData[18:], '.')
+	binary.LittleEndian.PutUint32(cgData[24:], recLen)
+	cg := b.add("CG", 6, cgData)
+	b.link(cg, 1, master)
+	b.link(cg, 2, b.text("TX", "CAN_DataFrame"))
+	b.link(cg, 3, si)
+
+	dg := b.add("DG", 4, make([]byte, 8))
+	b.link(dg, 1, cg)
+	b.link(hd, 0, dg)
+	// The DT block's length is that of its header until Close() knows better.
+	dt := b.add("DT", 0, nil)
+	b.link(dg, 2, dt)
+
+	my.cg, my.dt = cg, dt
+	my.bw.Write(b.b)
+	my.written = int64(len(b.b))
+}
+
+// block is a block read, with data blocks' data left out.
+type block struct {
+	id    string
+	len   uint64
+	links []uint64
+	data  []byte
+}
+
+// dataGroup is a data group with CAN_DataFrame channel groups.
+type dataGroup struct {
+	recIDSize int
+	data      uint64
+	cgs       map[uint64]*channelGroup
+}
+
+// channelGroup is a channel group of a data group, frames is nil for other groups than CAN_DataFrame.
+type channelGroup struct {
+	recLen int
+	vlsd   bool
+	// vlsdData collects the records of a VLSD group.
+	vlsdData []byte
+	frames   *frameChannels
+}
+
+// frameChannels are the channels of a CAN_DataFrame group, those missing are nil.
+type frameChannels struct {
+	time, bus, id, ide, dlc, length, bytes, dir, edl, brs, esi *cn
+}
+
+// cn is a channel read.
+type cn struct {
+	typ      byte
+	dataType byte
+	bitOff   uint32
+	byteOff  uint32
+	bits     uint32
+	// a0, a1 are a linear conversion.
+	a0, a1 float64
+	// sd is the signal data of a VLSD channel, or vlsd the VLSD group it is in.
+	sd   []byte
+	vlsd *channelGroup
+}
+
+// open reads the blocks down to the channels.
+func (my *Reader) open() error {
+	my.started = true
+	base, err := my.r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := my.r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	my.base, my.size = base, end-base
+	id := make([]byte, idLen)
+	if err := my.readAt(0, id); err != nil {
+		if my.size == 0 {
+			return io.EOF
+		}
+		return ErrFormat
+	}
+	if (string(id[:8]) != finId && string(id[:8]) != unfinId) || string(id[8:9]) != "4" {
+		return ErrFormat
+	}
+	my.unfin = binary.LittleEndian.Uint16(id[idUnfinFlags:])
+	hd, err := my.block(idLen, "HD")
+	if err != nil {
+		return err
+	}
+	if len(hd.links) < 1 || len(hd.data) < 8 {
+		return ErrFormat
+	}
+	my.start = time.Unix(0, int64(binary.LittleEndian.Uint64(hd.data)))
+	seen := make(map[uint64]bool)
+	for off := hd.links[0]; off != 0; {
+		if seen[off] {
+			return ErrFormat
+		}
+		seen[off] = true
+		dgb, err := my.block(off, "DG")
+		if err != nil {
+			return err
+		}
+		if len(dgb.links) < 3 || len(dgb.data) < 1 {
+			return ErrFormat
+		}
+		dg := &dataGroup{recIDSize: int(dgb.data[0]), data: dgb.links[2], cgs: make(map[uint64]*channelGroup)}
+		frames := false
+		for cgOff := dgb.links[1]; cgOff != 0; {
+			if seen[cgOff] {
+				return ErrFormat
+			}
+			seen[cgOff] = true
+			cgb, err := my.block(cgOff, "CG")
+			if err != nil {
+				return err
+			}
+			if len(cgb.links) < 6 || len(cgb.data) < 32 {
+				return ErrFormat
+			}
+			recID := binary.LittleEndian.Uint64(cgb.data[0:])
+			cg := &channelGroup{
+				recLen: int(binary.LittleEndian.Uint32(cgb.data[24:])) + int(binary.LittleEndian.Uint32(cgb.data[28:])),
+				vlsd:   binary.LittleEndian.Uint16(cgb.data[16:])&cgVLSD != 0,
+			}
+			if cg.recLen > maxBlock {
+				return ErrFormat
+			}
+			// VLSD groups are found by the channels pointing at them.
+			if old, ok := dg.cgs[recID]; ok && old.vlsd {
+				cg = old
+				cg.recLen = int(binary.LittleEndian.Uint32(cgb.data[24:])) + int(binary.LittleEndian.Uint32(cgb.data[28:]))
+			}
+			dg.cgs[recID] = cg
+			if !cg.vlsd {
+				name, err := my.text(cgb.links[2])
+				if err != nil {
+					return err
+				}
+				if cg.frames, err = my.channels(cgb.links[1], name == "CAN_DataFrame", dg); err != nil {
+					return err
+				}
+				frames = frames || cg.frames != nil
+			}
+			cgOff = cgb.links[0]
+		}
+		if frames {
+			my.dgs = append(my.dgs, dg)
+		}
+		off = dgb.links[0]
+	}
+	return nil
+}
+
+// channels finds the channels of a CAN_DataFrame group, nil for other groups.
+func (my *Reader) channels(first uint64, frameGroup bool, dg *dataGroup) (*frameChannels, error) {
+	fc := &frameChannels{}
+	byName := map[string]**cn{
+		"BusChannel": &fc.bus, "ID": &fc.id, "IDE": &fc.ide, "DLC": &fc.dlc, "DataLength": &fc.length,
+		"DataBytes": &fc.bytes, "Dir": &fc.dir, "EDL": &fc.edl, "BRS": &fc.brs, "ESI": &fc.esi,
+	}
+	seen := make(map[uint64]bool)
+	var walk func(off uint64, member bool) error
+	walk = func(off uint64, member bool) error {
+		for off != 0 {
+			if seen[off] {
+				return ErrFormat
+			}
+			seen[off] = true
+			b, err := my.block(off, "CN")
+			if err != nil {
+				return err
+			}
+			if len(b.links) < 8 || len(b.data) < 24 {
+				return ErrFormat
+			}
+			name, err := my.text(b.links[2])
+			if err != nil {
+				return err
+			}
+			c := &cn{
+				typ:      b.data[0],
+				dataType: b.data[2],
+				bitOff:   uint32(b.data[3]),
+				byteOff:  binary.LittleEndian.Uint32(b.data[4:]),
+				bits:     binary.LittleEndian.Uint32(b.data[8:]),
+				a1:       1,
+			}
+			// Members are named with or without their structure.
+			short, ok := strings.CutPrefix(name, "CAN_DataFrame.")
+			switch {
+			case c.typ == cnMaster:
+				fc.time = c
+				if err := my.conversion(b.links[4], c); err != nil {
+					return err
+				}
+			case ok || member:
+				if p, ok := byName[short]; ok {
+					*p = c
+				}
+			}
+			if c.typ == cnVLSD && fc.bytes == c {
+				if err := my.signalData(b.links[5], c, dg); err != nil {
+					return err
+				}
+			}
+			if name == "CAN_DataFrame" {
+				frameGroup = true
+			}
+			if b.links[1] != 0 {
+				if err := walk(b.links[1], member || name == "CAN_DataFrame"); err != nil {
+					return err
+				}
+			}
+			off = b.links[0]
+		}
+		return nil
+	}
+	if err := walk(first, false); err != nil {
+		return nil, err
+	}
+	if !frameGroup || fc.time == nil || fc.id == nil || fc.bytes == nil {
+		return nil, nil
+	}
+	return fc, nil
+}
+
+// conversion reads a linear conversion, others are ignored.
+func (my *Reader) conversion(off uint64, c *cn) error {
+	if off == 0 {
+		return nil
+	}
+	b, err := my.block(off, "CC")
+	if err != nil {
+		return err
+	}
+	if len(b.data) >= 40 && b.data[0] == ccLinear {
+		c.a0 = math.Float64frombits(binary.LittleEndian.Uint64(b.data[24:]))
+		c.a1 = math.Float64frombits(binary.LittleEndian.Uint64(b.data[32:]))
+	}
+	return nil
+}
+
+// signalData reads the SD blocks of a VLSD channel, or finds the VLSD group it points to.
+func (my *Reader) signalData(off uint64, c *cn, dg *dataGroup) error {
+	if off == 0 {
+		return nil
+	}
+	id, err := my.blockID(off)
+	if err != nil {
+		return err
+	}
+	if id == "CG" {
+		b, err := my.block(off, "CG")
+		if err != nil {
+			return err
+		}
+		if len(b.data) < 8 {
+			return ErrFormat
+		}
+		recID := binary.LittleEndian.Uint64(b.data)
+		cg, ok := dg.cgs[recID]
+		if !ok {
+			cg = &channelGroup{vlsd: true}
+			dg.cgs[recID] = cg
+		}
+		c.vlsd = cg
+		return nil
+	}
+	frags, err := my.fragments(off, "SD")
+	if err != nil {
+		return err
+	}
+	if c.sd, err = io.ReadAll(io.LimitReader(&stream{my: my, frags: frags}, maxBlock+1)); err != nil {
+		return err
+	}
+	if len(c.sd) > maxBlock {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// record reads the next record of the current data group, ok tells whether it was a frame.
+func (my *Reader) record() (r canlog.Record, ok bool, err error) {
+	dg := my.dg
+	var recID uint64
+	if dg.recIDSize > 0 {
+		var id [8]byte
+		if dg.recIDSize > 8 {
+			return r, false, ErrFormat
+		}
+		if _, err := io.ReadFull(my.data, id[:dg.recIDSize]); err == io.EOF {
+			return r, false, io.EOF
+		} else if err != nil {
+			return r, false, ErrFormat
+		}
+		recID = binary.LittleEndian.Uint64(id[:])
+	}
+	cg, ok := dg.cgs[recID]
+	if !ok {
+		return r, false, ErrFormat
+	}
+	if cg.vlsd {
+		var n [4]byte
+		if _, err := io.ReadFull(my.data, n[:]); err != nil {
+			return r, false, ErrFormat
+		}
+		l := binary.LittleEndian.Uint32(n[:])
+		if uint64(l)+uint64(len(cg.vlsdData)) > maxBlock {
+			return r, false, ErrTooLarge
+		}
+		cg.vlsdData = append(cg.vlsdData, n[:]...)
+		start := len(cg.vlsdData)
+		cg.vlsdData = append(cg.vlsdData, make([]byte, l)...)
+		if _, err := io.ReadFull(my.data, cg.vlsdData[start:]); err != nil {
+			return r, false, ErrFormat
+		}
+		return r, false, nil
+	}
+	rec := make([]byte, cg.recLen)
+	if _, err := io.ReadFull(my.data, rec); err == io.EOF && dg.recIDSize == 0 {
+		return r, false, io.EOF
+	} else if err != nil {
+		if my.unfin&unfinDTLen != 0 && err == io.ErrUnexpectedEOF {
+			// A record cut off at the end of an unfinalized file.
+			return r, false, io.EOF
+		}
+		return r, false, ErrFormat
+	}
+	if cg.frames == nil {
+		return r, false, nil
+	}
+	return my.frame(cg.frames, rec)
+}
+
+// frame converts a CAN_DataFrame record.
+func (my *Reader) frame(fc *frameChannels, rec []byte) (r canlog.Record, ok bool, err error) {
+	secs, ok := fc.time.float(rec)
+	if !ok {
+		return r, false, ErrFormat
+	}
+	r.Time = my.start.Add(time.Duration(math.Round(secs * 1e9)))
+	r.Interface = canlog.ChannelName(1)
+	if v, ok := fc.bus.uint(rec); ok && v > 0 {
+		r.Interface = canlog.ChannelName(int(v))
+	}
+	f := &r.Frame
+	id, ok := fc.id.uint(rec)
+	if !ok {
+		return r, false, ErrFormat
+	}
+	f.ID = uint32(id) & 0x1FFFFFFF
+	if ide, ok := fc.ide.uint(rec); ok {
+		f.IsExtended = ide != 0
+	} else {
+		// Some loggers keep IDE in the top bit of the ID.
+		f.IsExtended = id&(1<<31) != 0 || f.ID > 0x7FF
+	}
+	dir, _ := fc.dir.uint(rec)
+	r.Tx = dir != 0
+	edl, _ := fc.edl.uint(rec)
+	brs, _ := fc.brs.uint(rec)
+	esi, _ := fc.esi.uint(rec)
+	f.IsFD, f.BRS, f.ESI = edl != 0, brs != 0, esi != 0
+
+	data, ok := fc.bytes.bytes(rec)
+	if !ok {
+		return r, false, ErrFormat
+	}
+	n := len(data)
+	if l, ok := fc.length.uint(rec); ok {
+		n = int(l)
+	} else if dlc, ok := fc.dlc.uint(rec); ok && dlc < 16 {
+		n = fdLens[dlc]
+		if !f.IsFD && n > canframe.FRAME_MAX_DATA_LEN {
+			n = canframe.FRAME_MAX_DATA_LEN
+		}
+	}
+	max := canframe.FRAME_MAX_DATA_LEN
+	if f.IsFD {
+		max = canframe.FD_FRAME_MAX_DATA_LEN
+	}
+	if n > len(data) || n > max {
+		return r, false, ErrFormat
+	}
+	f.Data = append([]byte(nil), data[:n]...)
+	return r, true, nil
+}
+
+// raw returns the bytes of a fixed length value.
+func (c *cn) raw(rec []byte) ([]byte, bool) {
+	n := (uint64(c.bitOff) + uint64(c.bits) + 7) / 8
+	end := uint64(c.byteOff) + n
+	if end > uint64(len(rec)) {
+		return nil, false
+	}
+	return rec[c.byteOff:end], true
+}
+
+// uint returns an integer value, ok is false for missing channels.
+func (c *cn) uint(rec []byte) (uint64, bool) {
+	if c == nil || c.bits == 0 || c.bitOff+c.bits > 64 {
+		return 0, false
+	}
+	b, ok := c.raw(rec)
+	if !ok {
+		return 0, false
+	}
+	var v uint64
+	switch c.dataType {
+	case typeUintLE, typeIntLE:
+		for i := len(b) - 1; i >= 0; i-- {
+			v = v<<8 | uint64(b[i])
+		}
+	case typeUintBE, typeIntBE:
+		for _, d := range b {
+			v = v<<8 | uint64(d)
+		}
+	default:
+		return 0, false
+	}
+	v >>= c.bitOff
+	if c.bits < 64 {
+		v &= 1<<c.bits - 1
+	}
+	return v, true
+}
+
+// float returns a value converted, as master channels have it.
+func (c *cn) float(rec []byte) (float64, bool) {
+	var v float64
+	switch c.dataType {
+	case typeFloatLE, typeFloatBE:
+		b, ok := c.raw(rec)
+		if !ok {
+			return 0, false
+		}
+		var order binary.ByteOrder = binary.LittleEndian
+		if c.dataType == typeFloatBE {
+			order = binary.BigEndian
+		}
+		switch {
+		case c.bits == 64 && len(b) == 8:
+			v = math.Float64frombits(order.Uint64(b))
+		case c.bits == 32 && len(b) == 4:
+			v = float64(math.Float32frombits(order.Uint32(b)))
+		default:
+			return 0, false
+		}
+	case typeIntLE, typeIntBE:
+		u, ok := c.uint(rec)
+		if !ok {
+			return 0, false
+		}
+		// Sign extension.
+		v = float64(int64(u<<(64-c.bits)) >> (64 - c.bits))
+	default:
+		u, ok := c.uint(rec)
+		if !ok {
+			return 0, false
+		}
+		v = float64(u)
+	}
+	return c.a0 + c.a1*v, true
+}
+
+// bytes returns a byte array value, fixed in the record or in signal data.
+func (c *cn) bytes(rec []byte) ([]byte, bool) {
+	if c.typ != cnVLSD {
+		if c.bitOff != 0 || c.bits%8 != 0 {
+			return nil, false
+		}
+		return c.raw(rec)
+	}
+	sd := c.sd
+	if c.vlsd != nil {
+		sd = c.vlsd.vlsdData
+	}
+	off, ok := (&cn{dataType: typeUintLE, byteOff: c.byteOff, bits: 64}).uint(rec)
+	if !ok || off+4 > uint64(len(sd)) {
+		return nil, false
+	}
+	n := uint64(binary.LittleEndian.Uint32(sd[off:]))
+	if off+4+n > uint64(len(sd)) {
+		return nil, false
+	}
+	return sd[off+4 : off+4+n], true
+}
+
+func (my *Reader) readAt(off int64, b []byte) error {
+	if off < 0 || off+int64(len(b)) > my.size {
+		return ErrFormat
+	}
+	if _, err := my.r.Seek(my.base+off, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(my.r, b)
+	return err
+}
+
+func (my *Reader) blockID(off uint64) (string, error) {
+	var hdr [4]byte
+	if err := my.readAt(int64(off), hdr[:]); err != nil || string(hdr[:2]) != "##" {
+		return "", ErrFormat
+	}
+	return string(hdr[2:]), nil
+}
+
+// block reads a block, of the id if not empty. The data of data blocks is left out.
+func (my *Reader) block(off uint64, id string) (*block, error) {
+	hdr := make([]byte, blockHeaderLen)
+	if off > math.MaxInt64 || my.readAt(int64(off), hdr) != nil || string(hdr[:2]) != "##" {
+		return nil, ErrFormat
+	}
+	b := &block{
+		id:  string(hdr[2:4]),
+		len: binary.LittleEndian.Uint64(hdr[8:]),
+	}
+	n := binary.LittleEndian.Uint64(hdr[16:])
+	if (id != "" && b.id != id) || b.len < blockHeaderLen || n > (b.len-blockHeaderLen)/8 {
+		return nil, ErrFormat
+	}
+	size := b.len - blockHeaderLen
+	switch b.id {
+	case "DT", "SD", "RD", "DZ":
+		// Data blocks have no links, DZ blocks a header of 24 bytes before the compressed data.
+		if n != 0 || (b.id == "DZ" && size < dzHeaderLen) {
+			return nil, ErrFormat
+		}
+		size = 0
+		if b.id == "DZ" {
+			size = dzHeaderLen
+		}
+	}
+	if size > maxBlock {
+		return nil, ErrFormat
+	}
+	body := make([]byte, size)
+	if err := my.readAt(int64(off)+blockHeaderLen, body); err != nil {
+		return nil, ErrFormat
+	}
+	b.links = make([]uint64, n)
+	for i := range b.links {
+		b.links[i] = binary.LittleEndian.Uint64(body[8*i:])
+	}
+	b.data = body[8*n:]
+	return b, nil
+}
+
+// text reads a TX block.
+func (my *Reader) text(off uint64) (string, error) {
+	if off == 0 {
+		return "", nil
+	}
+	b, err := my.block(off, "")
+	if err != nil {
+		return "", err
+	}
+	if b.id != "TX" {
+		return "", nil
+	}
+	return string(bytes.TrimRight(b.data, "\x00")), nil
+}
+
+// frag is a data block, of data of the type a list was followed for.
+type frag struct {
+	off uint64
+	b   *block
+}
+
+// fragments follows DL and HL lists to the DT, SD or DZ blocks of data.
+func (my *Reader) fragments(off uint64, typ string) ([]frag, error) {
+	var frags []frag
+	seen := make(map[uint64]bool)
+	var follow func(off uint64) error
+	follow = func(off uint64) error {
+		for off != 0 {
+			if seen[off] {
+				return ErrFormat
+			}
+			seen[off] = true
+			b, err := my.block(off, "")
+			if err != nil {
+				return err
+			}
+			switch b.id {
+			case typ, "DZ":
+				if b.id == "DZ" && string(b.data[:2]) != typ {
+					return ErrFormat
+				}
+				frags = append(frags, frag{off, b})
+				return nil
+			case "HL":
+				if len(b.links) < 1 {
+					return ErrFormat
+				}
+				off = b.links[0]
+			case "DL":
+				if len(b.links) < 1 {
+					return ErrFormat
+				}
+				for _, l := range b.links[1:] {
+					if err := follow(l); err != nil {
+						return err
+					}
+				}
+				off = b.links[0]
+			default:
+				return ErrFormat
+			}
+		}
+		return nil
+	}
+	err := follow(off)
+	return frags, err
+}
+
+// open returns the data of a fragment, and where it is read from in the file, -1 for data in memory.
+func (f *frag) open(my *Reader) (io.Reader, int64, error) {
+	start := int64(f.off) + blockHeaderLen
+	if f.b.id != "DZ" {
+		n := int64(f.b.len) - blockHeaderLen
+		if my.unfin&unfinDTLen != 0 && (n <= 0 || start+n > my.size) {
+			// The last DT block of an unfinalized file goes to its end.
+			n = my.size - start
+		}
+		if n < 0 || start+n > my.size {
+			return nil, 0, ErrFormat
+		}
+		return io.LimitReader(my.r, n), start, nil
+	}
+	zipType := f.b.data[2]
+	param := binary.LittleEndian.Uint32(f.b.data[4:])
+	orgLen := binary.LittleEndian.Uint64(f.b.data[8:])
+	n := binary.LittleEndian.Uint64(f.b.data[16:])
+	if orgLen > maxBlock || n > maxBlock {
+		return nil, 0, ErrTooLarge
+	}
+	z := make([]byte, n)
+	if err := my.readAt(start+dzHeaderLen, z); err != nil {
+		return nil, 0, err
+	}
+	data, err := inflate(z, orgLen)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch zipType {
+	case 0:
+	case 1:
+		data = untranspose(data, int(param))
+	default:
+		return nil, 0, ErrFormat
+	}
+	return bytes.NewReader(data), -1, nil
+}
+
+// inflate decompresses a zlib stream of n bytes.
+func inflate(z []byte, n uint64) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(z))
+	if err != nil {
+		return nil, ErrFormat
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, ErrFormat
+	}
+	return data, nil
+}
+
+// untranspose restores the rows of columns bytes that transposition made columns of.
+func untranspose(data []byte, columns int) []byte {
+	if columns <= 0 {
+		return data
+	}
+	rows := len(data) / columns
+	out := make([]byte, len(data))
+	for c := 0; c < columns; c++ {
+		for r := 0; r < rows; r++ {
+			out[r*columns+c] = data[c*rows+r]
+		}
+	}
+	copy(out[rows*columns:], data[rows*columns:])
+	return out
+}
+
+// stream reads fragments one after the other. The reader seeks to each before reading it.
+type stream struct {
+	my    *Reader
+	frags []frag
+	cur   io.Reader
+	// pos is where the current fragment is read from the file, or -1 for data in memory.
+	pos int64
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	for {
+		if s.cur == nil {
+			if len(s.frags) == 0 {
+				return 0, io.EOF
+			}
+			f := &s.frags[0]
+			s.frags = s.frags[1:]
+			cur, pos, err := f.open(s.my)
+			if err != nil {
+				return 0, err
+			}
+			s.cur, s.pos = cur, pos
+		}
+		if s.pos >= 0 {
+			// Others may have moved the underlying reader.
+			if _, err := s.my.r.Seek(s.my.base+s.pos, io.SeekStart); err != nil {
+				return 0, err
+			}
+		}
+		n, err := s.cur.Read(p)
+		if s.pos >= 0 {
+			s.pos += int64(n)
+		}
+		if err == io.EOF {
+			s.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
@@ -0,0 +1,109 @@
+package mdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lion187chen/socketcan-go/canlog"
+	"github.com/lion187chen/socketcan-go/canlog/internal/logtest"
+)
+
+// dataFrames are the records the writer keeps.
+func dataFrames(recs []canlog.Record) []canlog.Record {
+	return logtest.Filter(recs, func(r *canlog.Record) bool { return !r.Frame.IsRemote && !r.Frame.IsError })
+}
+
+func TestRoundTripUnfinalized(t *testing.T) {
+	recs := logtest.Records()
+	var b bytes.Buffer
+	logtest.WriteAll(t, new(Writer).Init(&b), recs)
+	data := b.Bytes()
+	if string(data[:8]) != unfinId || binary.LittleEndian.Uint16(data[idUnfinFlags:]) == 0 {
+		t.Errorf("file written without seeking is not marked unfinalized")
+	}
+	mr := new(Reader).Init(bytes.NewReader(data))
+	logtest.Check(t, logtest.ReadAll(t, mr), dataFrames(recs))
+	if !mr.Start().Equal(recs[0].Time) {
+		t.Errorf("start %v, want %v", mr.Start(), recs[0].Time)
+	}
+}
+
+func TestRoundTripFinalized(t *testing.T) {
+	recs := logtest.Records()
+	path := filepath.Join(t.TempDir(), "test.mf4")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logtest.WriteAll(t, new(Writer).Init(f), recs)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:8]) != finId || binary.LittleEndian.Uint16(data[idUnfinFlags:]) != 0 {
+		t.Errorf("file is not finalized")
+	}
+	dt := bytes.LastIndex(data, []byte("##DT"))
+	if l := binary.LittleEndian.Uint64(data[dt+8:]); l != uint64(len(data)-dt) {
+		t.Errorf("DT block length %d, want %d", l, len(data)-dt)
+	}
+	cg := bytes.Index(data, []byte("##CG"))
+	if n := binary.LittleEndian.Uint64(data[cg+cgCycleCount:]); n != uint64(len(dataFrames(recs))) {
+		t.Errorf("cycle count %d, want %d", n, len(dataFrames(recs)))
+	}
+	// Blocks start at multiples of 8.
+	for _, id := range []string{"##HD", "##FH", "##DG", "##CG", "##CN", "##SI", "##DT"} {
+		if i := bytes.Index(data, []byte(id)); i%8 != 0 {
+			t.Errorf("%s block at %d", id, i)
+		}
+	}
+	logtest.Check(t, logtest.ReadAll(t, new(Reader).Init(bytes.NewReader(data))), dataFrames(recs))
+}
+
+func TestEmpty(t *testing.T) {
+	var b bytes.Buffer
+	logtest.WriteAll(t, new(Writer).Init(&b), nil)
+	if recs := logtest.ReadAll(t, new(Reader).Init(bytes.NewReader(b.Bytes()))); len(recs) != 0 {
+		t.Errorf("read %d records from an empty file", len(recs))
+	}
+}
+
+// TestBrokenDZ links the data group to DZ blocks with links or a short header.
+func TestBrokenDZ(t *testing.T) {
+	var b bytes.Buffer
+	logtest.WriteAll(t, new(Writer).Init(&b), logtest.Records())
+	data := b.Bytes()
+	dg := int64(bytes.Index(data, []byte("##DG")))
+	for _, tc := range []struct {
+		links int
+		data  []byte
+	}{
+		{4, make([]byte, 64)},
+		{1, make([]byte, 64)},
+		{0, make([]byte, 8)},
+	} {
+		bl := &blocks{b: append([]byte(nil), data[:bytes.LastIndex(data, []byte("##DT"))]...)}
+		dz := bl.add("DZ", tc.links, tc.data)
+		bl.link(dg, 2, dz)
+		if _, err := new(Reader).Init(bytes.NewReader(bl.b)).Read(); err != ErrFormat {
+			t.Errorf("DZ with %d links and %d bytes: got %v, want ErrFormat", tc.links, len(tc.data), err)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	if _, err := new(Reader).Init(bytes.NewReader(make([]byte, 100))).Read(); err != ErrFormat {
+		t.Errorf("got %v, want ErrFormat", err)
+	}
+	if _, err := new(Reader).Init(bytes.NewReader(nil)).Read(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
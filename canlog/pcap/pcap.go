@@ -0,0 +1,631 @@
+// Package pcap reads and writes pcap and pcapng captures of link type LINKTYPE_CAN_SOCKETCAN,
+// the format Wireshark and tcpdump capture CAN interfaces in.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/canlog"
+)
+
+// LINKTYPE_CAN_SOCKETCAN is the link type of SocketCAN frames.
+const LINKTYPE_CAN_SOCKETCAN = 227
+
+// ErrFormat is returned for data that is no pcap or pcapng file or broken.
+var ErrFormat = errors.New("pcap: invalid format")
+
+// Reader reads the CAN, CAN FD and CAN XL frames of a pcap or pcapng file, which one it tells by its first bytes.
+// Packets of other link types are skipped, Skipped() counts them.
+// pcapng frames get the interface names of their interface description blocks, pcap ones are can0.
+type Reader struct {
+	r       *bufio.Reader
+	started bool
+	ng      bool
+	order   binary.ByteOrder
+	// nano marks pcap files with nanosecond timestamps.
+	nano bool
+	link uint32
+	// ifaces are the interfaces of the current pcapng section.
+	ifaces  []iface
+	skipped int
+}
+
+func (my *Reader) Init(r io.Reader) *Reader {
+	my.r = bufio.NewReader(r)
+	my.started = false
+	my.ifaces = nil
+	my.skipped = 0
+	return my
+}
+
+// Skipped returns how many packets of other link types were skipped so far.
+func (my *Reader) Skipped() int {
+	return my.skipped
+}
+
+// Read returns the next frame, io.EOF at the end of the file.
+func (my *Reader) Read() (canlog.Record, error) {
+	if !my.started {
+		if err := my.header(); err != nil {
+			return canlog.Record{}, err
+		}
+	}
+	for {
+		var r canlog.Record
+		var ok bool
+		var err error
+		if my.ng {
+			r, ok, err = my.block()
+		} else {
+			r, ok, err = my.packet()
+		}
+		if err != nil || ok {
+			return r, err
+		}
+	}
+}
+
+// Writer writes a pcap file with nanosecond timestamps, of CAN, CAN FD and CAN XL frames.
+type Writer struct {
+	w       *bufio.Writer
+	started bool
+	buf     []byte
+}
+
+func (my *Writer) Init(w io.Writer) *Writer {
+	my.w = bufio.NewWriter(w)
+	my.started = false
+	return my
+}
+
+// Write writes one record, pcap keeps neither interface nor direction.
+func (my *Writer) Write(r *canlog.Record) error {
+	if !my.started {
+		my.header()
+	}
+	my.buf = appendFrame(my.buf[:0], &r.Frame)
+	var hdr [16]byte
+	ns := r.Time.UnixNano()
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(ns/1e9))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(ns%1e9))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(my.buf)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(my.buf)))
+	my.w.Write(hdr[:])
+	my.w.Write(my.buf)
+	return my.w.Flush()
+}
+
+// Close writes the file header if nothing was written, it does not close the underlying writer.
+func (my *Writer) Close() error {
+	if !my.started {
+		my.header()
+	}
+	return my.w.Flush()
+}
+
+// NgWriter writes a pcapng file with nanosecond timestamps, of CAN, CAN FD and CAN XL frames like Writer.
+// Each interface name gets an interface description block before its first frame, records without interface are can0.
+type NgWriter struct {
+	w       *bufio.Writer
+	started bool
+	ifaces  map[string]uint32
+	buf     []byte
+}
+
+func (my *NgWriter) Init(w io.Writer) *NgWriter {
+	my.w = bufio.NewWriter(w)
+	my.started = false
+	my.ifaces = make(map[string]uint32)
+	return my
+}
+
+// Write writes one record as enhanced packet block, its direction goes to the epb_flags option.
+func (my *NgWriter) Write(r *canlog.Record) error {
+	if !my.started {
+		my.header()
+	}
+	ifName := r.Interface
+	if ifName == "" {
+		ifName = "can0"
+	}
+	id, ok := my.ifaces[ifName]
+	if !ok {
+		id = uint32(len(my.ifaces))
+		my.ifaces[ifName] = id
+		my.iface(ifName)
+	}
+
+	frame := appendFrame(nil, &r.Frame)
+	ns := uint64(r.Time.UnixNano())
+	b := my.buf[:0]
+	b = binary.LittleEndian.AppendUint32(b, id)
+	b = binary.LittleEndian.AppendUint32(b, uint32(ns>>32))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ns))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(frame)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(frame)))
+	b = pad(append(b, frame...))
+	flags := uint32(epbInbound)
+	if r.Tx {
+		flags = epbOutbound
+	}
+	b = appendOption(b, optEpbFlags, binary.LittleEndian.AppendUint32(nil, flags))
+	b = appendOption(b, optEnd, nil)
+	my.buf = b
+	my.block(blockEPB, b)
+	return my.w.Flush()
+}
+
+// Close writes the section header if nothing was written, it does not close the underlying writer.
+func (my *NgWriter) Close() error {
+	if !my.started {
+		my.header()
+	}
+	return my.w.Flush()
+}
+
+// pcap private.
+
+// SocketCAN frame layout: can_id, len, flags, reserved, len8_dlc, data.
+const (
+	canHeaderLen = 8
+	canMTU       = canHeaderLen + canframe.FRAME_MAX_DATA_LEN
+	canFDMTU     = canHeaderLen + canframe.FD_FRAME_MAX_DATA_LEN
+)
+
+// SocketCAN ID and flag bits.
+const (
+	canEFFFlag = 0x80000000
+	canRTRFlag = 0x40000000
+	canERRFlag = 0x20000000
+	canEFFMask = 0x1FFFFFFF
+	canSFFMask = 0x7FF
+
+	canFDBRS = 0x01
+	canFDESI = 0x02
+	canFDFDF = 0x04
+	// canXLXLF marks CAN XL frames in the byte classic and FD frames have their length in.
+	canXLXLF = 0x80
+	canXLSEC = 0x01
+)
+
+// SocketCAN CAN XL frame layout: prio with the VCID, flags, sdt, len, af, data.
+const (
+	canXLHeaderLen = 12
+	canXLVCIDShift = 16
+)
+
+const (
+	pcapMagic     = 0xA1B2C3D4
+	pcapNanoMagic = 0xA1B23C4D
+)
+
+// pcapng block types, option codes and values.
+const (
+	blockSHB = 0x0A0D0D0A
+	blockIDB = 0x00000001
+	blockSPB = 0x00000003
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	optEnd       = 0
+	optIfName    = 2
+	optIfTsresol = 9
+	optEpbFlags  = 2
+
+	epbInbound  = 1
+	epbOutbound = 2
+)
+
+// snapLen is large enough for any SocketCAN frame.
+const snapLen = 0xFFFF
+
+// maxBlock limits what is read of packets and blocks of broken files.
+const maxBlock = 16 << 20
+
+// iface is a pcapng interface.
+type iface struct {
+	name string
+	link uint16
+	// units are timestamp units per second.
+	units uint64
+}
+
+// header reads the file header or section header block.
+func (my *Reader) header() error {
+	magic, err := my.r.Peek(4)
+	if err == io.EOF {
+		return io.EOF
+	} else if err != nil {
+		return ErrFormat
+	}
+	my.started = true
+	if binary.LittleEndian.Uint32(magic) == blockSHB {
+		// The first block sets the byte order.
+		my.ng = true
+		return nil
+	}
+	var hdr [24]byte
+	if _, err := io.ReadFull(my.r, hdr[:]); err != nil {
+		return ErrFormat
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr[0:]) {
+		case pcapMagic, pcapNanoMagic:
+			my.order = order
+			my.nano = order.Uint32(hdr[0:]) == pcapNanoMagic
+			my.link = order.Uint32(hdr[20:]) & 0x0FFFFFFF
+			return nil
+		}
+	}
+	return ErrFormat
+}
+
+// packet reads a pcap packet, ok tells whether it was a frame.
+func (my *Reader) packet() (r canlog.Record, ok bool, err error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(my.r, hdr[:]); err == io.EOF {
+		return r, false, io.EOF
+	} else if err != nil {
+		return r, false, ErrFormat
+	}
+	caplen := my.order.Uint32(hdr[8:])
+	if caplen > maxBlock {
+		return r, false, ErrFormat
+	}
+	data := make([]byte, caplen)
+	if _, err := io.ReadFull(my.r, data); err != nil {
+		return r, false, ErrFormat
+	}
+	if my.link != LINKTYPE_CAN_SOCKETCAN {
+		my.skipped++
+		return r, false, nil
+	}
+	frac := time.Duration(my.order.Uint32(hdr[4:]))
+	if !my.nano {
+		frac *= time.Microsecond
+	}
+	r.Time = time.Unix(int64(my.order.Uint32(hdr[0:])), int64(frac))
+	r.Interface = "can0"
+	err = parseFrame(&r.Frame, data)
+	return r, err == nil, err
+}
+
+// block reads a pcapng block, ok tells whether it was a frame.
+func (my *Reader) block() (r canlog.Record, ok bool, err error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(my.r, hdr[:]); err == io.EOF {
+		return r, false, io.EOF
+	} else if err != nil {
+		return r, false, ErrFormat
+	}
+	typ := binary.LittleEndian.Uint32(hdr[0:])
+	if typ == blockSHB {
+		// A new section, its interfaces start over.
+		var bom [4]byte
+		if _, err := io.ReadFull(my.r, bom[:]); err != nil {
+			return r, false, ErrFormat
+		}
+		switch {
+		case binary.LittleEndian.Uint32(bom[:]) == byteOrderMagic:
+			my.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(bom[:]) == byteOrderMagic:
+			my.order = binary.BigEndian
+		default:
+			return r, false, ErrFormat
+		}
+		my.ifaces = my.ifaces[:0]
+		n := my.order.Uint32(hdr[4:])
+		if n < 16 || n%4 != 0 {
+			return r, false, ErrFormat
+		}
+		_, err := my.r.Discard(int(n) - 12)
+		if err != nil {
+			return r, false, ErrFormat
+		}
+		return r, false, nil
+	}
+	n := my.order.Uint32(hdr[4:])
+	if n < 12 || n%4 != 0 || n > maxBlock {
+		return r, false, ErrFormat
+	}
+	body := make([]byte, n-8)
+	if _, err := io.ReadFull(my.r, body); err != nil {
+		return r, false, ErrFormat
+	}
+	body = body[:len(body)-4]
+
+	switch typ {
+	case blockIDB:
+		if len(body) < 8 {
+			return r, false, ErrFormat
+		}
+		ifc := iface{link: my.order.Uint16(body[0:]), units: 1e6}
+		my.options(body[8:], func(code uint16, val []byte) {
+			switch code {
+			case optIfName:
+				ifc.name = string(trimNUL(val))
+			case optIfTsresol:
+				if len(val) > 0 {
+					ifc.units = tsUnits(val[0])
+				}
+			}
+		})
+		if ifc.name == "" {
+			ifc.name = canlog.ChannelName(len(my.ifaces) + 1)
+		}
+		my.ifaces = append(my.ifaces, ifc)
+		return r, false, nil
+	case blockEPB:
+		if len(body) < 20 {
+			return r, false, ErrFormat
+		}
+		id := my.order.Uint32(body[0:])
+		if id >= uint32(len(my.ifaces)) {
+			return r, false, ErrFormat
+		}
+		ifc := &my.ifaces[id]
+		caplen := my.order.Uint32(body[12:])
+		if uint64(caplen) > uint64(len(body)-20) {
+			return r, false, ErrFormat
+		}
+		if ifc.link != LINKTYPE_CAN_SOCKETCAN {
+			my.skipped++
+			return r, false, nil
+		}
+		ts := uint64(my.order.Uint32(body[4:]))<<32 | uint64(my.order.Uint32(body[8:]))
+		r.Time = ifc.time(ts)
+		r.Interface = ifc.name
+		var opts []byte
+		if off := 20 + (caplen+3)&^3; off <= uint32(len(body)) {
+			opts = body[off:]
+		}
+		my.options(opts, func(code uint16, val []byte) {
+			if code == optEpbFlags && len(val) >= 4 {
+				r.Tx = my.order.Uint32(val)&3 == epbOutbound
+			}
+		})
+		err = parseFrame(&r.Frame, body[20:20+caplen])
+		return r, err == nil, err
+	case blockSPB:
+		// Simple packets are of the first interface and have no timestamp.
+		if len(body) < 4 || len(my.ifaces) == 0 {
+			return r, false, ErrFormat
+		}
+		ifc := &my.ifaces[0]
+		caplen := my.order.Uint32(body[0:])
+		if max := uint32(len(body) - 4); caplen > max {
+			caplen = max
+		}
+		if ifc.link != LINKTYPE_CAN_SOCKETCAN {
+			my.skipped++
+			return r, false, nil
+		}
+		r.Interface = ifc.name
+		err = parseFrame(&r.Frame, body[4:4+caplen])
+		return r, err == nil, err
+	}
+	// Statistics, name resolution and other blocks.
+	return r, false, nil
+}
+
+// options calls fn for each option of a block.
+func (my *Reader) options(b []byte, fn func(code uint16, val []byte)) {
+	for len(b) >= 4 {
+		code, n := my.order.Uint16(b[0:]), int(my.order.Uint16(b[2:]))
+		if code == optEnd || 4+n > len(b) {
+			return
+		}
+		fn(code, b[4:4+n])
+		next := 4 + (n+3)&^3
+		if next > len(b) {
+			return
+		}
+		b = b[next:]
+	}
+}
+
+// time converts a timestamp in the interface's units.
+func (ifc *iface) time(ts uint64) time.Time {
+	secs, rem := ts/ifc.units, ts%ifc.units
+	hi, lo := bits.Mul64(rem, 1e9)
+	ns, _ := bits.Div64(hi, lo, ifc.units)
+	return time.Unix(int64(secs), int64(ns))
+}
+
+// tsUnits returns the units per second of an if_tsresol value, a negative power of 10, or of 2 if the top bit is set.
+func tsUnits(res byte) uint64 {
+	exp := res & 0x7F
+	if res&0x80 != 0 {
+		if exp > 63 {
+			exp = 63
+		}
+		return 1 << exp
+	}
+	units := uint64(1)
+	for i := byte(0); i < exp && i < 19; i++ {
+		units *= 10
+	}
+	return units
+}
+
+func trimNUL(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// parseFrame converts a SocketCAN frame.
+func parseFrame(f *canframe.Frame, b []byte) error {
+	if len(b) < canHeaderLen {
+		return ErrFormat
+	}
+	if b[4]&canXLXLF != 0 {
+		return parseXLFrame(f, b)
+	}
+	id := binary.BigEndian.Uint32(b[0:])
+	n := int(b[4])
+	f.IsExtended = id&canEFFFlag != 0
+	f.IsRemote = id&canRTRFlag != 0
+	f.IsError = id&canERRFlag != 0
+	if f.IsExtended || f.IsError {
+		f.ID = id & canEFFMask
+	} else {
+		f.ID = id & canSFFMask
+	}
+	f.IsFD = b[5]&canFDFDF != 0 || len(b) == canFDMTU
+	if f.IsFD {
+		f.BRS, f.ESI = b[5]&canFDBRS != 0, b[5]&canFDESI != 0
+		if n > canframe.FD_FRAME_MAX_DATA_LEN {
+			return ErrFormat
+		}
+	} else if n > canframe.FRAME_MAX_DATA_LEN {
+		return ErrFormat
+	}
+	if f.IsRemote {
+		f.Data = make([]byte, n)
+		return nil
+	}
+	if canHeaderLen+n > len(b) {
+		return ErrFormat
+	}
+	f.Data = append([]byte(nil), b[canHeaderLen:canHeaderLen+n]...)
+	return nil
+}
+
+// parseXLFrame converts a SocketCAN CAN XL frame, whose header fields are little endian.
+func parseXLFrame(f *canframe.Frame, b []byte) error {
+	if len(b) < canXLHeaderLen {
+		return ErrFormat
+	}
+	prio := binary.LittleEndian.Uint32(b[0:])
+	n := int(binary.LittleEndian.Uint16(b[6:]))
+	if n < 1 || n > canframe.XL_FRAME_MAX_DATA_LEN || canXLHeaderLen+n > len(b) {
+		return ErrFormat
+	}
+	f.IsXL = true
+	f.ID = prio & canSFFMask
+	f.VCID = uint8(prio >> canXLVCIDShift)
+	f.SEC = b[4]&canXLSEC != 0
+	f.SDT = b[5]
+	f.AF = binary.LittleEndian.Uint32(b[8:])
+	f.Data = append([]byte(nil), b[canXLHeaderLen:canXLHeaderLen+n]...)
+	return nil
+}
+
+// appendFrame appends a frame as it is on a SocketCAN socket, 16 bytes classic, 72 bytes FD and 12 plus its data XL.
+func appendFrame(b []byte, f *canframe.Frame) []byte {
+	if f.IsXL {
+		flags := byte(canXLXLF)
+		if f.SEC {
+			flags |= canXLSEC
+		}
+		n := len(f.Data)
+		if n > canframe.XL_FRAME_MAX_DATA_LEN {
+			n = canframe.XL_FRAME_MAX_DATA_LEN
+		}
+		b = binary.LittleEndian.AppendUint32(b, f.ID&canSFFMask|uint32(f.VCID)<<canXLVCIDShift)
+		b = append(b, flags, f.SDT)
+		b = binary.LittleEndian.AppendUint16(b, uint16(n))
+		b = binary.LittleEndian.AppendUint32(b, f.AF)
+		return append(b, f.Data[:n]...)
+	}
+	id := f.ID & canSFFMask
+	if f.IsExtended {
+		id = f.ID&canEFFMask | canEFFFlag
+	}
+	if f.IsRemote {
+		id |= canRTRFlag
+	}
+	if f.IsError {
+		id = f.ID&canEFFMask | canERRFlag
+	}
+	mtu, max := canMTU, canframe.FRAME_MAX_DATA_LEN
+	var flags byte
+	if f.IsFD {
+		mtu, max, flags = canFDMTU, canframe.FD_FRAME_MAX_DATA_LEN, canFDFDF
+		if f.BRS {
+			flags |= canFDBRS
+		}
+		if f.ESI {
+			flags |= canFDESI
+		}
+	}
+	n := len(f.Data)
+	if n > max {
+		n = max
+	}
+	start := len(b)
+	b = binary.BigEndian.AppendUint32(b, id)
+	b = append(b, byte(n), flags, 0, 0)
+	if !f.IsRemote {
+		b = append(b, f.Data[:n]...)
+	}
+	return append(b, make([]byte, start+mtu-len(b))...)
+}
+
+func (my *Writer) header() {
+	my.started = true
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], pcapNanoMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], snapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], LINKTYPE_CAN_SOCKETCAN)
+	my.w.Write(hdr[:])
+}
+
+// header writes the section header block, of unknown section length.
+func (my *NgWriter) header() {
+	my.started = true
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, byteOrderMagic)
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint64(b, 0xFFFFFFFFFFFFFFFF)
+	my.block(blockSHB, b)
+}
+
+// iface writes the interface description block of an interface, with nanosecond resolution.
+func (my *NgWriter) iface(name string) {
+	var b []byte
+	b = binary.LittleEndian.AppendUint16(b, LINKTYPE_CAN_SOCKETCAN)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, snapLen)
+	b = appendOption(b, optIfName, []byte(name))
+	b = appendOption(b, optIfTsresol, []byte{9})
+	b = appendOption(b, optEnd, nil)
+	my.block(blockIDB, b)
+}
+
+// block writes a block around its body, which is padded to 4 bytes.
+func (my *NgWriter) block(typ uint32, body []byte) {
+	var hdr [8]byte
+	n := uint32(12 + len(body))
+	binary.LittleEndian.PutUint32(hdr[0:], typ)
+	binary.LittleEndian.PutUint32(hdr[4:], n)
+	my.w.Write(hdr[:])
+	my.w.Write(body)
+	my.w.Write(hdr[4:])
+}
+
+func appendOption(b []byte, code uint16, val []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(val)))
+	return pad(append(b, val...))
+}
+
+// pad pads to 4 bytes.
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
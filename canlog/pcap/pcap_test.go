@@ -0,0 +1,129 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/canlog"
+	"github.com/lion187chen/socketcan-go/canlog/internal/logtest"
+)
+
+// records are the shared ones and CAN XL frames.
+func records() []canlog.Record {
+	recs := logtest.Records()
+	t := recs[len(recs)-1].Time
+	return append(recs,
+		canlog.Record{Time: t.Add(time.Millisecond), Interface: "can1",
+			Frame: canframe.Frame{ID: 0x123, IsXL: true, VCID: 0x45, SDT: 0x03, SEC: true, AF: 0x12345678, Data: bytes.Repeat([]byte{0xAB}, 2048)}},
+		canlog.Record{Time: t.Add(2 * time.Millisecond), Interface: "can0", Tx: true,
+			Frame: canframe.Frame{ID: 0x7FF, IsXL: true, Data: []byte{1}}})
+}
+
+func TestRoundTripPcap(t *testing.T) {
+	recs := records()
+	var b bytes.Buffer
+	logtest.WriteAll(t, new(Writer).Init(&b), recs)
+	// pcap keeps neither interface nor direction.
+	for i := range recs {
+		recs[i].Interface, recs[i].Tx = "can0", false
+	}
+	r := new(Reader).Init(&b)
+	logtest.Check(t, logtest.ReadAll(t, r), recs)
+	if r.Skipped() != 0 {
+		t.Errorf("skipped %d packets", r.Skipped())
+	}
+}
+
+func TestRoundTripPcapng(t *testing.T) {
+	recs := records()
+	var b bytes.Buffer
+	logtest.WriteAll(t, new(NgWriter).Init(&b), recs)
+	logtest.Check(t, logtest.ReadAll(t, new(Reader).Init(&b)), recs)
+}
+
+func TestEmpty(t *testing.T) {
+	for name, w := range map[string]func(b *bytes.Buffer) canlog.RecordWriter{
+		"pcap":   func(b *bytes.Buffer) canlog.RecordWriter { return new(Writer).Init(b) },
+		"pcapng": func(b *bytes.Buffer) canlog.RecordWriter { return new(NgWriter).Init(b) },
+	} {
+		var b bytes.Buffer
+		logtest.WriteAll(t, w(&b), nil)
+		if recs := logtest.ReadAll(t, new(Reader).Init(&b)); len(recs) != 0 {
+			t.Errorf("%s: read %d records from an empty file", name, len(recs))
+		}
+	}
+}
+
+// TestReadBigEndian reads a big endian pcap file of microsecond timestamps, like tcpdump writes on such hosts.
+func TestReadBigEndian(t *testing.T) {
+	var b []byte
+	b = binary.BigEndian.AppendUint32(b, pcapMagic)
+	b = binary.BigEndian.AppendUint16(b, 2)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = binary.BigEndian.AppendUint32(b, snapLen)
+	b = binary.BigEndian.AppendUint32(b, LINKTYPE_CAN_SOCKETCAN)
+	packet := func(sec, usec uint32, data []byte) {
+		b = binary.BigEndian.AppendUint32(b, sec)
+		b = binary.BigEndian.AppendUint32(b, usec)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+		b = append(b, data...)
+	}
+	// The CAN ID is big endian, the CAN XL header little endian whatever the file's byte order.
+	packet(1700000000, 250, []byte{0x80, 0, 0x01, 0x23, 2, 0, 0, 0, 0xAA, 0xBB, 0, 0, 0, 0, 0, 0})
+	packet(1700000001, 0, []byte{0x23, 0x01, 0x45, 0, 0x81, 0x03, 2, 0, 0x78, 0x56, 0x34, 0x12, 0xCC, 0xDD})
+
+	want := []canlog.Record{
+		{Time: time.Unix(1700000000, 250_000), Interface: "can0",
+			Frame: canframe.Frame{ID: 0x123, IsExtended: true, Data: []byte{0xAA, 0xBB}}},
+		{Time: time.Unix(1700000001, 0), Interface: "can0",
+			Frame: canframe.Frame{ID: 0x123, IsXL: true, VCID: 0x45, SDT: 0x03, SEC: true, AF: 0x12345678, Data: []byte{0xCC, 0xDD}}},
+	}
+	logtest.Check(t, logtest.ReadAll(t, new(Reader).Init(bytes.NewReader(b))), want)
+}
+
+func TestWriteXL(t *testing.T) {
+	f := canframe.Frame{ID: 0x123, IsXL: true, VCID: 0x45, SDT: 0x03, SEC: true, AF: 0x12345678, Data: []byte{0xCC, 0xDD}}
+	want := []byte{0x23, 0x01, 0x45, 0, 0x81, 0x03, 2, 0, 0x78, 0x56, 0x34, 0x12, 0xCC, 0xDD}
+	if got := appendFrame(nil, &f); !reflect.DeepEqual(got, want) {
+		t.Errorf("got % X, want % X", got, want)
+	}
+}
+
+func TestSkipped(t *testing.T) {
+	var b bytes.Buffer
+	logtest.WriteAll(t, new(Writer).Init(&b), records()[:1])
+	data := b.Bytes()
+	// Ethernet.
+	binary.LittleEndian.PutUint32(data[20:], 1)
+	r := new(Reader).Init(bytes.NewReader(data))
+	if recs := logtest.ReadAll(t, r); len(recs) != 0 || r.Skipped() != 1 {
+		t.Errorf("read %d records, skipped %d, want 0 and 1", len(recs), r.Skipped())
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	var b bytes.Buffer
+	logtest.WriteAll(t, new(Writer).Init(&b), records()[6:7])
+	// The CAN XL length after file and packet header.
+	xlLen := 24 + 16 + 6
+	tooLong := bytes.Clone(b.Bytes())
+	binary.LittleEndian.PutUint16(tooLong[xlLen:], canframe.XL_FRAME_MAX_DATA_LEN+1)
+	empty := bytes.Clone(b.Bytes())
+	binary.LittleEndian.PutUint16(empty[xlLen:], 0)
+	for name, data := range map[string][]byte{
+		"no capture":      bytes.Repeat([]byte("not pcap "), 8),
+		"truncated":       b.Bytes()[:b.Len()-100],
+		"CAN XL too long": tooLong,
+		"CAN XL empty":    empty,
+	} {
+		if _, err := new(Reader).Init(bytes.NewReader(data)).Read(); err != ErrFormat {
+			t.Errorf("%s: got %v, want ErrFormat", name, err)
+		}
+	}
+}
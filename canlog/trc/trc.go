@@ -0,0 +1,462 @@
+// Package trc reads and writes PEAK TRC trace files.
+package trc
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/canlog"
+)
+
+// Version of the file format.
+type Version int
+
+const (
+	V1_0 Version = iota
+	V1_1
+	V2_0
+	V2_1
+)
+
+func (v Version) String() string {
+	return [...]string{"1.0", "1.1", "2.0", "2.1"}[v]
+}
+
+// Reader reads the frames of a TRC file, version 1.0 to 2.1 as its header tells.
+// Status and event records are skipped. Bus numbers become interface names by canlog.ChannelName().
+type Reader struct {
+	sc      *bufio.Scanner
+	line    int
+	version Version
+	start   time.Time
+	// columns are the column indexes of version 2 files by letter.
+	columns map[byte]int
+}
+
+func (my *Reader) Init(r io.Reader) *Reader {
+	my.sc = bufio.NewScanner(r)
+	my.line = 0
+	my.version = V1_0
+	my.start = time.Unix(0, 0)
+	my.columns = columns(defaultColumns)
+	return my
+}
+
+// Version returns the file version, known after the first Read().
+func (my *Reader) Version() Version {
+	return my.version
+}
+
+// Read returns the next frame, io.EOF at the end of the file.
+func (my *Reader) Read() (canlog.Record, error) {
+	for my.sc.Scan() {
+		my.line++
+		line := strings.TrimSpace(my.sc.Text())
+		if strings.HasPrefix(line, ";") {
+			if err := my.header(line); err != nil {
+				return canlog.Record{}, fmt.Errorf("trc: line %d: %w", my.line, err)
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		var r canlog.Record
+		var ok bool
+		var err error
+		if my.version >= V2_0 {
+			r, ok, err = my.parse2(strings.Fields(line))
+		} else {
+			r, ok, err = my.parse1(strings.Fields(line))
+		}
+		if err != nil {
+			return r, fmt.Errorf("trc: line %d: %w", my.line, err)
+		}
+		if ok {
+			return r, nil
+		}
+	}
+	if err := my.sc.Err(); err != nil {
+		return canlog.Record{}, err
+	}
+	return canlog.Record{}, io.EOF
+}
+
+// Writer writes a TRC file.
+type Writer struct {
+	w       *bufio.Writer
+	version Version
+	started bool
+	n       int
+	// Start is the time offsets count from, the time of the first record if zero.
+	Start time.Time
+}
+
+// version is V1_1, V2_0 or V2_1, V1_0 writes V1_1. CAN FD frames need V2_0 or later.
+func (my *Writer) Init(w io.Writer, version Version) *Writer {
+	if version == V1_0 {
+		version = V1_1
+	}
+	my.w = bufio.NewWriter(w)
+	my.version = version
+	my.started = false
+	my.n = 0
+	return my
+}
+
+// Write writes one record. Version 1.1 and 2.0 files have no bus column, all frames go there as one bus.
+// Version 1.1 files have no CAN FD frames and no version has CAN XL frames, writing one is an error.
+func (my *Writer) Write(r *canlog.Record) error {
+	if r.Frame.IsXL {
+		return fmt.Errorf("trc: no CAN XL frames")
+	}
+	if my.version < V2_0 && (r.Frame.IsFD || len(r.Frame.Data) > canframe.FRAME_MAX_DATA_LEN) {
+		return fmt.Errorf("trc: no CAN FD frames in version %s", my.version)
+	}
+	if !my.started {
+		if my.Start.IsZero() {
+			my.Start = r.Time
+		}
+		my.header()
+	}
+	my.n++
+	f := &r.Frame
+	ms := float64(r.Time.Sub(my.Start)) / float64(time.Millisecond)
+	dir := "Rx"
+	if r.Tx {
+		dir = "Tx"
+	}
+	id := fmt.Sprintf("%04X", f.ID)
+	if f.IsExtended || f.IsError {
+		id = fmt.Sprintf("%08X", f.ID)
+	}
+	data := strings.TrimSpace(strings.ToUpper(hexBytes(f.Data)))
+	if f.IsRemote {
+		data = ""
+	}
+
+	if my.version < V2_0 {
+		typ := dir
+		if f.IsError {
+			typ = "Error"
+		}
+		if f.IsRemote {
+			data = "RTR"
+		}
+		my.line(fmt.Sprintf("%6d)%12.1f  %-5s %8s  %d  %s", my.n, ms, typ, id, len(f.Data), data))
+		return my.w.Flush()
+	}
+
+	typ := "DT"
+	switch {
+	case f.IsError:
+		typ = "ER"
+	case f.IsRemote:
+		typ = "RR"
+	case f.IsFD && f.BRS && f.ESI:
+		typ = "BI"
+	case f.IsFD && f.BRS:
+		typ = "FB"
+	case f.IsFD && f.ESI:
+		typ = "FE"
+	case f.IsFD:
+		typ = "FD"
+	}
+	if my.version == V2_0 {
+		my.line(fmt.Sprintf("%7d %13.3f %s %8s %s %2d %s", my.n, ms, typ, id, dir, len(f.Data), data))
+	} else {
+		my.line(fmt.Sprintf("%7d %13.3f %s %2d %8s %s - %2d %s", my.n, ms, typ, canlog.Channel(r.Interface), id, dir, dlc(len(f.Data)), data))
+	}
+	return my.w.Flush()
+}
+
+// TRC private.
+
+// defaultColumns are those of version 2.0, 2.1 files list theirs in $COLUMNS.
+const defaultColumns = "N,O,T,I,d,l,D"
+
+// oleEpoch is the date the days of $STARTTIME count from, in local wall clock time.
+var oleEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// oleDigits are the fraction digits of $STARTTIME that are converted exactly, 12 resolve 86.4ns.
+// A float64 of days holds today's dates only to about a microsecond.
+const oleDigits = 12
+
+// oleDays formats the local time of t as days since oleEpoch.
+func oleDays(t time.Time) string {
+	t = t.In(time.Local)
+	y, m, d := t.Date()
+	days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(oleEpoch) / (24 * time.Hour)
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	day := int64(24 * time.Hour / time.Nanosecond)
+	frac := (int64(clock)*1000 + day/2e9) / (day / 1e9)
+	return fmt.Sprintf("%d.%0*d", days, oleDigits, frac)
+}
+
+// oleTime parses days since oleEpoch as local time, rounded to the microsecond.
+func oleTime(s string) (time.Time, error) {
+	days, frac, _ := strings.Cut(s, ".")
+	d, err := strconv.ParseUint(days, 10, 31)
+	if err != nil {
+		return time.Time{}, err
+	}
+	frac = (frac + strings.Repeat("0", oleDigits))[:oleDigits]
+	f, err := strconv.ParseUint(frac, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	clock := (time.Duration(f) * (24 * time.Hour / 1e9) / 1000).Round(time.Microsecond)
+	y, m, day := oleEpoch.Date()
+	return time.Date(y, m, day+int(d), 0, 0, int(clock/time.Second), int(clock%time.Second), time.Local), nil
+}
+
+var fdLens = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 16, 20, 24, 32, 48, 64}
+
+func columns(s string) map[byte]int {
+	c := make(map[byte]int)
+	for i, col := range strings.Split(s, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			c[col[0]] = i
+		}
+	}
+	return c
+}
+
+// header handles the ;$ lines, other comments are ignored.
+func (my *Reader) header(line string) error {
+	key, val, ok := strings.Cut(strings.TrimPrefix(line, ";$"), "=")
+	if !strings.HasPrefix(line, ";$") || !ok {
+		return nil
+	}
+	switch key {
+	case "FILEVERSION":
+		switch val {
+		case "1.0":
+			my.version = V1_0
+		case "1.1":
+			my.version = V1_1
+		case "2.0":
+			my.version = V2_0
+		case "2.1":
+			my.version = V2_1
+		default:
+			return fmt.Errorf("unsupported version %s", val)
+		}
+	case "STARTTIME":
+		start, err := oleTime(val)
+		if err != nil {
+			return fmt.Errorf("invalid start time %q", val)
+		}
+		my.start = start
+	case "COLUMNS":
+		my.columns = columns(val)
+	}
+	return nil
+}
+
+// parse1 parses "1) 17535.4 Tx 00000100 8 00 ...", version 1.0 lacks the type.
+func (my *Reader) parse1(f []string) (r canlog.Record, ok bool, err error) {
+	if len(f) < 4 || !strings.HasSuffix(f[0], ")") {
+		return r, false, fmt.Errorf("invalid record")
+	}
+	if err := my.offset(&r, f[1]); err != nil {
+		return r, false, err
+	}
+	r.Interface = canlog.ChannelName(1)
+	f = f[2:]
+	if my.version == V1_1 {
+		switch f[0] {
+		case "Rx", "Tx":
+			r.Tx = f[0] == "Tx"
+		case "Error":
+			r.Frame.IsError = true
+		default:
+			// Warnings and other status records.
+			return r, false, nil
+		}
+		f = f[1:]
+	}
+	if len(f) < 2 {
+		return r, false, fmt.Errorf("invalid record")
+	}
+	if err := parseID(&r.Frame, f[0]); err != nil {
+		return r, false, err
+	}
+	// Error frames have 8 digit IDs too.
+	r.Frame.IsExtended = r.Frame.IsExtended && !r.Frame.IsError
+	n, err := strconv.Atoi(f[1])
+	if err != nil || n < 0 || n > canframe.FRAME_MAX_DATA_LEN {
+		return r, false, fmt.Errorf("invalid length %q", f[1])
+	}
+	if len(f) > 2 && f[2] == "RTR" {
+		r.Frame.IsRemote = true
+		r.Frame.Data = make([]byte, n)
+		return r, true, nil
+	}
+	r.Frame.Data, err = parseData(f[2:], n)
+	return r, err == nil, err
+}
+
+// parse2 parses a version 2 record by the file's columns.
+func (my *Reader) parse2(f []string) (r canlog.Record, ok bool, err error) {
+	col := func(c byte) (string, bool) {
+		i, ok := my.columns[c]
+		if !ok || i >= len(f) {
+			return "", false
+		}
+		return f[i], true
+	}
+	typ, _ := col('T')
+	switch typ {
+	case "DT", "RR", "FD", "FB", "FE", "BI", "ER":
+	default:
+		// Status, error counter and event records.
+		return r, false, nil
+	}
+	o, _ := col('O')
+	if err := my.offset(&r, o); err != nil {
+		return r, false, err
+	}
+	r.Interface = canlog.ChannelName(1)
+	if b, ok := col('B'); ok {
+		bus, err := strconv.Atoi(b)
+		if err != nil {
+			return r, false, fmt.Errorf("invalid bus %q", b)
+		}
+		r.Interface = canlog.ChannelName(bus)
+	}
+	dir, _ := col('d')
+	r.Tx = dir == "Tx"
+	fr := &r.Frame
+	fr.IsRemote = typ == "RR"
+	fr.IsFD = typ == "FD" || typ == "FB" || typ == "FE" || typ == "BI"
+	fr.BRS = typ == "FB" || typ == "BI"
+	fr.ESI = typ == "FE" || typ == "BI"
+	if typ == "ER" {
+		// PEAK writes error details in their own layout, keep what reads like a frame.
+		fr.IsError = true
+		if id, ok := col('I'); ok && parseID(fr, id) == nil {
+			fr.IsExtended = false
+			if n, err := my.length(col); err == nil && n <= canframe.FRAME_MAX_DATA_LEN && my.columns['D'] <= len(f) {
+				fr.Data, _ = parseData(f[my.columns['D']:], n)
+			}
+		}
+		return r, true, nil
+	}
+
+	id, _ := col('I')
+	if err := parseID(fr, id); err != nil {
+		return r, false, err
+	}
+	n, err := my.length(col)
+	if err != nil {
+		return r, false, err
+	}
+	max := canframe.FRAME_MAX_DATA_LEN
+	if fr.IsFD {
+		max = canframe.FD_FRAME_MAX_DATA_LEN
+	}
+	if n > max {
+		return r, false, fmt.Errorf("invalid length %d", n)
+	}
+	if fr.IsRemote {
+		fr.Data = make([]byte, n)
+		return r, true, nil
+	}
+	d, ok := my.columns['D']
+	if !ok || d > len(f) {
+		return r, false, fmt.Errorf("missing data")
+	}
+	fr.Data, err = parseData(f[d:], n)
+	return r, err == nil, err
+}
+
+// length returns the data length of the l column, or of the DLC of the L column.
+func (my *Reader) length(col func(byte) (string, bool)) (int, error) {
+	if l, ok := col('l'); ok {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid length %q", l)
+		}
+		return n, nil
+	}
+	l, ok := col('L')
+	if !ok {
+		return 0, fmt.Errorf("missing length")
+	}
+	n, err := strconv.Atoi(l)
+	if err != nil || n < 0 || n > 15 {
+		return 0, fmt.Errorf("invalid dlc %q", l)
+	}
+	return fdLens[n], nil
+}
+
+func (my *Reader) offset(r *canlog.Record, s string) error {
+	ms, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid time offset %q", s)
+	}
+	r.Time = my.start.Add(time.Duration(math.Round(ms * float64(time.Millisecond))))
+	return nil
+}
+
+// parseID parses a hex ID, extended ones have more than 4 digits.
+func parseID(f *canframe.Frame, s string) error {
+	id, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || id > 0x1FFFFFFF {
+		return fmt.Errorf("invalid id %q", s)
+	}
+	f.ID, f.IsExtended = uint32(id), len(s) > 4
+	return nil
+}
+
+func parseData(f []string, n int) ([]byte, error) {
+	if len(f) < n {
+		return nil, fmt.Errorf("%d of %d data bytes", len(f), n)
+	}
+	data, err := hex.DecodeString(strings.Join(f[:n], ""))
+	if err != nil || len(data) != n {
+		return nil, fmt.Errorf("invalid data")
+	}
+	return data, nil
+}
+
+func (my *Writer) header() {
+	my.started = true
+	fmt.Fprintf(my.w, ";$FILEVERSION=%s\n;$STARTTIME=%s\n", my.version, oleDays(my.Start))
+	if my.version == V2_1 {
+		fmt.Fprintf(my.w, ";$COLUMNS=N,O,T,B,I,d,R,L,D\n")
+	}
+	fmt.Fprintf(my.w, ";\n;   Start time: %s\n;\n", my.Start.Format("02.01.2006 15:04:05.000"))
+}
+
+// line writes a record line without trailing blanks.
+func (my *Writer) line(s string) {
+	my.w.WriteString(strings.TrimRight(s, " ") + "\n")
+}
+
+func hexBytes(data []byte) string {
+	var b strings.Builder
+	for _, d := range data {
+		fmt.Fprintf(&b, "%02X ", d)
+	}
+	return b.String()
+}
+
+// dlc returns the DLC of a payload length.
+func dlc(n int) int {
+	for dlc, l := range fdLens {
+		if l >= n {
+			return dlc
+		}
+	}
+	return 15
+}
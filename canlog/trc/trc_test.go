@@ -0,0 +1,121 @@
+package trc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/canlog"
+	"github.com/lion187chen/socketcan-go/canlog/internal/logtest"
+)
+
+// logged returns what the records read back as in a version, which may lack buses and CAN FD.
+func logged(recs []canlog.Record, version Version) []canlog.Record {
+	var want []canlog.Record
+	for _, r := range recs {
+		if version < V2_0 && r.Frame.IsFD {
+			continue
+		}
+		if version < V2_1 {
+			r.Interface = "can0"
+		}
+		if version < V2_0 && r.Frame.IsError {
+			r.Tx = false
+		}
+		want = append(want, r)
+	}
+	return want
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, version := range []Version{V1_1, V2_0, V2_1} {
+		t.Run(version.String(), func(t *testing.T) {
+			recs := logged(logtest.Records(), version)
+			var b bytes.Buffer
+			logtest.WriteAll(t, new(Writer).Init(&b, version), recs)
+			r := new(Reader).Init(&b)
+			logtest.Check(t, logtest.ReadAll(t, r), logged(recs, version))
+			if r.Version() != version {
+				t.Errorf("read version %s", r.Version())
+			}
+		})
+	}
+}
+
+func TestWriteFD(t *testing.T) {
+	var b bytes.Buffer
+	w := new(Writer).Init(&b, V1_1)
+	if err := w.Write(&canlog.Record{Time: time.Now(), Frame: canframe.Frame{ID: 1, IsFD: true, Data: []byte{1}}}); err == nil {
+		t.Errorf("CAN FD frame written to version 1.1")
+	}
+	if err := w.Write(&canlog.Record{Time: time.Now(), Frame: canframe.Frame{ID: 1, Data: make([]byte, 12)}}); err == nil {
+		t.Errorf("12 data bytes written to version 1.1")
+	}
+	if b.Len() != 0 {
+		t.Errorf("rejected frames wrote %q", b.String())
+	}
+}
+
+func TestRead(t *testing.T) {
+	t0 := time.Date(2024, 5, 6, 7, 8, 9, 10_000_000, time.Local)
+	for _, tc := range []struct {
+		name, log string
+		want      []canlog.Record
+	}{
+		{"1.0", `;##########################################################################
+;   Start time: 06.05.2024 07:08:09.010
+;##########################################################################
+     1)      1.5  00000100  8  00 01 02 03 04 05 06 07
+     2)      2.0  0123  2  RTR
+`, []canlog.Record{
+			{Time: time.Unix(0, 0).Add(1500 * time.Microsecond), Interface: "can0",
+				Frame: canframe.Frame{ID: 0x100, IsExtended: true, Data: []byte{0, 1, 2, 3, 4, 5, 6, 7}}},
+			{Time: time.Unix(0, 0).Add(2 * time.Millisecond), Interface: "can0",
+				Frame: canframe.Frame{ID: 0x123, IsRemote: true, Data: make([]byte, 2)}},
+		}},
+		{"1.1", `;$FILEVERSION=1.1
+;$STARTTIME=45418.29732650463
+     1)         1.5  Rx         0100  3  01 02 03
+     2)         2.0  Warng  FFFFFFFF  4  00 00 00 08  BUSHEAVY
+     3)         2.5  Tx     1ABCDEF0  0
+`, []canlog.Record{
+			{Time: t0.Add(1500 * time.Microsecond), Interface: "can0", Frame: canframe.Frame{ID: 0x100, Data: []byte{1, 2, 3}}},
+			{Time: t0.Add(2500 * time.Microsecond), Interface: "can0", Tx: true,
+				Frame: canframe.Frame{ID: 0x1ABCDEF0, IsExtended: true, Data: []byte{}}},
+		}},
+		{"2.1", `;$FILEVERSION=2.1
+;$STARTTIME=45418.29732650463
+;$COLUMNS=N,O,T,B,I,d,R,L,D
+;
+      1         1.500 DT 2      0100 Rx -  3    01 02 03
+      2         2.000 ST 1 Rx 00 00 00 08
+      3         3.000 FB 1  18DAF110 Tx - 9    55 55 55 55 55 55 55 55 55 55 55 55
+`, []canlog.Record{
+			{Time: t0.Add(1500 * time.Microsecond), Interface: "can1", Frame: canframe.Frame{ID: 0x100, Data: []byte{1, 2, 3}}},
+			{Time: t0.Add(3 * time.Millisecond), Interface: "can0", Tx: true,
+				Frame: canframe.Frame{ID: 0x18DAF110, IsExtended: true, IsFD: true, BRS: true, Data: bytes.Repeat([]byte{0x55}, 12)}},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logtest.Check(t, logtest.ReadAll(t, new(Reader).Init(strings.NewReader(tc.log))), tc.want)
+		})
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	for _, log := range []string{
+		";$FILEVERSION=3.0\n",
+		";$STARTTIME=x\n",
+		"1) 1.5 0100 9 00\n",
+		"1) 1.5 0100 2 00\n",
+		"1) x 0100 1 00\n",
+		";$FILEVERSION=2.0\n1 1.5 DT 0100 Rx 2 00 GG\n",
+		";$FILEVERSION=2.0\n1 1.5 FD 0100 Rx 65\n",
+	} {
+		if _, err := new(Reader).Init(strings.NewReader(log)).Read(); err == nil {
+			t.Errorf("%q: no error", log)
+		}
+	}
+}
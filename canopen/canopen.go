@@ -0,0 +1,12 @@
+package canopen
+
+// Function codes of the predefined connection set, a node's COB-ID is the code plus its node-ID.
+const (
+	cobNMT       = 0x000
+	cobSYNC      = 0x080
+	cobEMCY      = 0x080
+	cobHeartbeat = 0x700
+)
+
+// MaxNodeID is the highest CANopen node-ID.
+const MaxNodeID = 127
@@ -0,0 +1,472 @@
+package canopen
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EDS is a parsed EDS or DCF file (CiA 306).
+type EDS struct {
+	// NodeID resolves $NODEID in values, from DeviceComissioning for DCFs.
+	NodeID uint8
+	OD     *ObjectDictionary
+	// Sections are the non object sections like FileInfo and DeviceInfo, keys as in the file.
+	Sections map[string]*Section
+
+	// The DefaultValue texts as written, keeping $NODEID, by index<<8|subindex.
+	defaults map[uint32]string
+	order    []string
+}
+
+// Section is one INI section, in file order.
+type Section struct {
+	Name   string
+	Keys   []string
+	Values map[string]string
+}
+
+// Get looks up a key case insensitively.
+func (s *Section) Get(key string) (string, bool) {
+	v, ok := s.Values[strings.ToLower(key)]
+	return v, ok
+}
+
+// Set adds or changes a key.
+func (s *Section) Set(key, value string) {
+	lower := strings.ToLower(key)
+	if _, ok := s.Values[lower]; !ok {
+		s.Keys = append(s.Keys, key)
+	}
+	s.Values[lower] = value
+}
+
+func newSection(name string) *Section {
+	return &Section{Name: name, Values: make(map[string]string)}
+}
+
+// ParseEDS reads an EDS or DCF file into an object dictionary.
+// nodeID resolves $NODEID, 0 takes it from the DeviceComissioning section.
+// ParameterValue, when present, becomes the entry's value, DefaultValue otherwise.
+func ParseEDS(r io.Reader, nodeID uint8) (*EDS, error) {
+	sections, order, err := parseINI(r)
+	if err != nil {
+		return nil, err
+	}
+
+	eds := &EDS{
+		NodeID:   nodeID,
+		OD:       new(ObjectDictionary).Init(),
+		Sections: make(map[string]*Section),
+		defaults: make(map[uint32]string),
+	}
+	if dc, ok := sections["devicecomissioning"]; ok && nodeID == 0 {
+		if v, ok := dc.Get("NodeID"); ok {
+			id, err := strconv.ParseUint(strings.TrimSpace(v), 0, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid NodeID %q", v)
+			}
+			eds.NodeID = uint8(id)
+		}
+	}
+
+	for _, name := range order {
+		sec := sections[name]
+		index, _, isSub, ok := objectSection(name)
+		if !ok {
+			if !strings.HasSuffix(name, "value") && !strings.HasSuffix(name, "name") {
+				eds.Sections[sec.Name] = sec
+				eds.order = append(eds.order, sec.Name)
+			}
+			continue
+		}
+		if isSub {
+			continue
+		}
+		if err := eds.addObject(sections, index, sec); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", sec.Name, err)
+		}
+	}
+	return eds, nil
+}
+
+// WriteDCF writes the object dictionary with its current values as ParameterValue.
+func (eds *EDS) WriteDCF(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	var comissioning *Section
+	for name, sec := range eds.Sections {
+		if strings.EqualFold(name, "DeviceComissioning") {
+			comissioning = sec
+		}
+	}
+	if comissioning == nil {
+		comissioning = newSection("DeviceComissioning")
+		eds.Sections[comissioning.Name] = comissioning
+		eds.order = append(eds.order, comissioning.Name)
+	}
+	comissioning.Set("NodeID", fmt.Sprintf("%d", eds.NodeID))
+
+	var mandatory, optional, manufacturer []uint16
+	for _, index := range eds.OD.Indices() {
+		switch {
+		case index == 0x1000 || index == 0x1001 || index == 0x1018:
+			mandatory = append(mandatory, index)
+		case index >= 0x2000 && index < 0x6000:
+			manufacturer = append(manufacturer, index)
+		default:
+			optional = append(optional, index)
+		}
+	}
+
+	for _, name := range eds.order {
+		switch strings.ToLower(name) {
+		case "mandatoryobjects", "optionalobjects", "manufacturerobjects":
+			continue
+		}
+		sec := eds.Sections[name]
+		fmt.Fprintf(bw, "[%s]\n", sec.Name)
+		for _, k := range sec.Keys {
+			fmt.Fprintf(bw, "%s=%s\n", k, sec.Values[strings.ToLower(k)])
+		}
+		fmt.Fprintln(bw)
+	}
+	writeList(bw, "MandatoryObjects", mandatory)
+	writeList(bw, "OptionalObjects", optional)
+	writeList(bw, "ManufacturerObjects", manufacturer)
+
+	for _, index := range eds.OD.Indices() {
+		obj, _ := eds.OD.Object(index)
+		if obj.ObjectType == ObjectVar {
+			if v, ok := obj.Sub(0); ok {
+				eds.writeVariable(bw, fmt.Sprintf("%04X", index), v)
+			}
+			continue
+		}
+		subs := obj.Subindices()
+		fmt.Fprintf(bw, "[%04X]\nParameterName=%s\nObjectType=0x%X\nSubNumber=%d\n\n", index, obj.Name, uint8(obj.ObjectType), len(subs))
+		for _, s := range subs {
+			v, _ := obj.Sub(s)
+			eds.writeVariable(bw, fmt.Sprintf("%04Xsub%X", index, s), v)
+		}
+	}
+	return bw.Flush()
+}
+
+// EDS private.
+
+func (eds *EDS) addObject(sections map[string]*Section, index uint16, sec *Section) error {
+	name, _ := sec.Get("ParameterName")
+	typ := ObjectVar
+	if v, ok := sec.Get("ObjectType"); ok && strings.TrimSpace(v) != "" {
+		t, err := strconv.ParseUint(strings.TrimSpace(v), 0, 8)
+		if err != nil {
+			return fmt.Errorf("invalid ObjectType %q", v)
+		}
+		typ = ObjectType(t)
+	}
+
+	switch typ {
+	case ObjectVar, ObjectDomain:
+		obj := eds.OD.AddObject(index, name, typ)
+		return eds.addVariable(obj, 0, sec)
+	case ObjectArray, ObjectRecord:
+		obj := eds.OD.AddObject(index, name, typ)
+		if compact, ok := sec.Get("CompactSubObj"); ok && strings.TrimSpace(compact) != "" && strings.TrimSpace(compact) != "0" {
+			return eds.addCompact(sections, obj, sec, compact)
+		}
+		prefix := strings.ToLower(fmt.Sprintf("%04Xsub", index))
+		for key, s := range sections {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			sub, err := strconv.ParseUint(key[len(prefix):], 16, 8)
+			if err != nil {
+				continue
+			}
+			if err := eds.addVariable(obj, uint8(sub), s); err != nil {
+				return fmt.Errorf("[%s]: %w", s.Name, err)
+			}
+		}
+	}
+	// DEFTYPE and DEFSTRUCT entries only describe types.
+	return nil
+}
+
+// addCompact expands a CompactSubObj array, names and values come from the <index>Name and <index>Value sections.
+func (eds *EDS) addCompact(sections map[string]*Section, obj *Object, sec *Section, compact string) error {
+	n, err := strconv.ParseUint(strings.TrimSpace(compact), 0, 8)
+	if err != nil {
+		return fmt.Errorf("invalid CompactSubObj %q", compact)
+	}
+	dt, access, err := typeAndAccess(sec)
+	if err != nil {
+		return err
+	}
+	obj.AddSub(0, "Number of entries", Unsigned8, AccessRO, []byte{byte(n)})
+
+	names := sections[strings.ToLower(fmt.Sprintf("%04Xname", obj.Index))]
+	values := sections[strings.ToLower(fmt.Sprintf("%04Xvalue", obj.Index))]
+	def, _ := sec.Get("DefaultValue")
+	for i := 1; i <= int(n); i++ {
+		name := fmt.Sprintf("%s%d", obj.Name, i)
+		if names != nil {
+			if v, ok := names.Get(strconv.Itoa(i)); ok {
+				name = v
+			}
+		}
+		text := def
+		if values != nil {
+			if v, ok := values.Get(strconv.Itoa(i)); ok {
+				text = v
+			}
+		}
+		value, err := eds.parseValue(dt, text)
+		if err != nil {
+			return err
+		}
+		v := obj.AddSub(uint8(i), name, dt, access, value)
+		if err := eds.limits(v, sec); err != nil {
+			return err
+		}
+		eds.defaults[uint32(obj.Index)<<8|uint32(i)] = text
+	}
+	return nil
+}
+
+func (eds *EDS) addVariable(obj *Object, sub uint8, sec *Section) error {
+	name, _ := sec.Get("ParameterName")
+	dt, access, err := typeAndAccess(sec)
+	if err != nil {
+		return err
+	}
+	defText, _ := sec.Get("DefaultValue")
+	def, err := eds.parseValue(dt, defText)
+	if err != nil {
+		return err
+	}
+	v := obj.AddSub(sub, name, dt, access, def)
+	eds.defaults[uint32(obj.Index)<<8|uint32(sub)] = defText
+
+	if text, ok := sec.Get("ParameterValue"); ok {
+		if v.Value, err = eds.parseValue(dt, text); err != nil {
+			return err
+		}
+	}
+	return eds.limits(v, sec)
+}
+
+// limits sets the LowLimit, HighLimit and PDOMapping keys on v.
+func (eds *EDS) limits(v *Variable, sec *Section) (err error) {
+	if text, ok := sec.Get("LowLimit"); ok && strings.TrimSpace(text) != "" {
+		if v.LowLimit, err = eds.parseValue(v.DataType, text); err != nil {
+			return err
+		}
+	}
+	if text, ok := sec.Get("HighLimit"); ok && strings.TrimSpace(text) != "" {
+		if v.HighLimit, err = eds.parseValue(v.DataType, text); err != nil {
+			return err
+		}
+	}
+	if m, ok := sec.Get("PDOMapping"); ok {
+		v.PDOMapping = strings.TrimSpace(m) == "1"
+	}
+	return nil
+}
+
+func (eds *EDS) writeVariable(w io.Writer, section string, v *Variable) {
+	fmt.Fprintf(w, "[%s]\nParameterName=%s\nObjectType=0x%X\nDataType=0x%04X\nAccessType=%s\n", section, v.Name, uint8(ObjectVar), uint16(v.DataType), v.Access)
+	def, ok := eds.defaults[uint32(v.Index)<<8|uint32(v.Subindex)]
+	if !ok {
+		def = formatValue(v.DataType, v.Default)
+	}
+	fmt.Fprintf(w, "DefaultValue=%s\n", def)
+	if v.LowLimit != nil {
+		fmt.Fprintf(w, "LowLimit=%s\n", formatValue(v.DataType, v.LowLimit))
+	}
+	if v.HighLimit != nil {
+		fmt.Fprintf(w, "HighLimit=%s\n", formatValue(v.DataType, v.HighLimit))
+	}
+	mapping := 0
+	if v.PDOMapping {
+		mapping = 1
+	}
+	fmt.Fprintf(w, "PDOMapping=%d\n", mapping)
+	if v.DataType != Domain {
+		fmt.Fprintf(w, "ParameterValue=%s\n", formatValue(v.DataType, v.Value))
+	}
+	fmt.Fprintln(w)
+}
+
+// parseValue parses a value text, resolving $NODEID.
+func (eds *EDS) parseValue(dt DataType, text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	switch dt {
+	case VisibleString, UnicodeString:
+		return []byte(text), nil
+	case OctetString, Domain:
+		b, err := hex.DecodeString(strings.ReplaceAll(text, " ", ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid octet string %q", text)
+		}
+		return b, nil
+	case Real32, Real64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q", text)
+		}
+		return EncodeFloat(dt, f), nil
+	}
+
+	// Integers, possibly a sum with $NODEID.
+	var sum int64
+	for _, term := range strings.Split(text, "+") {
+		term = strings.TrimSpace(term)
+		if strings.EqualFold(term, "$NODEID") {
+			sum += int64(eds.NodeID)
+			continue
+		}
+		var v int64
+		var err error
+		if strings.HasPrefix(term, "-") {
+			v, err = strconv.ParseInt(term, 0, 64)
+		} else {
+			var u uint64
+			u, err = strconv.ParseUint(term, 0, 64)
+			v = int64(u)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q", text)
+		}
+		sum += v
+	}
+	if dt.Size() == 0 {
+		return nil, fmt.Errorf("unsupported data type %#04x", uint16(dt))
+	}
+	return EncodeUint(dt, uint64(sum)), nil
+}
+
+func formatValue(dt DataType, b []byte) string {
+	if b == nil {
+		return ""
+	}
+	switch {
+	case dt == VisibleString || dt == UnicodeString:
+		return string(b)
+	case dt == OctetString || dt == Domain:
+		return strings.ToUpper(hex.EncodeToString(b))
+	case dt == Real32 || dt == Real64:
+		f := DecodeFloat(b)
+		if dt == Real32 {
+			return strconv.FormatFloat(f, 'g', -1, 32)
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return "0"
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	case dt.IsSigned():
+		return strconv.FormatInt(DecodeInt(b), 10)
+	}
+	return fmt.Sprintf("0x%X", DecodeUint(b))
+}
+
+func typeAndAccess(sec *Section) (DataType, Access, error) {
+	text, _ := sec.Get("DataType")
+	t, err := strconv.ParseUint(strings.TrimSpace(text), 0, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid DataType %q", text)
+	}
+	text, _ = sec.Get("AccessType")
+	var access Access
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "ro":
+		access = AccessRO
+	case "wo":
+		access = AccessWO
+	case "rw":
+		access = AccessRW
+	case "rwr":
+		access = AccessRWR
+	case "rww":
+		access = AccessRWW
+	case "const":
+		access = AccessConst
+	default:
+		return 0, 0, fmt.Errorf("invalid AccessType %q", text)
+	}
+	return DataType(t), access, nil
+}
+
+// objectSection recognizes "1018" and "1018sub1" section names.
+func objectSection(name string) (index uint16, sub uint8, isSub bool, ok bool) {
+	if len(name) < 4 {
+		return 0, 0, false, false
+	}
+	i, err := strconv.ParseUint(name[:4], 16, 16)
+	if err != nil {
+		return 0, 0, false, false
+	}
+	rest := name[4:]
+	if rest == "" {
+		return uint16(i), 0, false, true
+	}
+	if strings.HasPrefix(rest, "sub") {
+		s, err := strconv.ParseUint(rest[3:], 16, 8)
+		if err != nil {
+			return 0, 0, false, false
+		}
+		return uint16(i), uint8(s), true, true
+	}
+	return 0, 0, false, false
+}
+
+// parseINI returns the sections by lower case name and the lower case names in file order.
+func parseINI(r io.Reader) (map[string]*Section, []string, error) {
+	sections := make(map[string]*Section)
+	var order []string
+	var cur *Section
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == ';' || text[0] == '#' {
+			continue
+		}
+		if text[0] == '[' {
+			end := strings.IndexByte(text, ']')
+			if end < 0 {
+				return nil, nil, fmt.Errorf("line %d: unterminated section", line)
+			}
+			name := strings.TrimSpace(text[1:end])
+			lower := strings.ToLower(name)
+			if _, ok := sections[lower]; !ok {
+				sections[lower] = newSection(name)
+				order = append(order, lower)
+			}
+			cur = sections[lower]
+			continue
+		}
+		eq := strings.IndexByte(text, '=')
+		if eq < 0 || cur == nil {
+			return nil, nil, fmt.Errorf("line %d: expected key=value", line)
+		}
+		cur.Set(strings.TrimSpace(text[:eq]), strings.TrimSpace(text[eq+1:]))
+	}
+	return sections, order, sc.Err()
+}
+
+func writeList(w io.Writer, name string, indices []uint16) {
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	fmt.Fprintf(w, "[%s]\nSupportedObjects=%d\n", name, len(indices))
+	for i, index := range indices {
+		fmt.Fprintf(w, "%d=0x%04X\n", i+1, index)
+	}
+	fmt.Fprintln(w)
+}
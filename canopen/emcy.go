@@ -0,0 +1,254 @@
+package canopen
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// Error register (0x1001) bits.
+const (
+	ErrRegGeneric       = 0x01
+	ErrRegCurrent       = 0x02
+	ErrRegVoltage       = 0x04
+	ErrRegTemperature   = 0x08
+	ErrRegCommunication = 0x10
+	ErrRegDeviceProfile = 0x20
+	ErrRegManufacturer  = 0x80
+)
+
+// Some emergency error codes of CiA 301.
+const (
+	EmcyNoError          = 0x0000
+	EmcyGeneric          = 0x1000
+	EmcyCurrent          = 0x2000
+	EmcyVoltage          = 0x3000
+	EmcyTemperature      = 0x4000
+	EmcyHardware         = 0x5000
+	EmcySoftware         = 0x6000
+	EmcyCANOverrun       = 0x8110
+	EmcyCANErrorPassive  = 0x8120
+	EmcyLifeGuard        = 0x8130
+	EmcyBusOffRecovered  = 0x8140
+	EmcyCANIDCollision   = 0x8150
+	EmcyPDOLength        = 0x8210
+	EmcyPDOLengthExceed  = 0x8220
+	EmcySyncLength       = 0x8240
+	EmcyRPDOTimeout      = 0x8250
+	EmcyExternal         = 0x9000
+	EmcyDeviceSpecific   = 0xFF00
+	emcyFrameLen         = 8
+	emcyManufacturerSize = 5
+)
+
+// Emergency is an EMCY message.
+type Emergency struct {
+	Node     uint8
+	Code     uint16
+	Register uint8
+	// Manufacturer is the manufacturer specific error code.
+	Manufacturer [emcyManufacturerSize]byte
+	Time         time.Time
+}
+
+// IsReset tells whether the node reports its errors are gone.
+func (e Emergency) IsReset() bool {
+	return e.Code == EmcyNoError
+}
+
+func (e Emergency) String() string {
+	return fmt.Sprintf("node %d emcy %04X (%s) register %02X manufacturer % X", e.Node, e.Code, EmcyDescription(e.Code), e.Register, e.Manufacturer[:])
+}
+
+// EmcyDescription describes an emergency error code by its class.
+func EmcyDescription(code uint16) string {
+	switch code {
+	case EmcyCANOverrun:
+		return "CAN overrun"
+	case EmcyCANErrorPassive:
+		return "CAN in error passive mode"
+	case EmcyLifeGuard:
+		return "life guard or heartbeat error"
+	case EmcyBusOffRecovered:
+		return "recovered from bus off"
+	case EmcyCANIDCollision:
+		return "CAN-ID collision"
+	case EmcyPDOLength:
+		return "PDO not processed due to length error"
+	case EmcyPDOLengthExceed:
+		return "PDO length exceeded"
+	case EmcySyncLength:
+		return "unexpected SYNC data length"
+	case EmcyRPDOTimeout:
+		return "RPDO timeout"
+	}
+	switch code >> 8 {
+	case 0x00:
+		return "error reset or no error"
+	case 0x10:
+		return "generic error"
+	case 0x20:
+		return "current"
+	case 0x21:
+		return "current, device input side"
+	case 0x22:
+		return "current inside the device"
+	case 0x23:
+		return "current, device output side"
+	case 0x30:
+		return "voltage"
+	case 0x31:
+		return "mains voltage"
+	case 0x32:
+		return "voltage inside the device"
+	case 0x33:
+		return "output voltage"
+	case 0x40:
+		return "temperature"
+	case 0x41:
+		return "ambient temperature"
+	case 0x42:
+		return "device temperature"
+	case 0x50:
+		return "device hardware"
+	case 0x60:
+		return "device software"
+	case 0x61:
+		return "internal software"
+	case 0x62:
+		return "user software"
+	case 0x63:
+		return "data set"
+	case 0x70:
+		return "additional modules"
+	case 0x80:
+		return "monitoring"
+	case 0x81:
+		return "communication"
+	case 0x82:
+		return "protocol error"
+	case 0x90:
+		return "external error"
+	case 0xF0:
+		return "additional functions"
+	case 0xFF:
+		return "device specific"
+	}
+	return "unknown"
+}
+
+// ParseEmergency decodes an EMCY frame, 0x081 to 0x0FF.
+func ParseEmergency(f *canframe.Frame) (Emergency, error) {
+	id := f.ID &^ 0x7F
+	if f.IsExtended || f.IsRemote || f.IsError || id != cobEMCY || f.ID == cobSYNC {
+		return Emergency{}, fmt.Errorf("frame %#x is no emergency", f.ID)
+	}
+	if len(f.Data) < emcyFrameLen {
+		return Emergency{}, fmt.Errorf("emergency of %d bytes", len(f.Data))
+	}
+	e := Emergency{
+		Node:     uint8(f.ID & 0x7F),
+		Code:     uint16(f.Data[0]) | uint16(f.Data[1])<<8,
+		Register: f.Data[2],
+		Time:     time.Now(),
+	}
+	copy(e.Manufacturer[:], f.Data[3:])
+	return e, nil
+}
+
+// EmcyFilter passes emergency messages (0x081 to 0x0FF) and SYNC,
+// to use with Can.SetFilter() on the Can an EmcyConsumer reads.
+func EmcyFilter() socketcan.Filter {
+	return socketcan.NewStdMaskFilter(cobEMCY, 0x780)
+}
+
+// EmcyConsumer receives emergency messages and keeps each node's last one.
+type EmcyConsumer struct {
+	bus  socketcan.Bus
+	mu   sync.Mutex
+	last map[uint8]Emergency
+	stop chan struct{}
+
+	// OnEmergency is called from Run() for every emergency message.
+	OnEmergency func(e Emergency)
+}
+
+func (my *EmcyConsumer) Init(bus socketcan.Bus) *EmcyConsumer {
+	my.bus = bus
+	my.last = make(map[uint8]Emergency)
+	my.stop = make(chan struct{})
+	return my
+}
+
+// Last returns the last emergency of node, an EmcyNoError one once its errors were reset.
+func (my *EmcyConsumer) Last(node uint8) (Emergency, bool) {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	e, ok := my.last[node]
+	return e, ok
+}
+
+// Run will block receiving emergencies until Stop() was called or the bus failed.
+func (my *EmcyConsumer) Run() error {
+	for {
+		select {
+		case <-my.stop:
+			return nil
+		default:
+		}
+
+		f, err := socketcan.RcvFrameUntil(my.bus, time.Now().Add(monitorTick))
+		if socketcan.IsTimeout(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		e, err := ParseEmergency(&f)
+		if err != nil {
+			continue
+		}
+		my.mu.Lock()
+		my.last[e.Node] = e
+		my.mu.Unlock()
+		if my.OnEmergency != nil {
+			my.OnEmergency(e)
+		}
+	}
+}
+
+// Stop makes Run() return.
+func (my *EmcyConsumer) Stop() {
+	close(my.stop)
+}
+
+// EmcyProducer sends our emergency messages.
+type EmcyProducer struct {
+	bus  socketcan.Bus
+	node uint8
+}
+
+func (my *EmcyProducer) Init(bus socketcan.Bus, node uint8) *EmcyProducer {
+	my.bus = bus
+	my.node = node
+	return my
+}
+
+// Send sends an emergency, manufacturer is up to 5 bytes.
+// Send EmcyNoError with register 0 once all errors are gone.
+func (my *EmcyProducer) Send(code uint16, register uint8, manufacturer []byte) error {
+	if len(manufacturer) > emcyManufacturerSize {
+		return fmt.Errorf("manufacturer error code of %d bytes, at most %d", len(manufacturer), emcyManufacturerSize)
+	}
+	d := make([]byte, emcyFrameLen)
+	d[0] = byte(code)
+	d[1] = byte(code >> 8)
+	d[2] = register
+	copy(d[3:], manufacturer)
+	f := canframe.Frame{ID: cobEMCY + uint32(my.node), Data: d}
+	_, err := my.bus.SendFrame(&f)
+	return err
+}
@@ -0,0 +1,307 @@
+package canopen
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// LSS COB-IDs (CiA 305).
+const (
+	cobLSSMaster = 0x7E5
+	cobLSSSlave  = 0x7E4
+)
+
+// LSS command specifiers.
+const (
+	lssSwitchGlobal        = 0x04
+	lssConfigureNodeID     = 0x11
+	lssConfigureBitTiming  = 0x13
+	lssActivateBitTiming   = 0x15
+	lssStore               = 0x17
+	lssSwitchVendor        = 0x40
+	lssSwitchProduct       = 0x41
+	lssSwitchRevision      = 0x42
+	lssSwitchSerial        = 0x43
+	lssSwitchResponse      = 0x44
+	lssIdentifySlave       = 0x4F
+	lssFastscan            = 0x51
+	lssInquireVendor       = 0x5A
+	lssInquireProduct      = 0x5B
+	lssInquireRevision     = 0x5C
+	lssInquireSerial       = 0x5D
+	lssInquireNodeID       = 0x5E
+	lssFastscanReset       = 0x80
+	lssFrameLen            = 8
+	lssUnconfiguredNodeID  = 0xFF
+	lssIdentityParts       = 4
+	lssBitsPerIdentityPart = 32
+)
+
+// DefaultLSSTimeout is how long an LSSMaster waits for a slave.
+const DefaultLSSTimeout = 100 * time.Millisecond
+
+// Bit timing table indices of CiA 305 table 0.
+const (
+	BitTiming1M   = 0
+	BitTiming800k = 1
+	BitTiming500k = 2
+	BitTiming250k = 3
+	BitTiming125k = 4
+	BitTiming50k  = 6
+	BitTiming20k  = 7
+	BitTiming10k  = 8
+	BitTimingAuto = 9
+)
+
+// ErrNoLSSSlave means no slave answered.
+var ErrNoLSSSlave = errors.New("no lss slave answered")
+
+// Identity is the LSS address of a node, object 0x1018.
+type Identity struct {
+	Vendor   uint32
+	Product  uint32
+	Revision uint32
+	Serial   uint32
+}
+
+func (id *Identity) part(i int) *uint32 {
+	return [...]*uint32{&id.Vendor, &id.Product, &id.Revision, &id.Serial}[i]
+}
+
+// LSSError is an error code a slave answered a configure or store command with.
+type LSSError struct {
+	Command string
+	Code    uint8
+	// Specific is the manufacturer specific error when Code is 0xFF.
+	Specific uint8
+}
+
+func (e *LSSError) Error() string {
+	switch {
+	case e.Code == 0xFF:
+		return fmt.Sprintf("lss %s: manufacturer specific error %#x", e.Command, e.Specific)
+	case e.Code == 1 && e.Command == "store":
+		return "lss store: not supported"
+	case e.Code == 2 && e.Command == "store":
+		return "lss store: storage media access error"
+	case e.Code == 1:
+		return fmt.Sprintf("lss %s: not supported or out of range", e.Command)
+	}
+	return fmt.Sprintf("lss %s: error %d", e.Command, e.Code)
+}
+
+// LSSMaster commissions nodes with Layer Setting Services.
+type LSSMaster struct {
+	bus socketcan.Bus
+
+	// Timeout is how long to wait for a slave.
+	Timeout time.Duration
+}
+
+func (my *LSSMaster) Init(bus socketcan.Bus) *LSSMaster {
+	my.bus = bus
+	my.Timeout = DefaultLSSTimeout
+	return my
+}
+
+// SwitchGlobal switches all slaves into the configuration state, or back into the waiting state.
+func (my *LSSMaster) SwitchGlobal(configuration bool) error {
+	var mode byte
+	if configuration {
+		mode = 1
+	}
+	return my.send(lssSwitchGlobal, mode)
+}
+
+// SwitchSelective switches the slave with id into the configuration state.
+func (my *LSSMaster) SwitchSelective(id Identity) error {
+	for i := 0; i < lssIdentityParts; i++ {
+		if err := my.send(lssSwitchVendor+byte(i), le32(*id.part(i))...); err != nil {
+			return err
+		}
+	}
+	_, err := my.receive(lssSwitchResponse)
+	return err
+}
+
+// ConfigureNodeID sets the pending node-ID of the slave in configuration state, 0xFF unconfigures it.
+func (my *LSSMaster) ConfigureNodeID(node uint8) error {
+	if (node == 0 || node > MaxNodeID) && node != lssUnconfiguredNodeID {
+		return fmt.Errorf("invalid node-ID %d", node)
+	}
+	return my.configure("configure node-ID", lssConfigureNodeID, node)
+}
+
+// ConfigureBitTiming sets the pending bit timing of the slave in configuration state, one of the BitTiming indices.
+func (my *LSSMaster) ConfigureBitTiming(index uint8) error {
+	return my.configure("configure bit timing", lssConfigureBitTiming, 0, index)
+}
+
+// ActivateBitTiming makes all slaves in configuration state switch to the pending bit timing.
+// They stop transmitting for delay, switch, and wait delay again before transmitting.
+func (my *LSSMaster) ActivateBitTiming(delay time.Duration) error {
+	ms := uint16(delay / time.Millisecond)
+	return my.send(lssActivateBitTiming, byte(ms), byte(ms>>8))
+}
+
+// Store makes the slave in configuration state store its pending node-ID and bit timing.
+func (my *LSSMaster) Store() error {
+	return my.configure("store", lssStore)
+}
+
+// InquireNodeID returns the active node-ID of the slave in configuration state, 0xFF when unconfigured.
+func (my *LSSMaster) InquireNodeID() (uint8, error) {
+	if err := my.send(lssInquireNodeID); err != nil {
+		return 0, err
+	}
+	d, err := my.receive(lssInquireNodeID)
+	if err != nil {
+		return 0, err
+	}
+	return d[1], nil
+}
+
+// InquireIdentity returns the identity of the slave in configuration state.
+func (my *LSSMaster) InquireIdentity() (Identity, error) {
+	var id Identity
+	for i := 0; i < lssIdentityParts; i++ {
+		cs := lssInquireVendor + byte(i)
+		if err := my.send(cs); err != nil {
+			return id, err
+		}
+		d, err := my.receive(cs)
+		if err != nil {
+			return id, err
+		}
+		*id.part(i) = getLE32(d[1:])
+	}
+	return id, nil
+}
+
+// Fastscan finds one slave without node-ID, and leaves it in configuration state.
+// Call it repeatedly, configuring each one found, until it returns ErrNoLSSSlave.
+func (my *LSSMaster) Fastscan() (Identity, error) {
+	var id Identity
+	ok, err := my.fastscan(0, lssFastscanReset, 0, 0)
+	if err != nil {
+		return id, err
+	}
+	if !ok {
+		return id, ErrNoLSSSlave
+	}
+
+	for sub := 0; sub < lssIdentityParts; sub++ {
+		part := id.part(sub)
+		// Bits checked from the top, a response means a slave matches the bits so far.
+		for bit := lssBitsPerIdentityPart - 1; bit >= 0; bit-- {
+			ok, err := my.fastscan(*part, byte(bit), byte(sub), byte(sub))
+			if err != nil {
+				return id, err
+			}
+			if !ok {
+				*part |= 1 << bit
+			}
+		}
+		// Confirm the whole part, the slave moves on to the next or, after the serial, into configuration state.
+		next := (sub + 1) % lssIdentityParts
+		ok, err := my.fastscan(*part, 0, byte(sub), byte(next))
+		if err != nil {
+			return id, err
+		}
+		if !ok {
+			return id, fmt.Errorf("lss fastscan: slave lost at %#08x of part %d", *part, sub)
+		}
+	}
+	return id, nil
+}
+
+// LSSMaster private.
+
+// fastscan sends a fastscan request and tells whether a slave answered.
+func (my *LSSMaster) fastscan(idNumber uint32, bitChecked, sub, next byte) (bool, error) {
+	if err := my.flush(); err != nil {
+		return false, err
+	}
+	d := append(le32(idNumber), bitChecked, sub, next)
+	if err := my.send(lssFastscan, d...); err != nil {
+		return false, err
+	}
+	// Several slaves may answer, all of them are taken within the timeout so none is left for the next request.
+	deadline := time.Now().Add(my.Timeout)
+	n := 0
+	for {
+		f, err := socketcan.RcvFrameUntil(my.bus, deadline)
+		if socketcan.IsTimeout(err) {
+			return n > 0, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if my.response(&f, lssIdentifySlave) {
+			n++
+		}
+	}
+}
+
+// configure sends a command answered with an error code.
+func (my *LSSMaster) configure(name string, cs byte, args ...byte) error {
+	if err := my.send(cs, args...); err != nil {
+		return err
+	}
+	d, err := my.receive(cs)
+	if err != nil {
+		return err
+	}
+	if d[1] != 0 {
+		return &LSSError{Command: name, Code: d[1], Specific: d[2]}
+	}
+	return nil
+}
+
+// flush drops responses that came late or from several slaves at once, so they are not taken for the next one.
+func (my *LSSMaster) flush() error {
+	for {
+		_, err := socketcan.RcvFrameUntil(my.bus, time.Now().Add(time.Millisecond))
+		if socketcan.IsTimeout(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (my *LSSMaster) send(cs byte, args ...byte) error {
+	d := make([]byte, lssFrameLen)
+	d[0] = cs
+	copy(d[1:], args)
+	f := canframe.Frame{ID: cobLSSMaster, Data: d}
+	_, err := my.bus.SendFrame(&f)
+	return err
+}
+
+// receive waits for the response cs, ErrNoLSSSlave when none came.
+func (my *LSSMaster) receive(cs byte) ([]byte, error) {
+	deadline := time.Now().Add(my.Timeout)
+	for {
+		f, err := socketcan.RcvFrameUntil(my.bus, deadline)
+		if socketcan.IsTimeout(err) {
+			return nil, ErrNoLSSSlave
+		}
+		if err != nil {
+			return nil, err
+		}
+		if my.response(&f, cs) {
+			return f.Data, nil
+		}
+	}
+}
+
+// response tells whether f is the slave response cs.
+func (my *LSSMaster) response(f *canframe.Frame, cs byte) bool {
+	return f.ID == cobLSSSlave && !f.IsExtended && !f.IsRemote && !f.IsError && len(f.Data) >= lssFrameLen && f.Data[0] == cs
+}
@@ -0,0 +1,243 @@
+package canopen
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// EventType tells what happened to a node.
+type EventType int
+
+const (
+	// EventBootUp is a boot-up message.
+	EventBootUp EventType = iota
+	// EventStateChanged is a heartbeat or guarding response with a new state.
+	EventStateChanged
+	// EventMissing means the heartbeat or guarding response did not come in time.
+	EventMissing
+	// EventRecovered means a missing node is back.
+	EventRecovered
+	// EventToggleError is a guarding response with a wrong toggle bit.
+	EventToggleError
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventBootUp:
+		return "boot-up"
+	case EventStateChanged:
+		return "state changed"
+	case EventMissing:
+		return "missing"
+	case EventRecovered:
+		return "recovered"
+	case EventToggleError:
+		return "toggle error"
+	}
+	return "unknown"
+}
+
+// Event is what a Monitor reports about a node.
+type Event struct {
+	Node  uint8
+	Type  EventType
+	State NMTState
+	Time  time.Time
+}
+
+// Monitor consumes heartbeats and boot-ups, guards nodes, and tracks each node's NMT state.
+// Use HeartbeatFilter() on its Can.
+type Monitor struct {
+	bus   socketcan.Bus
+	mu    sync.Mutex
+	nodes map[uint8]*nodeStatus
+	stop  chan struct{}
+
+	// OnEvent is called from Run() for every event.
+	OnEvent func(e Event)
+}
+
+type nodeStatus struct {
+	state    NMTState
+	lastSeen time.Time
+	// timeout is the heartbeat consumer time or the node life time, 0 when not watched.
+	timeout time.Duration
+	missing bool
+	// Node guarding.
+	guard     time.Duration
+	nextGuard time.Time
+	toggle    byte
+	guarded   bool
+}
+
+// The longest Run() sleeps between timeout checks.
+const monitorTick = 50 * time.Millisecond
+
+func (my *Monitor) Init(bus socketcan.Bus) *Monitor {
+	my.bus = bus
+	my.nodes = make(map[uint8]*nodeStatus)
+	my.stop = make(chan struct{})
+	return my
+}
+
+// Watch expects a heartbeat from node at least every timeout, the heartbeat consumer time.
+func (my *Monitor) Watch(node uint8, timeout time.Duration) {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	n := my.node(node)
+	n.timeout = timeout
+	n.guard = 0
+	n.lastSeen = time.Now()
+}
+
+// Guard polls node with remote frames every guardTime, it is missing after guardTime*lifeTimeFactor without response.
+func (my *Monitor) Guard(node uint8, guardTime time.Duration, lifeTimeFactor uint8) {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	n := my.node(node)
+	n.guard = guardTime
+	n.timeout = guardTime * time.Duration(lifeTimeFactor)
+	n.nextGuard = time.Now()
+	n.lastSeen = time.Now()
+	n.guarded = false
+}
+
+// Unwatch stops watching and guarding node.
+func (my *Monitor) Unwatch(node uint8) {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	if n, ok := my.nodes[node]; ok {
+		n.timeout = 0
+		n.guard = 0
+		n.missing = false
+	}
+}
+
+// State returns the last state heard from node, StateUnknown if never heard of.
+// missing is true while a watched node is overdue.
+func (my *Monitor) State(node uint8) (state NMTState, missing bool) {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	n, ok := my.nodes[node]
+	if !ok {
+		return StateUnknown, false
+	}
+	return n.state, n.missing
+}
+
+// Nodes returns the state of every node heard of or watched.
+func (my *Monitor) Nodes() map[uint8]NMTState {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	states := make(map[uint8]NMTState, len(my.nodes))
+	for id, n := range my.nodes {
+		states[id] = n.state
+	}
+	return states
+}
+
+// Run will block processing frames and checking timeouts until Stop() was called or the bus failed.
+func (my *Monitor) Run() error {
+	for {
+		select {
+		case <-my.stop:
+			return nil
+		default:
+		}
+
+		f, err := socketcan.RcvFrameUntil(my.bus, time.Now().Add(monitorTick))
+		if err != nil && !socketcan.IsTimeout(err) {
+			return err
+		}
+		var events []Event
+		if err == nil {
+			events = my.handle(&f)
+		}
+		events = append(events, my.check()...)
+		for _, e := range events {
+			if my.OnEvent != nil {
+				my.OnEvent(e)
+			}
+		}
+	}
+}
+
+// Stop makes Run() return.
+func (my *Monitor) Stop() {
+	close(my.stop)
+}
+
+// Monitor private.
+
+// node returns the status of id, creating it. The caller holds the lock.
+func (my *Monitor) node(id uint8) *nodeStatus {
+	n, ok := my.nodes[id]
+	if !ok {
+		n = &nodeStatus{state: StateUnknown}
+		my.nodes[id] = n
+	}
+	return n
+}
+
+func (my *Monitor) handle(f *canframe.Frame) []Event {
+	if f.IsExtended || f.IsRemote || f.IsError || f.ID&^0x7F != cobHeartbeat || len(f.Data) < 1 {
+		return nil
+	}
+	id := uint8(f.ID & 0x7F)
+	if id == 0 {
+		return nil
+	}
+
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	now := time.Now()
+	n := my.node(id)
+	state := NMTState(f.Data[0] & 0x7F)
+	var events []Event
+
+	if n.guard != 0 && state != StateBootUp {
+		toggle := f.Data[0] & 0x80
+		if n.guarded && toggle == n.toggle {
+			events = append(events, Event{Node: id, Type: EventToggleError, State: state, Time: now})
+		}
+		n.toggle = toggle
+		n.guarded = true
+	}
+	if n.missing {
+		n.missing = false
+		events = append(events, Event{Node: id, Type: EventRecovered, State: state, Time: now})
+	}
+	switch {
+	case state == StateBootUp:
+		n.guarded = false
+		events = append(events, Event{Node: id, Type: EventBootUp, State: state, Time: now})
+	case state != n.state:
+		events = append(events, Event{Node: id, Type: EventStateChanged, State: state, Time: now})
+	}
+	n.state = state
+	n.lastSeen = now
+	return events
+}
+
+// check reports overdue nodes and sends due guarding requests.
+func (my *Monitor) check() []Event {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	now := time.Now()
+	var events []Event
+	for id, n := range my.nodes {
+		if n.guard != 0 && !now.Before(n.nextGuard) {
+			f := canframe.Frame{ID: cobHeartbeat + uint32(id), Data: make([]byte, 1), IsRemote: true}
+			my.bus.SendFrame(&f)
+			n.nextGuard = now.Add(n.guard)
+		}
+		if n.timeout != 0 && !n.missing && now.Sub(n.lastSeen) > n.timeout {
+			n.missing = true
+			events = append(events, Event{Node: id, Type: EventMissing, State: n.state, Time: now})
+		}
+	}
+	return events
+}
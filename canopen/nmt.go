@@ -0,0 +1,98 @@
+package canopen
+
+import (
+	"fmt"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// NMTCommand is a network management command specifier.
+type NMTCommand uint8
+
+const (
+	NMTStart              NMTCommand = 0x01
+	NMTStop               NMTCommand = 0x02
+	NMTPreOperational     NMTCommand = 0x80
+	NMTResetNode          NMTCommand = 0x81
+	NMTResetCommunication NMTCommand = 0x82
+)
+
+// NMTState is the state a node reports in its heartbeat.
+type NMTState uint8
+
+const (
+	StateBootUp         NMTState = 0x00
+	StateStopped        NMTState = 0x04
+	StateOperational    NMTState = 0x05
+	StatePreOperational NMTState = 0x7F
+	// StateUnknown is used for nodes never heard of.
+	StateUnknown NMTState = 0xFF
+)
+
+func (s NMTState) String() string {
+	switch s {
+	case StateBootUp:
+		return "boot-up"
+	case StateStopped:
+		return "stopped"
+	case StateOperational:
+		return "operational"
+	case StatePreOperational:
+		return "pre-operational"
+	case StateUnknown:
+		return "unknown"
+	}
+	return fmt.Sprintf("NMTState(%#02x)", uint8(s))
+}
+
+// NMT is a network management master.
+type NMT struct {
+	bus socketcan.Bus
+}
+
+func (my *NMT) Init(bus socketcan.Bus) *NMT {
+	my.bus = bus
+	return my
+}
+
+// Send sends cmd to node, node 0 addresses all nodes.
+func (my *NMT) Send(cmd NMTCommand, node uint8) error {
+	if node > MaxNodeID {
+		return fmt.Errorf("invalid node-ID %d", node)
+	}
+	f := canframe.Frame{ID: cobNMT, Data: []byte{byte(cmd), node}}
+	_, err := my.bus.SendFrame(&f)
+	return err
+}
+
+// Start switches node to operational, 0 for all nodes.
+func (my *NMT) Start(node uint8) error {
+	return my.Send(NMTStart, node)
+}
+
+// Stop switches node to stopped, 0 for all nodes.
+func (my *NMT) Stop(node uint8) error {
+	return my.Send(NMTStop, node)
+}
+
+// PreOperational switches node to pre-operational, 0 for all nodes.
+func (my *NMT) PreOperational(node uint8) error {
+	return my.Send(NMTPreOperational, node)
+}
+
+// ResetNode resets the application of node, 0 for all nodes.
+func (my *NMT) ResetNode(node uint8) error {
+	return my.Send(NMTResetNode, node)
+}
+
+// ResetCommunication resets the communication of node, 0 for all nodes.
+func (my *NMT) ResetCommunication(node uint8) error {
+	return my.Send(NMTResetCommunication, node)
+}
+
+// HeartbeatFilter passes heartbeats, boot-ups and node guarding responses (0x700 to 0x77F),
+// to use with Can.SetFilter() on the Can a Monitor reads.
+func HeartbeatFilter() socketcan.Filter {
+	return socketcan.NewStdMaskFilter(cobHeartbeat, 0x780)
+}
@@ -0,0 +1,418 @@
+package canopen
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// DataType is a CiA 301 data type, its value is the index of its definition.
+type DataType uint16
+
+const (
+	Boolean        DataType = 0x0001
+	Integer8       DataType = 0x0002
+	Integer16      DataType = 0x0003
+	Integer32      DataType = 0x0004
+	Unsigned8      DataType = 0x0005
+	Unsigned16     DataType = 0x0006
+	Unsigned32     DataType = 0x0007
+	Real32         DataType = 0x0008
+	VisibleString  DataType = 0x0009
+	OctetString    DataType = 0x000A
+	UnicodeString  DataType = 0x000B
+	TimeOfDay      DataType = 0x000C
+	TimeDifference DataType = 0x000D
+	Domain         DataType = 0x000F
+	Integer24      DataType = 0x0010
+	Real64         DataType = 0x0011
+	Integer40      DataType = 0x0012
+	Integer48      DataType = 0x0013
+	Integer56      DataType = 0x0014
+	Integer64      DataType = 0x0015
+	Unsigned24     DataType = 0x0016
+	Unsigned40     DataType = 0x0018
+	Unsigned48     DataType = 0x0019
+	Unsigned56     DataType = 0x001A
+	Unsigned64     DataType = 0x001B
+)
+
+// Size is the encoded size in bytes, 0 for variable length types.
+func (t DataType) Size() int {
+	switch t {
+	case Boolean, Integer8, Unsigned8:
+		return 1
+	case Integer16, Unsigned16:
+		return 2
+	case Integer24, Unsigned24:
+		return 3
+	case Integer32, Unsigned32, Real32:
+		return 4
+	case Integer40, Unsigned40:
+		return 5
+	case Integer48, Unsigned48, TimeOfDay, TimeDifference:
+		return 6
+	case Integer56, Unsigned56:
+		return 7
+	case Integer64, Unsigned64, Real64:
+		return 8
+	}
+	return 0
+}
+
+// IsSigned reports whether t is one of the IntegerN types.
+func (t DataType) IsSigned() bool {
+	switch t {
+	case Integer8, Integer16, Integer24, Integer32, Integer40, Integer48, Integer56, Integer64:
+		return true
+	}
+	return false
+}
+
+// Access is the access type of an entry.
+type Access uint8
+
+const (
+	AccessRO Access = iota
+	AccessWO
+	AccessRW
+	// AccessRWR and AccessRWW are read-write, mapped to TPDOs and RPDOs respectively.
+	AccessRWR
+	AccessRWW
+	AccessConst
+)
+
+func (a Access) Readable() bool {
+	return a != AccessWO
+}
+
+func (a Access) Writable() bool {
+	return a == AccessWO || a == AccessRW || a == AccessRWR || a == AccessRWW
+}
+
+func (a Access) String() string {
+	switch a {
+	case AccessRO:
+		return "ro"
+	case AccessWO:
+		return "wo"
+	case AccessRW:
+		return "rw"
+	case AccessRWR:
+		return "rwr"
+	case AccessRWW:
+		return "rww"
+	case AccessConst:
+		return "const"
+	}
+	return fmt.Sprintf("Access(%d)", uint8(a))
+}
+
+// ObjectType is the kind of an object dictionary index.
+type ObjectType uint8
+
+const (
+	ObjectNull      ObjectType = 0x0
+	ObjectDomain    ObjectType = 0x2
+	ObjectDefType   ObjectType = 0x5
+	ObjectDefStruct ObjectType = 0x6
+	ObjectVar       ObjectType = 0x7
+	ObjectArray     ObjectType = 0x8
+	ObjectRecord    ObjectType = 0x9
+)
+
+// Variable is one entry, an index and subindex.
+// Values are kept in their little endian wire form.
+type Variable struct {
+	Index    uint16
+	Subindex uint8
+	Name     string
+	DataType DataType
+	Access   Access
+	Default  []byte
+	Value    []byte
+	// LowLimit and HighLimit are nil when unlimited.
+	LowLimit   []byte
+	HighLimit  []byte
+	PDOMapping bool
+
+	// OnRead is called before a remote read, it may update the value with Set() or refuse with an AbortCode.
+	// The dictionary is not locked meanwhile, callbacks may Get() and Set() any entry.
+	OnRead func(v *Variable) error
+	// OnWrite is called before a remote write is stored, it may refuse it with an AbortCode.
+	// The dictionary is not locked meanwhile either.
+	OnWrite func(v *Variable, data []byte) error
+}
+
+// Object is one index, a VAR has subindex 0 only.
+type Object struct {
+	Index      uint16
+	Name       string
+	ObjectType ObjectType
+	subs       map[uint8]*Variable
+}
+
+// ObjectDictionary is a device's object dictionary.
+// Read() and Write() are safe for concurrent use, building the dictionary is not.
+type ObjectDictionary struct {
+	mu      sync.Mutex
+	objects map[uint16]*Object
+}
+
+func (od *ObjectDictionary) Init() *ObjectDictionary {
+	od.objects = make(map[uint16]*Object)
+	return od
+}
+
+// AddObject adds an empty ARRAY, RECORD or other object, replacing one at the same index.
+func (od *ObjectDictionary) AddObject(index uint16, name string, typ ObjectType) *Object {
+	obj := &Object{Index: index, Name: name, ObjectType: typ, subs: make(map[uint8]*Variable)}
+	od.objects[index] = obj
+	return obj
+}
+
+// AddVariable adds a VAR object, value starts as the default.
+func (od *ObjectDictionary) AddVariable(index uint16, name string, dt DataType, access Access, def []byte) *Variable {
+	return od.AddObject(index, name, ObjectVar).AddSub(0, name, dt, access, def)
+}
+
+// AddSub adds or replaces a subindex, value starts as the default.
+func (obj *Object) AddSub(subindex uint8, name string, dt DataType, access Access, def []byte) *Variable {
+	v := &Variable{
+		Index:    obj.Index,
+		Subindex: subindex,
+		Name:     name,
+		DataType: dt,
+		Access:   access,
+		Default:  def,
+		Value:    append([]byte(nil), def...),
+	}
+	obj.subs[subindex] = v
+	return v
+}
+
+// Sub returns a subindex.
+func (obj *Object) Sub(subindex uint8) (*Variable, bool) {
+	v, ok := obj.subs[subindex]
+	return v, ok
+}
+
+// Subindices returns the subindices in order.
+func (obj *Object) Subindices() []uint8 {
+	subs := make([]uint8, 0, len(obj.subs))
+	for s := range obj.subs {
+		subs = append(subs, s)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i] < subs[j] })
+	return subs
+}
+
+// Object returns an index.
+func (od *ObjectDictionary) Object(index uint16) (*Object, bool) {
+	obj, ok := od.objects[index]
+	return obj, ok
+}
+
+// Variable returns an entry.
+func (od *ObjectDictionary) Variable(index uint16, subindex uint8) (*Variable, bool) {
+	obj, ok := od.objects[index]
+	if !ok {
+		return nil, false
+	}
+	return obj.Sub(subindex)
+}
+
+// Indices returns the indices in order.
+func (od *ObjectDictionary) Indices() []uint16 {
+	indices := make([]uint16, 0, len(od.objects))
+	for i := range od.objects {
+		indices = append(indices, i)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices
+}
+
+// Read reads an entry as a remote client would, honouring access rights and OnRead.
+// Errors are AbortCodes.
+func (od *ObjectDictionary) Read(index uint16, subindex uint8) ([]byte, error) {
+	od.mu.Lock()
+	v, err := od.lookup(index, subindex)
+	if err == nil && !v.Access.Readable() {
+		err = AbortWriteOnly
+	}
+	od.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if v.OnRead != nil {
+		if err := v.OnRead(v); err != nil {
+			return nil, err
+		}
+	}
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	return append([]byte(nil), v.Value...), nil
+}
+
+// Write writes an entry as a remote client would, honouring access rights, size, limits and OnWrite.
+// Errors are AbortCodes.
+func (od *ObjectDictionary) Write(index uint16, subindex uint8, data []byte) error {
+	v, err := od.check(index, subindex, data)
+	if err != nil {
+		return err
+	}
+	if v.OnWrite != nil {
+		if err := v.OnWrite(v, data); err != nil {
+			return err
+		}
+	}
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	v.Value = append([]byte(nil), data...)
+	return nil
+}
+
+// check looks up an entry for a remote write and validates data.
+func (od *ObjectDictionary) check(index uint16, subindex uint8, data []byte) (*Variable, error) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	v, err := od.lookup(index, subindex)
+	if err != nil {
+		return nil, err
+	}
+	if !v.Access.Writable() {
+		return nil, AbortReadOnly
+	}
+	if size := v.DataType.Size(); size != 0 {
+		if len(data) > size {
+			return nil, AbortTypeTooLong
+		}
+		if len(data) < size {
+			return nil, AbortTypeTooShort
+		}
+		if v.LowLimit != nil && compare(v.DataType, data, v.LowLimit) < 0 {
+			return nil, AbortValueTooLow
+		}
+		if v.HighLimit != nil && compare(v.DataType, data, v.HighLimit) > 0 {
+			return nil, AbortValueTooHigh
+		}
+	}
+	return v, nil
+}
+
+// Set stores a value locally, bypassing access rights and callbacks.
+func (od *ObjectDictionary) Set(index uint16, subindex uint8, data []byte) error {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	v, err := od.lookup(index, subindex)
+	if err != nil {
+		return err
+	}
+	v.Value = append([]byte(nil), data...)
+	return nil
+}
+
+// Get returns a value locally, bypassing access rights and callbacks.
+func (od *ObjectDictionary) Get(index uint16, subindex uint8) ([]byte, error) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	v, err := od.lookup(index, subindex)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), v.Value...), nil
+}
+
+// exists returns the abort code of a missing entry, nil if it exists.
+func (od *ObjectDictionary) exists(index uint16, subindex uint8) error {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	_, err := od.lookup(index, subindex)
+	return err
+}
+
+func (od *ObjectDictionary) lookup(index uint16, subindex uint8) (*Variable, error) {
+	obj, ok := od.objects[index]
+	if !ok {
+		return nil, AbortObjectDoesNotExist
+	}
+	v, ok := obj.subs[subindex]
+	if !ok {
+		return nil, AbortSubindexDoesNotExist
+	}
+	return v, nil
+}
+
+// Value encoding.
+
+// EncodeUint encodes v in the size of dt.
+func EncodeUint(dt DataType, v uint64) []byte {
+	b := make([]byte, dt.Size())
+	for i := range b {
+		b[i] = byte(v >> (8 * i))
+	}
+	return b
+}
+
+// EncodeFloat encodes v as Real32 or Real64.
+func EncodeFloat(dt DataType, v float64) []byte {
+	if dt == Real32 {
+		return EncodeUint(Real32, uint64(math.Float32bits(float32(v))))
+	}
+	return EncodeUint(Real64, math.Float64bits(v))
+}
+
+// DecodeUint decodes little endian bytes.
+func DecodeUint(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+// DecodeInt decodes little endian bytes, sign extended.
+func DecodeInt(b []byte) int64 {
+	if len(b) == 0 {
+		return 0
+	}
+	shift := 64 - 8*uint(len(b))
+	return int64(DecodeUint(b)<<shift) >> shift
+}
+
+// DecodeFloat decodes a Real32 or Real64.
+func DecodeFloat(b []byte) float64 {
+	if len(b) == 4 {
+		return float64(math.Float32frombits(uint32(DecodeUint(b))))
+	}
+	return math.Float64frombits(DecodeUint(b))
+}
+
+// compare orders two encoded values of dt.
+func compare(dt DataType, a, b []byte) int {
+	switch {
+	case dt == Real32 || dt == Real64:
+		x, y := DecodeFloat(a), DecodeFloat(b)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	case dt.IsSigned():
+		x, y := DecodeInt(a), DecodeInt(b)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	default:
+		x, y := DecodeUint(a), DecodeUint(b)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+	return 0
+}
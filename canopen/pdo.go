@@ -0,0 +1,572 @@
+package canopen
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// Function codes of the default PDO COB-IDs, PDO n of a node is the code plus (n-1)*0x100 plus its node-ID.
+const (
+	cobTPDO1 = 0x180
+	cobRPDO1 = 0x200
+)
+
+// Object dictionary indices of PDO parameters, PDO n is at the index plus n-1.
+const (
+	idxRPDOComm    = 0x1400
+	idxRPDOMapping = 0x1600
+	idxTPDOComm    = 0x1800
+	idxTPDOMapping = 0x1A00
+)
+
+// Bits of a PDO COB-ID entry.
+const (
+	PDOInvalid  = 0x80000000
+	PDONoRTR    = 0x40000000
+	PDOExtended = 0x20000000
+
+	cobIDMask = 0x1FFFFFFF
+)
+
+// Transmission types, 1 to 240 are synchronous every n-th SYNC.
+const (
+	TransmissionSyncAcyclic = 0
+	TransmissionRTRSync     = 252
+	TransmissionRTREvent    = 253
+	TransmissionEventMfr    = 254
+	TransmissionEventDevice = 255
+)
+
+// MaxPDOMappings is the most objects a PDO maps.
+const MaxPDOMappings = 64
+
+// DefaultTPDO returns the default COB-ID of TPDO n, 1 to 4, of node.
+func DefaultTPDO(n int, node uint8) uint32 {
+	return cobTPDO1 + uint32(n-1)*0x100 + uint32(node)
+}
+
+// DefaultRPDO returns the default COB-ID of RPDO n, 1 to 4, of node.
+func DefaultRPDO(n int, node uint8) uint32 {
+	return cobRPDO1 + uint32(n-1)*0x100 + uint32(node)
+}
+
+// Mapping maps an object into a PDO, Bits long.
+// Indices below 0x1000 are dummy entries that only take up space.
+type Mapping struct {
+	Index    uint16
+	Subindex uint8
+	Bits     uint8
+}
+
+func (m Mapping) uint32() uint32 {
+	return uint32(m.Index)<<16 | uint32(m.Subindex)<<8 | uint32(m.Bits)
+}
+
+func mappingOf(v uint32) Mapping {
+	return Mapping{Index: uint16(v >> 16), Subindex: uint8(v >> 8), Bits: uint8(v)}
+}
+
+// variable returns the mapped entry, which must not be shorter than the mapping.
+func (m Mapping) variable(od *ObjectDictionary) (*Variable, error) {
+	v, ok := od.Variable(m.Index, m.Subindex)
+	if !ok {
+		return nil, fmt.Errorf("pdo mapping %#04x:%d: %w", m.Index, m.Subindex, AbortObjectDoesNotExist)
+	}
+	if size := v.DataType.Size(); size != 0 && int(m.Bits) > size*8 {
+		return nil, fmt.Errorf("pdo mapping %#04x:%d: %d bits, the entry has %d", m.Index, m.Subindex, m.Bits, size*8)
+	}
+	return v, nil
+}
+
+// PDO holds the communication and mapping parameters of one PDO.
+type PDO struct {
+	// COBID includes the PDOInvalid, PDONoRTR and PDOExtended bits.
+	COBID            uint32
+	TransmissionType uint8
+	// InhibitTime is in 100µs units, TPDOs only.
+	InhibitTime uint16
+	// EventTimer is in ms, 0 disables it.
+	EventTimer uint16
+	// SyncStart is the SYNC counter value of the first transmission, TPDOs only.
+	SyncStart uint8
+	Mappings  []Mapping
+
+	// Transmission state in a PDOExchange.
+	pending  bool
+	started  bool
+	syncs    int
+	lastSent time.Time
+}
+
+// ID returns the CAN identifier and whether it is extended.
+func (p *PDO) ID() (id uint32, extended bool) {
+	return p.COBID & cobIDMask, p.COBID&PDOExtended != 0
+}
+
+// Valid tells whether the PDO exists.
+func (p *PDO) Valid() bool {
+	return p.COBID&PDOInvalid == 0
+}
+
+// Synchronous tells whether the PDO is transmitted on SYNC.
+func (p *PDO) Synchronous() bool {
+	return p.TransmissionType <= 240 || p.TransmissionType == TransmissionRTRSync
+}
+
+// Len returns the length of the PDO data in bytes.
+func (p *PDO) Len() int {
+	bits := 0
+	for _, m := range p.Mappings {
+		bits += int(m.Bits)
+	}
+	return (bits + 7) / 8
+}
+
+// check checks the mappings against od.
+func (p *PDO) check(od *ObjectDictionary) error {
+	for _, m := range p.Mappings {
+		if m.Index >= 0x1000 {
+			if _, err := m.variable(od); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Decode stores the mapped values in data into od, bypassing access rights.
+func (p *PDO) Decode(od *ObjectDictionary, data []byte) error {
+	if len(data) < p.Len() {
+		return fmt.Errorf("pdo %#x: %d bytes, mapping needs %d", p.COBID&cobIDMask, len(data), p.Len())
+	}
+	pos := 0
+	for _, m := range p.Mappings {
+		if m.Index >= 0x1000 {
+			v, err := m.variable(od)
+			if err != nil {
+				return err
+			}
+			size := v.DataType.Size()
+			if size == 0 {
+				size = (int(m.Bits) + 7) / 8
+			}
+			b := make([]byte, size)
+			copyBits(b, 0, data, pos, int(m.Bits))
+			// IntegerN values mapped shorter than their type keep their sign.
+			if v.DataType.IsSigned() && m.Bits > 0 && int(m.Bits) < size*8 && b[(m.Bits-1)/8]&(1<<((m.Bits-1)%8)) != 0 {
+				for i := int(m.Bits); i < size*8; i++ {
+					b[i/8] |= 1 << (i % 8)
+				}
+			}
+			if err := od.Set(m.Index, m.Subindex, b); err != nil {
+				return err
+			}
+		}
+		pos += int(m.Bits)
+	}
+	return nil
+}
+
+// Encode packs the mapped values from od.
+func (p *PDO) Encode(od *ObjectDictionary) ([]byte, error) {
+	if p.Len() > canframe.FRAME_MAX_DATA_LEN {
+		return nil, fmt.Errorf("pdo mapping of %d bytes is too long", p.Len())
+	}
+	data := make([]byte, p.Len())
+	pos := 0
+	for _, m := range p.Mappings {
+		if m.Index >= 0x1000 {
+			if _, err := m.variable(od); err != nil {
+				return nil, err
+			}
+			b, err := od.Get(m.Index, m.Subindex)
+			if err != nil {
+				return nil, fmt.Errorf("pdo mapping %#04x:%d: %w", m.Index, m.Subindex, err)
+			}
+			if len(b)*8 < int(m.Bits) {
+				b = append(b, make([]byte, (int(m.Bits)+7)/8-len(b))...)
+			}
+			copyBits(data, pos, b, 0, int(m.Bits))
+		}
+		pos += int(m.Bits)
+	}
+	return data, nil
+}
+
+// Remote configuration.
+
+// ReadRPDO reads the parameters of RPDO n, 1 to 512, from a node.
+func ReadRPDO(sdo *SDOClient, n int) (PDO, error) {
+	return readPDO(sdo, idxRPDOComm+uint16(n-1), idxRPDOMapping+uint16(n-1), false)
+}
+
+// ReadTPDO reads the parameters of TPDO n, 1 to 512, from a node.
+func ReadTPDO(sdo *SDOClient, n int) (PDO, error) {
+	return readPDO(sdo, idxTPDOComm+uint16(n-1), idxTPDOMapping+uint16(n-1), true)
+}
+
+// ConfigureRPDO writes the parameters of RPDO n, 1 to 512, to a node.
+// Event timer is written only when not 0, as not every node has it.
+func ConfigureRPDO(sdo *SDOClient, n int, p *PDO) error {
+	return configurePDO(sdo, idxRPDOComm+uint16(n-1), idxRPDOMapping+uint16(n-1), p, false)
+}
+
+// ConfigureTPDO writes the parameters of TPDO n, 1 to 512, to a node.
+// Inhibit time, event timer and SYNC start are written only when not 0, as not every node has them.
+func ConfigureTPDO(sdo *SDOClient, n int, p *PDO) error {
+	return configurePDO(sdo, idxTPDOComm+uint16(n-1), idxTPDOMapping+uint16(n-1), p, true)
+}
+
+func readPDO(sdo *SDOClient, comm, mapping uint16, tx bool) (PDO, error) {
+	var p PDO
+	var err error
+	if p.COBID, err = sdo.ReadUint32(comm, 1); err != nil {
+		return p, err
+	}
+	if p.TransmissionType, err = sdo.ReadUint8(comm, 2); err != nil {
+		return p, err
+	}
+	// Optional entries, missing ones stay 0.
+	if tx {
+		p.InhibitTime, _ = sdo.ReadUint16(comm, 3)
+		p.SyncStart, _ = sdo.ReadUint8(comm, 6)
+	}
+	p.EventTimer, _ = sdo.ReadUint16(comm, 5)
+
+	count, err := sdo.ReadUint8(mapping, 0)
+	if err != nil {
+		return p, err
+	}
+	for i := 1; i <= int(count); i++ {
+		v, err := sdo.ReadUint32(mapping, uint8(i))
+		if err != nil {
+			return p, err
+		}
+		p.Mappings = append(p.Mappings, mappingOf(v))
+	}
+	return p, nil
+}
+
+// configurePDO follows CiA 301: invalidate the PDO, write the parameters, disable the mapping,
+// write the entries, enable the mapping and finally write the COB-ID.
+func configurePDO(sdo *SDOClient, comm, mapping uint16, p *PDO, tx bool) error {
+	if len(p.Mappings) > MaxPDOMappings {
+		return fmt.Errorf("%d pdo mappings, at most %d", len(p.Mappings), MaxPDOMappings)
+	}
+	if err := sdo.WriteUint32(comm, 1, p.COBID|PDOInvalid); err != nil {
+		return err
+	}
+	if err := sdo.WriteUint8(comm, 2, p.TransmissionType); err != nil {
+		return err
+	}
+	if tx && p.InhibitTime != 0 {
+		if err := sdo.WriteUint16(comm, 3, p.InhibitTime); err != nil {
+			return err
+		}
+	}
+	if p.EventTimer != 0 {
+		if err := sdo.WriteUint16(comm, 5, p.EventTimer); err != nil {
+			return err
+		}
+	}
+	if tx && p.SyncStart != 0 {
+		if err := sdo.WriteUint8(comm, 6, p.SyncStart); err != nil {
+			return err
+		}
+	}
+
+	if err := sdo.WriteUint8(mapping, 0, 0); err != nil {
+		return err
+	}
+	for i, m := range p.Mappings {
+		if err := sdo.WriteUint32(mapping, uint8(i+1), m.uint32()); err != nil {
+			return err
+		}
+	}
+	if err := sdo.WriteUint8(mapping, 0, uint8(len(p.Mappings))); err != nil {
+		return err
+	}
+	if p.Valid() {
+		return sdo.WriteUint32(comm, 1, p.COBID)
+	}
+	return nil
+}
+
+// PDOExchange receives and transmits PDOs, keeping the mapped values in an ObjectDictionary.
+// As a master the dictionary mirrors the nodes, e.g. loaded from their EDS files,
+// the nodes' TPDOs are received and their RPDOs transmitted.
+type PDOExchange struct {
+	bus  socketcan.Bus
+	od   *ObjectDictionary
+	mu   sync.Mutex
+	rx   map[uint32]*PDO
+	tx   []*PDO
+	stop chan struct{}
+
+	// OnReceive is called from Run() after a received PDO was decoded into the dictionary.
+	OnReceive func(p *PDO)
+	// OnError is called from Run() for PDOs that could not be decoded or sent.
+	OnError func(p *PDO, err error)
+}
+
+func (my *PDOExchange) Init(bus socketcan.Bus, od *ObjectDictionary) *PDOExchange {
+	my.bus = bus
+	my.od = od
+	my.rx = make(map[uint32]*PDO)
+	my.stop = make(chan struct{})
+	return my
+}
+
+// AddReceive decodes frames with the COB-ID of p into the dictionary.
+// It fails for mappings of missing entries or longer than their entry.
+func (my *PDOExchange) AddReceive(p *PDO) error {
+	if err := p.check(my.od); err != nil {
+		return err
+	}
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	my.rx[p.COBID&(cobIDMask|PDOExtended)] = p
+	return nil
+}
+
+// AddTransmit sends p on SYNC, on its event timer, on remote request or by Transmit().
+// It fails like AddReceive().
+func (my *PDOExchange) AddTransmit(p *PDO) error {
+	if err := p.check(my.od); err != nil {
+		return err
+	}
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	my.tx = append(my.tx, p)
+	return nil
+}
+
+// Transmit sends p now, or at the next SYNC for synchronous acyclic PDOs.
+// Within the inhibit time it is sent by Run() when the inhibit time is over.
+func (my *PDOExchange) Transmit(p *PDO) error {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	if p.Synchronous() || time.Since(p.lastSent) < p.inhibit() {
+		p.pending = true
+		return nil
+	}
+	return my.send(p)
+}
+
+// Sync sends the synchronous PDOs due at a SYNC with counter, 0 without counter.
+// Run() calls it for received SYNCs, set it as SyncProducer.OnSync when producing them.
+func (my *PDOExchange) Sync(counter uint8) error {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	var errs []error
+	for _, p := range my.tx {
+		if !p.Valid() || !p.Synchronous() {
+			continue
+		}
+		due := p.pending
+		if p.TransmissionType != TransmissionSyncAcyclic && p.TransmissionType != TransmissionRTRSync {
+			switch {
+			case !p.started:
+				if p.SyncStart != 0 && counter != 0 && counter != p.SyncStart {
+					continue
+				}
+				p.started = true
+				p.syncs = 0
+				due = true
+			default:
+				p.syncs++
+				if p.syncs >= int(p.TransmissionType) {
+					p.syncs = 0
+					due = true
+				}
+			}
+		}
+		if due {
+			if err := my.send(p); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run will block exchanging PDOs until Stop() was called or the bus failed.
+func (my *PDOExchange) Run() error {
+	for {
+		select {
+		case <-my.stop:
+			return nil
+		default:
+		}
+
+		f, err := socketcan.RcvFrameUntil(my.bus, my.next())
+		if err != nil && !socketcan.IsTimeout(err) {
+			return err
+		}
+		if err == nil {
+			my.handle(&f)
+		}
+		my.timers()
+	}
+}
+
+// Stop makes Run() return.
+func (my *PDOExchange) Stop() {
+	close(my.stop)
+}
+
+// PDOExchange private.
+
+func (my *PDOExchange) handle(f *canframe.Frame) {
+	if f.IsError {
+		return
+	}
+	if !f.IsExtended && f.ID == cobSYNC && !f.IsRemote {
+		var counter uint8
+		if len(f.Data) > 0 {
+			counter = f.Data[0]
+		}
+		if err := my.Sync(counter); err != nil {
+			my.report(nil, err)
+		}
+		return
+	}
+
+	key := f.ID
+	if f.IsExtended {
+		key |= PDOExtended
+	}
+	if f.IsRemote {
+		my.remote(key)
+		return
+	}
+	my.mu.Lock()
+	p, ok := my.rx[key]
+	my.mu.Unlock()
+	if !ok || !p.Valid() {
+		return
+	}
+	if err := p.Decode(my.od, f.Data); err != nil {
+		my.report(p, err)
+		return
+	}
+	if my.OnReceive != nil {
+		my.OnReceive(p)
+	}
+}
+
+// remote answers a remote request for one of our PDOs.
+func (my *PDOExchange) remote(key uint32) {
+	my.mu.Lock()
+	var failed []*PDO
+	var errs []error
+	for _, p := range my.tx {
+		if p.COBID&(cobIDMask|PDOExtended) != key || !p.Valid() || p.COBID&PDONoRTR != 0 {
+			continue
+		}
+		if p.TransmissionType == TransmissionRTRSync {
+			p.pending = true
+		} else if err := my.send(p); err != nil {
+			failed = append(failed, p)
+			errs = append(errs, err)
+		}
+	}
+	my.mu.Unlock()
+	for i := range failed {
+		my.report(failed[i], errs[i])
+	}
+}
+
+// timers sends event driven PDOs whose event timer expired or whose inhibit time is over.
+func (my *PDOExchange) timers() {
+	my.mu.Lock()
+	var failed []*PDO
+	var errs []error
+	now := time.Now()
+	for _, p := range my.tx {
+		if !p.Valid() || p.Synchronous() {
+			continue
+		}
+		if now.Sub(p.lastSent) < p.inhibit() {
+			continue
+		}
+		if p.pending || (p.EventTimer != 0 && now.Sub(p.lastSent) >= p.eventTimer()) {
+			if err := my.send(p); err != nil {
+				failed = append(failed, p)
+				errs = append(errs, err)
+			}
+		}
+	}
+	my.mu.Unlock()
+	for i := range failed {
+		my.report(failed[i], errs[i])
+	}
+}
+
+// next returns when timers() has to run next.
+func (my *PDOExchange) next() time.Time {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	next := time.Now().Add(monitorTick)
+	for _, p := range my.tx {
+		if !p.Valid() || p.Synchronous() {
+			continue
+		}
+		if p.pending {
+			if t := p.lastSent.Add(p.inhibit()); t.Before(next) {
+				next = t
+			}
+		}
+		if p.EventTimer != 0 {
+			if t := p.lastSent.Add(p.eventTimer()); t.Before(next) {
+				next = t
+			}
+		}
+	}
+	return next
+}
+
+// send encodes and sends p. The caller holds the lock.
+func (my *PDOExchange) send(p *PDO) error {
+	data, err := p.Encode(my.od)
+	if err != nil {
+		return err
+	}
+	id, extended := p.ID()
+	f := canframe.Frame{ID: id, IsExtended: extended, Data: data}
+	p.pending = false
+	p.lastSent = time.Now()
+	_, err = my.bus.SendFrame(&f)
+	return err
+}
+
+func (my *PDOExchange) report(p *PDO, err error) {
+	if my.OnError != nil {
+		my.OnError(p, err)
+	}
+}
+
+func (p *PDO) inhibit() time.Duration {
+	return time.Duration(p.InhibitTime) * 100 * time.Microsecond
+}
+
+func (p *PDO) eventTimer() time.Duration {
+	return time.Duration(p.EventTimer) * time.Millisecond
+}
+
+// copyBits copies n bits from src at bit srcPos to dst at bit dstPos, LSB first.
+func copyBits(dst []byte, dstPos int, src []byte, srcPos int, n int) {
+	for i := 0; i < n; i++ {
+		s, d := srcPos+i, dstPos+i
+		if src[s/8]&(1<<(s%8)) != 0 {
+			dst[d/8] |= 1 << (d % 8)
+		} else {
+			dst[d/8] &^= 1 << (d % 8)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package canopen
+
+import (
+	"fmt"
+)
+
+// SDO COB-IDs are these plus the server's node-ID.
+const (
+	cobSDOTx = 0x580 // server to client
+	cobSDORx = 0x600 // client to server
+)
+
+// SDO client command specifiers, the top 3 bits of the first byte.
+const (
+	ccsDownloadSegment  = 0 << 5
+	ccsInitiateDownload = 1 << 5
+	ccsInitiateUpload   = 2 << 5
+	ccsUploadSegment    = 3 << 5
+	ccsBlockUpload      = 5 << 5
+	ccsBlockDownload    = 6 << 5
+)
+
+// SDO server command specifiers.
+const (
+	scsUploadSegment    = 0 << 5
+	scsDownloadSegment  = 1 << 5
+	scsInitiateUpload   = 2 << 5
+	scsInitiateDownload = 3 << 5
+	scsBlockDownload    = 5 << 5
+	scsBlockUpload      = 6 << 5
+)
+
+const (
+	sdoAbort  = 4 << 5
+	sdoCSMask = 7 << 5
+)
+
+// Block transfer subcommands.
+const (
+	blockInitiate = 0
+	blockEnd      = 1
+	blockAck      = 2
+	blockStart    = 3
+)
+
+// Longest block transfer sub-block.
+const maxBlockSize = 127
+
+// AbortCode is an SDO abort code, it is an error itself so callers can errors.Is() a specific code.
+type AbortCode uint32
+
+const (
+	AbortToggleBit             AbortCode = 0x05030000
+	AbortTimeout               AbortCode = 0x05040000
+	AbortCommand               AbortCode = 0x05040001
+	AbortBlockSize             AbortCode = 0x05040002
+	AbortSequenceNumber        AbortCode = 0x05040003
+	AbortCRC                   AbortCode = 0x05040004
+	AbortOutOfMemory           AbortCode = 0x05040005
+	AbortUnsupportedAccess     AbortCode = 0x06010000
+	AbortWriteOnly             AbortCode = 0x06010001
+	AbortReadOnly              AbortCode = 0x06010002
+	AbortObjectDoesNotExist    AbortCode = 0x06020000
+	AbortNotMappable           AbortCode = 0x06040041
+	AbortPDOLength             AbortCode = 0x06040042
+	AbortParameterIncompatible AbortCode = 0x06040043
+	AbortDeviceIncompatible    AbortCode = 0x06040047
+	AbortHardware              AbortCode = 0x06060000
+	AbortTypeMismatch          AbortCode = 0x06070010
+	AbortTypeTooLong           AbortCode = 0x06070012
+	AbortTypeTooShort          AbortCode = 0x06070013
+	AbortSubindexDoesNotExist  AbortCode = 0x06090011
+	AbortInvalidValue          AbortCode = 0x06090030
+	AbortValueTooHigh          AbortCode = 0x06090031
+	AbortValueTooLow           AbortCode = 0x06090032
+	AbortMaxBelowMin           AbortCode = 0x06090036
+	AbortResourceNotAvailable  AbortCode = 0x060A0023
+	AbortGeneral               AbortCode = 0x08000000
+	AbortDataTransfer          AbortCode = 0x08000020
+	AbortLocalControl          AbortCode = 0x08000021
+	AbortDeviceState           AbortCode = 0x08000022
+	AbortNoObjectDictionary    AbortCode = 0x08000023
+	AbortNoData                AbortCode = 0x08000024
+)
+
+var abortTexts = map[AbortCode]string{
+	AbortToggleBit:             "toggle bit not alternated",
+	AbortTimeout:               "SDO protocol timed out",
+	AbortCommand:               "client/server command specifier not valid or unknown",
+	AbortBlockSize:             "invalid block size",
+	AbortSequenceNumber:        "invalid sequence number",
+	AbortCRC:                   "CRC error",
+	AbortOutOfMemory:           "out of memory",
+	AbortUnsupportedAccess:     "unsupported access to an object",
+	AbortWriteOnly:             "attempt to read a write only object",
+	AbortReadOnly:              "attempt to write a read only object",
+	AbortObjectDoesNotExist:    "object does not exist in the object dictionary",
+	AbortNotMappable:           "object cannot be mapped to the PDO",
+	AbortPDOLength:             "number and length of objects to be mapped would exceed PDO length",
+	AbortParameterIncompatible: "general parameter incompatibility",
+	AbortDeviceIncompatible:    "general internal incompatibility in the device",
+	AbortHardware:              "access failed due to a hardware error",
+	AbortTypeMismatch:          "data type does not match, length of service parameter does not match",
+	AbortTypeTooLong:           "data type does not match, length of service parameter too high",
+	AbortTypeTooShort:          "data type does not match, length of service parameter too low",
+	AbortSubindexDoesNotExist:  "sub-index does not exist",
+	AbortInvalidValue:          "invalid value for parameter",
+	AbortValueTooHigh:          "value of parameter written too high",
+	AbortValueTooLow:           "value of parameter written too low",
+	AbortMaxBelowMin:           "maximum value is less than minimum value",
+	AbortResourceNotAvailable:  "resource not available: SDO connection",
+	AbortGeneral:               "general error",
+	AbortDataTransfer:          "data cannot be transferred or stored to the application",
+	AbortLocalControl:          "data cannot be transferred or stored to the application because of local control",
+	AbortDeviceState:           "data cannot be transferred or stored to the application because of the present device state",
+	AbortNoObjectDictionary:    "object dictionary dynamic generation fails or no object dictionary is present",
+	AbortNoData:                "no data available",
+}
+
+func (c AbortCode) Error() string {
+	text, ok := abortTexts[c]
+	if !ok {
+		text = "unknown abort code"
+	}
+	return fmt.Sprintf("%s (%#08x)", text, uint32(c))
+}
+
+// AbortError is an aborted SDO transfer, it unwraps to its AbortCode.
+type AbortError struct {
+	Index    uint16
+	Subindex uint8
+	Code     AbortCode
+	// Remote is true when the server aborted, false when we did.
+	Remote bool
+}
+
+func (e *AbortError) Error() string {
+	by := "client"
+	if e.Remote {
+		by = "server"
+	}
+	return fmt.Sprintf("SDO %04Xh:%02Xh aborted by %s: %v", e.Index, e.Subindex, by, e.Code)
+}
+
+func (e *AbortError) Unwrap() error {
+	return e.Code
+}
+
+// crc16 is the CRC-16-CCITT (XMODEM) of block transfers.
+func crc16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func sdoFrame(cmd byte, index uint16, subindex uint8, data []byte) []byte {
+	d := []byte{cmd, byte(index), byte(index >> 8), subindex, 0, 0, 0, 0}
+	copy(d[4:], data)
+	return d
+}
+
+func le32(v uint32) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+}
+
+func getLE32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
@@ -0,0 +1,439 @@
+package canopen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// DefaultSDOTimeout is how long the client waits for each server response.
+const DefaultSDOTimeout = 1 * time.Second
+
+// SDOClient reads and writes the object dictionary of one node.
+// It is not safe for concurrent use.
+type SDOClient struct {
+	bus  socketcan.Bus
+	txID uint32
+	rxID uint32
+
+	index    uint16
+	subindex uint8
+
+	Timeout time.Duration
+	// BlockSize is the sub-block size we ask for in block uploads, 1 to 127.
+	BlockSize uint8
+}
+
+// node is the node-ID of the server, using the default SDO COB-IDs.
+func (my *SDOClient) Init(bus socketcan.Bus, node uint8) *SDOClient {
+	my.bus = bus
+	my.txID = cobSDORx + uint32(node)
+	my.rxID = cobSDOTx + uint32(node)
+	my.Timeout = DefaultSDOTimeout
+	my.BlockSize = maxBlockSize
+	return my
+}
+
+// Upload reads an entry, expedited or segmented as the server chooses.
+func (my *SDOClient) Upload(index uint16, subindex uint8) ([]byte, error) {
+	my.index, my.subindex = index, subindex
+	res, err := my.request(sdoFrame(ccsInitiateUpload, index, subindex, nil), scsInitiateUpload, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expedited.
+	if res[0]&0x02 != 0 {
+		n := 4
+		if res[0]&0x01 != 0 {
+			n = 4 - int(res[0]>>2&0x3)
+		}
+		return append([]byte(nil), res[4:4+n]...), nil
+	}
+
+	var data []byte
+	if res[0]&0x01 != 0 {
+		data = make([]byte, 0, getLE32(res[4:]))
+	}
+	toggle := byte(0)
+	for {
+		res, err := my.request([]byte{ccsUploadSegment | toggle, 0, 0, 0, 0, 0, 0, 0}, scsUploadSegment, false)
+		if err != nil {
+			return nil, err
+		}
+		if res[0]&0x10 != toggle {
+			return nil, my.abort(AbortToggleBit)
+		}
+		n := 7 - int(res[0]>>1&0x7)
+		data = append(data, res[1:1+n]...)
+		if res[0]&0x01 != 0 {
+			return data, nil
+		}
+		toggle ^= 0x10
+	}
+}
+
+// Download writes an entry, expedited from 1 to 4 bytes and segmented otherwise.
+func (my *SDOClient) Download(index uint16, subindex uint8, data []byte) error {
+	my.index, my.subindex = index, subindex
+	if len(data) > 0 && len(data) <= 4 {
+		cmd := byte(ccsInitiateDownload|0x03) | byte(4-len(data))<<2
+		_, err := my.request(sdoFrame(cmd, index, subindex, data), scsInitiateDownload, true)
+		return err
+	}
+
+	_, err := my.request(sdoFrame(ccsInitiateDownload|0x01, index, subindex, le32(uint32(len(data)))), scsInitiateDownload, true)
+	if err != nil {
+		return err
+	}
+	toggle := byte(0)
+	for off, last := 0, false; !last; off += 7 {
+		seg := data[off:]
+		last = len(seg) <= 7
+		if !last {
+			seg = seg[:7]
+		}
+		cmd := ccsDownloadSegment | toggle | byte(7-len(seg))<<1
+		if last {
+			cmd |= 0x01
+		}
+		d := make([]byte, 8)
+		d[0] = cmd
+		copy(d[1:], seg)
+		res, err := my.request(d, scsDownloadSegment, false)
+		if err != nil {
+			return err
+		}
+		if res[0]&0x10 != toggle {
+			return my.abort(AbortToggleBit)
+		}
+		toggle ^= 0x10
+	}
+	return nil
+}
+
+// BlockUpload reads an entry with a block transfer, checking the CRC if the server supports it.
+func (my *SDOClient) BlockUpload(index uint16, subindex uint8) ([]byte, error) {
+	my.index, my.subindex = index, subindex
+	blockSize := my.BlockSize
+	if blockSize == 0 || blockSize > maxBlockSize {
+		blockSize = maxBlockSize
+	}
+	req := sdoFrame(ccsBlockUpload|0x04|blockInitiate, index, subindex, []byte{blockSize, 0})
+	res, err := my.request(req, scsBlockUpload, true)
+	if err != nil {
+		return nil, err
+	}
+	withCRC := res[0]&0x04 != 0
+	var data []byte
+	if res[0]&0x02 != 0 {
+		data = make([]byte, 0, getLE32(res[4:]))
+	}
+
+	if err := my.send([]byte{ccsBlockUpload | blockStart, 0, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+	var last []byte
+	for done := false; !done; {
+		// One sub-block.
+		seq := byte(0)
+		var block []byte
+		for {
+			d, err := my.receive()
+			if err != nil {
+				return nil, err
+			}
+			if d[0]&0x7F == seq+1 {
+				seq++
+				block = append(block, d[1:8]...)
+				last = d[1:8]
+				done = d[0]&0x80 != 0
+			}
+			// A lost segment is retransmitted after the acknowledgement.
+			if d[0]&0x80 != 0 || d[0]&0x7F == blockSize {
+				break
+			}
+		}
+		data = append(data, block...)
+		if err := my.send([]byte{ccsBlockUpload | blockAck, seq, blockSize, 0, 0, 0, 0, 0}); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err = my.receive()
+	if err != nil {
+		return nil, err
+	}
+	if res[0]&sdoCSMask != scsBlockUpload || res[0]&0x03 != blockEnd {
+		return nil, my.abort(AbortCommand)
+	}
+	// The last segment carries n bytes of padding.
+	n := int(res[0] >> 2 & 0x7)
+	if n > len(last) || n > len(data) {
+		return nil, my.abort(AbortCommand)
+	}
+	data = data[:len(data)-n]
+	if withCRC && crc16(0, data) != uint16(res[1])|uint16(res[2])<<8 {
+		return nil, my.abort(AbortCRC)
+	}
+	if err := my.send([]byte{ccsBlockUpload | blockEnd, 0, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// BlockDownload writes an entry with a block transfer, with CRC if the server supports it.
+func (my *SDOClient) BlockDownload(index uint16, subindex uint8, data []byte) error {
+	my.index, my.subindex = index, subindex
+	req := sdoFrame(ccsBlockDownload|0x04|0x02|blockInitiate, index, subindex, le32(uint32(len(data))))
+	res, err := my.request(req, scsBlockDownload, true)
+	if err != nil {
+		return err
+	}
+	withCRC := res[0]&0x04 != 0
+	blockSize := int(res[4])
+	if blockSize < 1 || blockSize > maxBlockSize {
+		return my.abort(AbortBlockSize)
+	}
+
+	segments := (len(data) + 6) / 7
+	if segments == 0 {
+		segments = 1
+	}
+	for next := 0; next < segments; {
+		var seq int
+		for seq = 1; seq <= blockSize && next+seq-1 < segments; seq++ {
+			i := next + seq - 1
+			d := make([]byte, 8)
+			d[0] = byte(seq)
+			if i == segments-1 {
+				d[0] |= 0x80
+			}
+			end := (i + 1) * 7
+			if end > len(data) {
+				end = len(data)
+			}
+			copy(d[1:], data[i*7:end])
+			if err := my.send(d); err != nil {
+				return err
+			}
+		}
+
+		res, err := my.receive()
+		if err != nil {
+			return err
+		}
+		if res[0]&sdoCSMask != scsBlockDownload || res[0]&0x03 != blockAck {
+			return my.abort(AbortCommand)
+		}
+		// Retransmit what the server did not acknowledge.
+		next += int(res[1])
+		blockSize = int(res[2])
+		if blockSize < 1 || blockSize > maxBlockSize {
+			return my.abort(AbortBlockSize)
+		}
+	}
+
+	n := segments*7 - len(data)
+	if len(data) == 0 {
+		n = 7
+	}
+	var crc uint16
+	if withCRC {
+		crc = crc16(0, data)
+	}
+	res, err = my.request([]byte{ccsBlockDownload | byte(n)<<2 | blockEnd, byte(crc), byte(crc >> 8), 0, 0, 0, 0, 0}, scsBlockDownload, false)
+	if err != nil {
+		return err
+	}
+	if res[0]&0x03 != blockEnd {
+		return my.abort(AbortCommand)
+	}
+	return nil
+}
+
+// Typed helpers, integers and floats are little endian as in the object dictionary.
+
+func (my *SDOClient) ReadUint8(index uint16, subindex uint8) (uint8, error) {
+	v, err := my.readUint(index, subindex, 1)
+	return uint8(v), err
+}
+
+func (my *SDOClient) ReadUint16(index uint16, subindex uint8) (uint16, error) {
+	v, err := my.readUint(index, subindex, 2)
+	return uint16(v), err
+}
+
+func (my *SDOClient) ReadUint32(index uint16, subindex uint8) (uint32, error) {
+	v, err := my.readUint(index, subindex, 4)
+	return uint32(v), err
+}
+
+func (my *SDOClient) ReadUint64(index uint16, subindex uint8) (uint64, error) {
+	return my.readUint(index, subindex, 8)
+}
+
+func (my *SDOClient) ReadInt8(index uint16, subindex uint8) (int8, error) {
+	v, err := my.readUint(index, subindex, 1)
+	return int8(v), err
+}
+
+func (my *SDOClient) ReadInt16(index uint16, subindex uint8) (int16, error) {
+	v, err := my.readUint(index, subindex, 2)
+	return int16(v), err
+}
+
+func (my *SDOClient) ReadInt32(index uint16, subindex uint8) (int32, error) {
+	v, err := my.readUint(index, subindex, 4)
+	return int32(v), err
+}
+
+func (my *SDOClient) ReadInt64(index uint16, subindex uint8) (int64, error) {
+	v, err := my.readUint(index, subindex, 8)
+	return int64(v), err
+}
+
+func (my *SDOClient) ReadFloat32(index uint16, subindex uint8) (float32, error) {
+	v, err := my.readUint(index, subindex, 4)
+	return math.Float32frombits(uint32(v)), err
+}
+
+func (my *SDOClient) ReadFloat64(index uint16, subindex uint8) (float64, error) {
+	v, err := my.readUint(index, subindex, 8)
+	return math.Float64frombits(v), err
+}
+
+// ReadString reads a VISIBLE_STRING, trailing NULs are dropped.
+func (my *SDOClient) ReadString(index uint16, subindex uint8) (string, error) {
+	data, err := my.Upload(index, subindex)
+	if err != nil {
+		return "", err
+	}
+	for len(data) > 0 && data[len(data)-1] == 0 {
+		data = data[:len(data)-1]
+	}
+	return string(data), nil
+}
+
+func (my *SDOClient) WriteUint8(index uint16, subindex uint8, v uint8) error {
+	return my.Download(index, subindex, []byte{v})
+}
+
+func (my *SDOClient) WriteUint16(index uint16, subindex uint8, v uint16) error {
+	return my.Download(index, subindex, []byte{byte(v), byte(v >> 8)})
+}
+
+func (my *SDOClient) WriteUint32(index uint16, subindex uint8, v uint32) error {
+	return my.Download(index, subindex, le32(v))
+}
+
+func (my *SDOClient) WriteUint64(index uint16, subindex uint8, v uint64) error {
+	return my.Download(index, subindex, append(le32(uint32(v)), le32(uint32(v>>32))...))
+}
+
+func (my *SDOClient) WriteInt8(index uint16, subindex uint8, v int8) error {
+	return my.WriteUint8(index, subindex, uint8(v))
+}
+
+func (my *SDOClient) WriteInt16(index uint16, subindex uint8, v int16) error {
+	return my.WriteUint16(index, subindex, uint16(v))
+}
+
+func (my *SDOClient) WriteInt32(index uint16, subindex uint8, v int32) error {
+	return my.WriteUint32(index, subindex, uint32(v))
+}
+
+func (my *SDOClient) WriteInt64(index uint16, subindex uint8, v int64) error {
+	return my.WriteUint64(index, subindex, uint64(v))
+}
+
+func (my *SDOClient) WriteFloat32(index uint16, subindex uint8, v float32) error {
+	return my.WriteUint32(index, subindex, math.Float32bits(v))
+}
+
+func (my *SDOClient) WriteFloat64(index uint16, subindex uint8, v float64) error {
+	return my.WriteUint64(index, subindex, math.Float64bits(v))
+}
+
+func (my *SDOClient) WriteString(index uint16, subindex uint8, v string) error {
+	return my.Download(index, subindex, []byte(v))
+}
+
+// SDOClient private.
+
+func (my *SDOClient) readUint(index uint16, subindex uint8, size int) (uint64, error) {
+	data, err := my.Upload(index, subindex)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != size {
+		return 0, fmt.Errorf("SDO %04Xh:%02Xh: expected %d bytes, got %d bytes", index, subindex, size, len(data))
+	}
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(data[i])
+	}
+	return v, nil
+}
+
+// request sends d and waits for a response with server command specifier scs,
+// withIndex responses have to repeat the index and subindex of the transfer.
+func (my *SDOClient) request(d []byte, scs byte, withIndex bool) ([]byte, error) {
+	if err := my.send(d); err != nil {
+		return nil, err
+	}
+	res, err := my.receive()
+	if err != nil {
+		return nil, err
+	}
+	if res[0]&sdoCSMask != scs {
+		return nil, my.abort(AbortCommand)
+	}
+	if withIndex && (uint16(res[1])|uint16(res[2])<<8 != my.index || res[3] != my.subindex) {
+		return nil, my.abort(AbortCommand)
+	}
+	return res, nil
+}
+
+// receive returns the next response, an abort from the server as *AbortError.
+func (my *SDOClient) receive() ([]byte, error) {
+	deadline := time.Now().Add(my.Timeout)
+	for {
+		f, err := socketcan.RcvFrameUntil(my.bus, deadline)
+		if errors.Is(err, socketcan.ErrTimeout) {
+			return nil, my.abort(AbortTimeout)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if f.ID != my.rxID || f.IsExtended || f.IsRemote || f.IsError || len(f.Data) < 8 {
+			continue
+		}
+		// Exactly 0x80, block segments 0x81 to 0xFF are last segments, not aborts.
+		if f.Data[0] == sdoAbort {
+			return nil, &AbortError{
+				Index:    uint16(f.Data[1]) | uint16(f.Data[2])<<8,
+				Subindex: f.Data[3],
+				Code:     AbortCode(getLE32(f.Data[4:])),
+				Remote:   true,
+			}
+		}
+		return f.Data, nil
+	}
+}
+
+func (my *SDOClient) send(d []byte) error {
+	f := canframe.Frame{ID: my.txID, Data: d}
+	_, err := my.bus.SendFrame(&f)
+	return err
+}
+
+// abort tells the server we give up and returns the matching error.
+func (my *SDOClient) abort(code AbortCode) error {
+	my.send(sdoFrame(sdoAbort, my.index, my.subindex, le32(uint32(code))))
+	return &AbortError{Index: my.index, Subindex: my.subindex, Code: code}
+}
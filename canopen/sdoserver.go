@@ -0,0 +1,368 @@
+package canopen
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// States of a transfer in progress on the server.
+const (
+	sdoIdle = iota
+	sdoSegDownload
+	sdoSegUpload
+	sdoBlkDownload
+	sdoBlkDownloadEnd
+	sdoBlkUploadInit
+	sdoBlkUpload
+	sdoBlkUploadEnd
+)
+
+// SDOServer serves an ObjectDictionary to SDO clients, letting a Go process act as a node.
+type SDOServer struct {
+	bus  socketcan.Bus
+	od   *ObjectDictionary
+	txID uint32
+	rxID uint32
+	stop chan struct{}
+
+	// The transfer in progress.
+	state     int
+	index     uint16
+	subindex  uint8
+	data      []byte
+	size      int
+	toggle    byte
+	withCRC   bool
+	blockSize int
+	seq       int
+	pos       int
+	deadline  time.Time
+
+	// Timeout aborts a transfer when the client stays silent this long.
+	Timeout time.Duration
+	// BlockSize is the sub-block size we ask for in block downloads, 1 to 127.
+	BlockSize uint8
+}
+
+// node is our node-ID, using the default SDO COB-IDs.
+func (my *SDOServer) Init(bus socketcan.Bus, node uint8, od *ObjectDictionary) *SDOServer {
+	my.bus = bus
+	my.od = od
+	my.txID = cobSDOTx + uint32(node)
+	my.rxID = cobSDORx + uint32(node)
+	my.stop = make(chan struct{})
+	my.Timeout = DefaultSDOTimeout
+	my.BlockSize = maxBlockSize
+	return my
+}
+
+// Run will block serving requests until Stop() was called or the bus failed.
+func (my *SDOServer) Run() error {
+	for {
+		select {
+		case <-my.stop:
+			return nil
+		default:
+		}
+
+		f, err := socketcan.RcvFrameUntil(my.bus, time.Now().Add(monitorTick))
+		if errors.Is(err, socketcan.ErrTimeout) {
+			if my.state != sdoIdle && time.Now().After(my.deadline) {
+				my.abort(AbortTimeout)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if f.ID != my.rxID || f.IsExtended || f.IsRemote || f.IsError || len(f.Data) < 8 {
+			continue
+		}
+		my.deadline = time.Now().Add(my.Timeout)
+		if err := my.handle(f.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// Stop makes Run() return.
+func (my *SDOServer) Stop() {
+	close(my.stop)
+}
+
+// SDOServer private.
+
+func (my *SDOServer) handle(d []byte) error {
+	if d[0] == sdoAbort {
+		my.state = sdoIdle
+		return nil
+	}
+
+	// Block download segments have no command specifier.
+	if my.state == sdoBlkDownload {
+		return my.blockSegment(d)
+	}
+
+	cs := d[0] & sdoCSMask
+	switch {
+	case cs == ccsInitiateDownload:
+		return my.initiateDownload(d)
+	case cs == ccsDownloadSegment && my.state == sdoSegDownload:
+		return my.downloadSegment(d)
+	case cs == ccsInitiateUpload:
+		return my.initiateUpload(d)
+	case cs == ccsUploadSegment && my.state == sdoSegUpload:
+		return my.uploadSegment(d)
+	case cs == ccsBlockDownload && d[0]&0x01 == blockInitiate:
+		return my.initiateBlockDownload(d)
+	case cs == ccsBlockDownload && d[0]&0x01 == blockEnd && my.state == sdoBlkDownloadEnd:
+		return my.endBlockDownload(d)
+	case cs == ccsBlockUpload && d[0]&0x03 == blockInitiate:
+		return my.initiateBlockUpload(d)
+	case cs == ccsBlockUpload && d[0]&0x03 == blockStart && my.state == sdoBlkUploadInit:
+		my.state = sdoBlkUpload
+		return my.sendSubBlock()
+	case cs == ccsBlockUpload && d[0]&0x03 == blockAck && my.state == sdoBlkUpload:
+		return my.blockAck(d)
+	case cs == ccsBlockUpload && d[0]&0x03 == blockEnd && my.state == sdoBlkUploadEnd:
+		my.state = sdoIdle
+		return nil
+	}
+	my.index = uint16(d[1]) | uint16(d[2])<<8
+	my.subindex = d[3]
+	return my.abort(AbortCommand)
+}
+
+func (my *SDOServer) initiateDownload(d []byte) error {
+	my.start(d)
+	if d[0]&0x02 != 0 {
+		n := 4
+		if d[0]&0x01 != 0 {
+			n = 4 - int(d[0]>>2&0x3)
+		}
+		if err := my.od.Write(my.index, my.subindex, d[4:4+n]); err != nil {
+			return my.abortWith(err)
+		}
+		return my.send(sdoFrame(scsInitiateDownload, my.index, my.subindex, nil))
+	}
+
+	my.size = -1
+	if d[0]&0x01 != 0 {
+		my.size = int(getLE32(d[4:]))
+	}
+	my.state = sdoSegDownload
+	return my.send(sdoFrame(scsInitiateDownload, my.index, my.subindex, nil))
+}
+
+func (my *SDOServer) downloadSegment(d []byte) error {
+	if d[0]&0x10 != my.toggle {
+		return my.abort(AbortToggleBit)
+	}
+	n := 7 - int(d[0]>>1&0x7)
+	my.data = append(my.data, d[1:1+n]...)
+	res := []byte{scsDownloadSegment | my.toggle, 0, 0, 0, 0, 0, 0, 0}
+	my.toggle ^= 0x10
+	if d[0]&0x01 == 0 {
+		return my.send(res)
+	}
+
+	my.state = sdoIdle
+	if my.size >= 0 && my.size != len(my.data) {
+		return my.abort(AbortTypeMismatch)
+	}
+	if err := my.od.Write(my.index, my.subindex, my.data); err != nil {
+		return my.abortWith(err)
+	}
+	return my.send(res)
+}
+
+func (my *SDOServer) initiateUpload(d []byte) error {
+	my.start(d)
+	data, err := my.od.Read(my.index, my.subindex)
+	if err != nil {
+		return my.abortWith(err)
+	}
+	if len(data) > 0 && len(data) <= 4 {
+		return my.send(sdoFrame(scsInitiateUpload|0x03|byte(4-len(data))<<2, my.index, my.subindex, data))
+	}
+	my.data = data
+	my.state = sdoSegUpload
+	return my.send(sdoFrame(scsInitiateUpload|0x01, my.index, my.subindex, le32(uint32(len(data)))))
+}
+
+func (my *SDOServer) uploadSegment(d []byte) error {
+	if d[0]&0x10 != my.toggle {
+		return my.abort(AbortToggleBit)
+	}
+	n := len(my.data) - my.pos
+	if n > 7 {
+		n = 7
+	}
+	res := make([]byte, 8)
+	res[0] = scsUploadSegment | my.toggle | byte(7-n)<<1
+	copy(res[1:], my.data[my.pos:my.pos+n])
+	my.pos += n
+	my.toggle ^= 0x10
+	if my.pos == len(my.data) {
+		res[0] |= 0x01
+		my.state = sdoIdle
+	}
+	return my.send(res)
+}
+
+func (my *SDOServer) initiateBlockDownload(d []byte) error {
+	my.start(d)
+	if err := my.od.exists(my.index, my.subindex); err != nil {
+		return my.abortWith(err)
+	}
+	my.withCRC = d[0]&0x04 != 0
+	my.size = -1
+	if d[0]&0x02 != 0 {
+		my.size = int(getLE32(d[4:]))
+	}
+	my.blockSize = int(my.BlockSize)
+	if my.blockSize < 1 || my.blockSize > maxBlockSize {
+		my.blockSize = maxBlockSize
+	}
+	my.state = sdoBlkDownload
+	return my.send(sdoFrame(scsBlockDownload|0x04|blockInitiate, my.index, my.subindex, []byte{byte(my.blockSize)}))
+}
+
+func (my *SDOServer) blockSegment(d []byte) error {
+	seq := int(d[0] & 0x7F)
+	last := d[0]&0x80 != 0
+	if seq == my.seq+1 {
+		my.seq = seq
+		my.data = append(my.data, d[1:8]...)
+	} else if !last && seq != my.blockSize {
+		// Out of order, wait for the end of the sub-block and acknowledge what we have.
+		return nil
+	}
+	if !last && seq != my.blockSize {
+		return nil
+	}
+
+	ack := my.seq
+	my.seq = 0
+	if last && seq == ack {
+		my.state = sdoBlkDownloadEnd
+	}
+	return my.send([]byte{scsBlockDownload | blockAck, byte(ack), byte(my.blockSize), 0, 0, 0, 0, 0})
+}
+
+func (my *SDOServer) endBlockDownload(d []byte) error {
+	n := int(d[0] >> 2 & 0x7)
+	if n > len(my.data) {
+		return my.abort(AbortCommand)
+	}
+	data := my.data[:len(my.data)-n]
+	my.state = sdoIdle
+	if my.withCRC && crc16(0, data) != uint16(d[1])|uint16(d[2])<<8 {
+		return my.abort(AbortCRC)
+	}
+	if my.size >= 0 && my.size != len(data) {
+		return my.abort(AbortTypeMismatch)
+	}
+	if err := my.od.Write(my.index, my.subindex, data); err != nil {
+		return my.abortWith(err)
+	}
+	return my.send([]byte{scsBlockDownload | blockEnd, 0, 0, 0, 0, 0, 0, 0})
+}
+
+func (my *SDOServer) initiateBlockUpload(d []byte) error {
+	my.start(d)
+	my.blockSize = int(d[4])
+	if my.blockSize < 1 || my.blockSize > maxBlockSize {
+		return my.abort(AbortBlockSize)
+	}
+	data, err := my.od.Read(my.index, my.subindex)
+	if err != nil {
+		return my.abortWith(err)
+	}
+	my.withCRC = d[0]&0x04 != 0
+	my.data = data
+	my.state = sdoBlkUploadInit
+	return my.send(sdoFrame(scsBlockUpload|0x04|0x02|blockInitiate, my.index, my.subindex, le32(uint32(len(data)))))
+}
+
+// sendSubBlock sends the segments from pos on.
+func (my *SDOServer) sendSubBlock() error {
+	segments := (len(my.data) + 6) / 7
+	if segments == 0 {
+		segments = 1
+	}
+	first := my.pos / 7
+	for seq := 1; seq <= my.blockSize && first+seq-1 < segments; seq++ {
+		i := first + seq - 1
+		d := make([]byte, 8)
+		d[0] = byte(seq)
+		if i == segments-1 {
+			d[0] |= 0x80
+		}
+		end := (i + 1) * 7
+		if end > len(my.data) {
+			end = len(my.data)
+		}
+		copy(d[1:], my.data[i*7:end])
+		if err := my.send(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (my *SDOServer) blockAck(d []byte) error {
+	my.pos += int(d[1]) * 7
+	my.blockSize = int(d[2])
+	if my.blockSize < 1 || my.blockSize > maxBlockSize {
+		return my.abort(AbortBlockSize)
+	}
+	if my.pos < len(my.data) {
+		return my.sendSubBlock()
+	}
+
+	n := (7 - len(my.data)%7) % 7
+	if len(my.data) == 0 {
+		n = 7
+	}
+	var crc uint16
+	if my.withCRC {
+		crc = crc16(0, my.data)
+	}
+	my.state = sdoBlkUploadEnd
+	return my.send([]byte{scsBlockUpload | byte(n)<<2 | blockEnd, byte(crc), byte(crc >> 8), 0, 0, 0, 0, 0})
+}
+
+// start begins a new transfer from an initiate request.
+func (my *SDOServer) start(d []byte) {
+	my.index = uint16(d[1]) | uint16(d[2])<<8
+	my.subindex = d[3]
+	my.state = sdoIdle
+	my.data = nil
+	my.toggle = 0
+	my.seq = 0
+	my.pos = 0
+}
+
+func (my *SDOServer) send(d []byte) error {
+	f := canframe.Frame{ID: my.txID, Data: d}
+	_, err := my.bus.SendFrame(&f)
+	return err
+}
+
+// abortWith aborts with err if it is an AbortCode, or a general error.
+func (my *SDOServer) abortWith(err error) error {
+	var code AbortCode
+	if !errors.As(err, &code) {
+		code = AbortGeneral
+	}
+	return my.abort(code)
+}
+
+func (my *SDOServer) abort(code AbortCode) error {
+	my.state = sdoIdle
+	return my.send(sdoFrame(sdoAbort, my.index, my.subindex, le32(uint32(code))))
+}
@@ -0,0 +1,68 @@
+package canopen
+
+import (
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// SyncProducer sends SYNC messages every communication cycle period.
+type SyncProducer struct {
+	bus      socketcan.Bus
+	period   time.Duration
+	overflow uint8
+	counter  uint8
+	stop     chan struct{}
+
+	// OnSync is called from Run() after every SYNC sent, e.g. to send synchronous PDOs.
+	OnSync func(counter uint8)
+}
+
+// overflow is the synchronous counter overflow value 2 to 240, 0 sends SYNCs without counter.
+func (my *SyncProducer) Init(bus socketcan.Bus, period time.Duration, overflow uint8) *SyncProducer {
+	my.bus = bus
+	my.period = period
+	my.overflow = overflow
+	my.stop = make(chan struct{})
+	return my
+}
+
+// Send sends one SYNC now, returning the counter it carried, 0 without counter.
+func (my *SyncProducer) Send() (uint8, error) {
+	f := canframe.Frame{ID: cobSYNC}
+	if my.overflow != 0 {
+		my.counter++
+		if my.counter > my.overflow {
+			my.counter = 1
+		}
+		f.Data = []byte{my.counter}
+	}
+	_, err := my.bus.SendFrame(&f)
+	return my.counter, err
+}
+
+// Run will block sending SYNCs until Stop() was called or the bus failed.
+func (my *SyncProducer) Run() error {
+	t := time.NewTicker(my.period)
+	defer t.Stop()
+	for {
+		select {
+		case <-my.stop:
+			return nil
+		case <-t.C:
+		}
+		counter, err := my.Send()
+		if err != nil {
+			return err
+		}
+		if my.OnSync != nil {
+			my.OnSync(counter)
+		}
+	}
+}
+
+// Stop makes Run() return.
+func (my *SyncProducer) Stop() {
+	close(my.stop)
+}
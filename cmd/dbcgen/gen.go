@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lion187chen/socketcan-go/dbc"
+)
+
+// How a signal is represented in its struct field.
+const (
+	kindFloat = iota
+	kindInt
+	kindBool
+	kindEnum
+)
+
+type signal struct {
+	*dbc.Signal
+	field string
+	kind  int
+	// typ is the Go type of the field.
+	typ string
+}
+
+type message struct {
+	*dbc.Message
+	name    string
+	signals []*signal
+}
+
+type enum struct {
+	name   string
+	doc    string
+	values map[int64]string
+}
+
+type generator struct {
+	db       *dbc.Database
+	buf      bytes.Buffer
+	messages []*message
+	enums    []*enum
+	useMath  bool
+	useTime  bool
+}
+
+// generate returns the Go source for db in package pkg.
+func generate(db *dbc.Database, pkg, source string) ([]byte, error) {
+	g := &generator{db: db}
+	if err := g.prepare(); err != nil {
+		return nil, err
+	}
+
+	g.constants()
+	for _, e := range g.enums {
+		g.enum(e)
+	}
+	g.dispatch()
+	for _, m := range g.messages {
+		g.message(m)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by dbcgen from %s. DO NOT EDIT.\n\npackage %s\n\nimport (\n\t\"fmt\"\n", source, pkg)
+	if g.useMath {
+		fmt.Fprintf(&out, "\t\"math\"\n")
+	}
+	if g.useTime {
+		fmt.Fprintf(&out, "\t\"time\"\n")
+	}
+	fmt.Fprintf(&out, "\n\t\"github.com/lion187chen/socketcan-go/canframe\"\n\t\"github.com/lion187chen/socketcan-go/dbc\"\n)\n\n")
+	out.Write(g.buf.Bytes())
+	return format.Source(out.Bytes())
+}
+
+// prepare names messages, fields and enums and picks the field types.
+func (g *generator) prepare() error {
+	used := map[string]bool{"Message": true, "Unmarshal": true}
+	for _, t := range g.db.ValueTables {
+		name := unique(used, goName(t.Name))
+		g.enums = append(g.enums, &enum{name: name, doc: name + " is value table " + t.Name + ".", values: t.Values})
+	}
+	for _, dm := range g.db.Messages {
+		if dm.Name == "VECTOR__INDEPENDENT_SIG_MSG" {
+			continue
+		}
+		m := &message{Message: dm, name: unique(used, goName(dm.Name))}
+		used[m.name+"ID"] = true
+		used[m.name+"CycleTime"] = true
+		fields := map[string]bool{"Marshal": true, "Unmarshal": true}
+		for _, ds := range dm.Signals {
+			if ds.Length < 1 || ds.Length > 64 {
+				return fmt.Errorf("%s.%s: length %d", dm.Name, ds.Name, ds.Length)
+			}
+			s := &signal{Signal: ds, field: unique(fields, goName(ds.Name))}
+			g.classify(m, s, used)
+			m.signals = append(m.signals, s)
+		}
+		g.messages = append(g.messages, m)
+	}
+	return nil
+}
+
+func (g *generator) classify(m *message, s *signal, used map[string]bool) {
+	integral := s.Min == math.Trunc(s.Min) && s.Max == math.Trunc(s.Max)
+	switch {
+	case len(s.Values) != 0 && s.Type == dbc.Integer:
+		s.kind = kindEnum
+		for _, e := range g.enums {
+			if reflect.DeepEqual(e.values, s.Values) {
+				s.typ = e.name
+				return
+			}
+		}
+		name := unique(used, m.name+s.field)
+		e := &enum{name: name, doc: name + " are the values of " + m.name + "." + s.field + ".", values: s.Values}
+		g.enums = append(g.enums, e)
+		s.typ = e.name
+	case s.Type != dbc.Integer || s.Factor != 1 || s.Offset != 0 || !integral:
+		s.kind = kindFloat
+		s.typ = "float64"
+		g.useMath = true
+	case s.Length == 1 && !s.Signed:
+		s.kind = kindBool
+		s.typ = "bool"
+	default:
+		s.kind = kindInt
+		bits := 8
+		for bits < s.Length {
+			bits *= 2
+		}
+		s.typ = fmt.Sprintf("int%d", bits)
+		if !s.Signed {
+			s.typ = "u" + s.typ
+		}
+	}
+}
+
+func (g *generator) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+func (g *generator) constants() {
+	g.p("// Message IDs.")
+	g.p("const (")
+	for _, m := range g.messages {
+		g.p("%sID = 0x%X", m.name, m.ID)
+	}
+	g.p(")")
+	g.p("")
+
+	var cyclic []*message
+	for _, m := range g.messages {
+		if g.db.CycleTime(m.Message) > 0 {
+			cyclic = append(cyclic, m)
+		}
+	}
+	if len(cyclic) == 0 {
+		return
+	}
+	g.useTime = true
+	g.p("// Message cycle times.")
+	g.p("const (")
+	for _, m := range cyclic {
+		g.p("%sCycleTime = %d * time.Millisecond", m.name, g.db.CycleTime(m.Message).Milliseconds())
+	}
+	g.p(")")
+	g.p("")
+}
+
+func (g *generator) enum(e *enum) {
+	raws := make([]int64, 0, len(e.values))
+	for raw := range e.values {
+		raws = append(raws, raw)
+	}
+	sort.Slice(raws, func(i, j int) bool { return raws[i] < raws[j] })
+
+	g.p("// %s", e.doc)
+	g.p("type %s int64", e.name)
+	g.p("")
+	g.p("const (")
+	names := make(map[string]bool)
+	consts := make(map[int64]string)
+	for _, raw := range raws {
+		label := goName(e.values[raw])
+		if label == "" {
+			label = fmt.Sprintf("Value%d", raw)
+		}
+		consts[raw] = unique(names, e.name+label)
+		g.p("%s %s = %d", consts[raw], e.name, raw)
+	}
+	g.p(")")
+	g.p("")
+	g.p("func (v %s) String() string {", e.name)
+	g.p("switch v {")
+	for _, raw := range raws {
+		g.p("case %s:", consts[raw])
+		g.p("return %s", strconv.Quote(e.values[raw]))
+	}
+	g.p("}")
+	g.p("return fmt.Sprintf(\"%s(%%d)\", int64(v))", e.name)
+	g.p("}")
+	g.p("")
+}
+
+func (g *generator) dispatch() {
+	g.p("// Message is implemented by the message types.")
+	g.p("type Message interface {")
+	g.p("Marshal() (canframe.Frame, error)")
+	g.p("Unmarshal(f *canframe.Frame) error")
+	g.p("}")
+	g.p("")
+	g.p("// Unmarshal decodes f into the message type of its ID.")
+	g.p("func Unmarshal(f *canframe.Frame) (Message, error) {")
+	g.p("var m Message")
+	g.p("switch {")
+	for _, m := range g.messages {
+		g.p("case %s:", matchID(m))
+		g.p("m = new(%s)", m.name)
+	}
+	g.p("default:")
+	g.p("return nil, fmt.Errorf(\"unknown frame id %%#x\", f.ID)")
+	g.p("}")
+	g.p("return m, m.Unmarshal(f)")
+	g.p("}")
+	g.p("")
+}
+
+func (g *generator) message(m *message) {
+	from := ""
+	if m.Sender != "" && m.Sender != "Vector__XXX" {
+		from = " sent by " + m.Sender
+	}
+	g.p("// %s is message 0x%X%s.", m.name, m.ID, from)
+	if m.Comment != "" {
+		g.comment(m.Comment)
+	}
+	g.p("type %s struct {", m.name)
+	for _, s := range m.signals {
+		doc := describe(s)
+		if s.Comment != "" {
+			doc += " " + strings.Join(strings.Fields(s.Comment), " ")
+		}
+		g.p("// %s", strings.TrimSpace(doc))
+		g.p("%s %s", s.field, s.typ)
+	}
+	g.p("}")
+	g.p("")
+
+	// Unmarshal.
+	g.p("// Unmarshal decodes f, fields of signals their multiplexer does not select are zeroed.")
+	g.p("func (m *%s) Unmarshal(f *canframe.Frame) error {", m.name)
+	g.p("if !(%s) {", matchID(m))
+	g.p("return fmt.Errorf(\"frame %%#x is no %s\", f.ID)", m.name)
+	g.p("}")
+	g.p("if len(f.Data) < %d {", m.Size)
+	g.p("return fmt.Errorf(\"%s: frame of %%d bytes, want %d\", len(f.Data))", m.name, m.Size)
+	g.p("}")
+	g.p("*m = %s{}", m.name)
+	for _, s := range m.signals {
+		g.p("r%s := dbc.Extract(f.Data, %d, %d, %s)", s.field, s.StartBit, s.Length, order(s))
+	}
+	for _, s := range m.signals {
+		if cond := g.cond(m, s); cond != "" {
+			g.p("if %s {", cond)
+			g.p("m.%s = %s", s.field, fromRaw(s))
+			g.p("}")
+			continue
+		}
+		g.p("m.%s = %s", s.field, fromRaw(s))
+	}
+	g.p("return nil")
+	g.p("}")
+	g.p("")
+
+	// Marshal.
+	g.p("// Marshal encodes m, checking signal ranges.")
+	g.p("func (m *%s) Marshal() (canframe.Frame, error) {", m.name)
+	g.p("f := canframe.Frame{ID: %sID, IsExtended: %v, Data: make([]byte, %d)}", m.name, m.Extended, m.Size)
+	for _, s := range m.signals {
+		if s.kind == kindBool {
+			g.p("var r%s uint64", s.field)
+			g.p("if m.%s {", s.field)
+			g.p("r%s = 1", s.field)
+			g.p("}")
+			continue
+		}
+		g.p("r%s := %s", s.field, toRaw(s))
+	}
+	for _, s := range m.signals {
+		cond := g.cond(m, s)
+		if cond != "" {
+			g.p("if %s {", cond)
+		}
+		if check := rangeCheck(s); check != "" {
+			g.p("if %s {", check)
+			g.p("return f, fmt.Errorf(\"%s.%s: %%v out of range [%s, %s]\", m.%s)", m.name, s.field, num(s.Min), num(s.Max), s.field)
+			g.p("}")
+		}
+		if check := widthCheck(s); check != "" {
+			g.p("if %s {", check)
+			g.p("return f, fmt.Errorf(\"%s.%s: %%v does not fit into %d bits\", m.%s)", m.name, s.field, s.Length, s.field)
+			g.p("}")
+		}
+		g.p("dbc.Insert(f.Data, %d, %d, %s, r%s)", s.StartBit, s.Length, order(s), s.field)
+		if cond != "" {
+			g.p("}")
+		}
+	}
+	g.p("return f, nil")
+	g.p("}")
+	g.p("")
+}
+
+func (g *generator) comment(text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		g.p("// %s", strings.TrimRight(line, "\r"))
+	}
+}
+
+// cond returns the condition for s being selected by its multiplexers, empty when always present.
+func (g *generator) cond(m *message, s *signal) string {
+	var terms []string
+	for depth := 0; s.Multiplexed() && depth < len(m.signals); depth++ {
+		var sw *signal
+		for _, o := range m.signals {
+			if o.Name == s.Multiplexer {
+				sw = o
+			}
+		}
+		if sw == nil {
+			return "false"
+		}
+		var ranges []string
+		for _, r := range s.MultiplexValues {
+			if r.Min == r.Max {
+				ranges = append(ranges, fmt.Sprintf("r%s == %d", sw.field, r.Min))
+			} else {
+				ranges = append(ranges, fmt.Sprintf("r%s >= %d && r%s <= %d", sw.field, r.Min, sw.field, r.Max))
+			}
+		}
+		term := strings.Join(ranges, " || ")
+		if len(ranges) > 1 {
+			term = "(" + term + ")"
+		}
+		// The outermost multiplexer first.
+		terms = append([]string{term}, terms...)
+		s = sw
+	}
+	return strings.Join(terms, " && ")
+}
+
+// fromRaw returns the expression converting the raw bits rField to the field.
+func fromRaw(s *signal) string {
+	r := "r" + s.field
+	var v string
+	switch {
+	case s.Type == dbc.Float32:
+		v = fmt.Sprintf("float64(math.Float32frombits(uint32(%s)))", r)
+	case s.Type == dbc.Float64:
+		v = fmt.Sprintf("math.Float64frombits(%s)", r)
+	case s.Signed:
+		v = fmt.Sprintf("dbc.SignExtend(%s, %d)", r, s.Length)
+	default:
+		v = r
+	}
+	switch s.kind {
+	case kindBool:
+		return r + " != 0"
+	case kindInt, kindEnum:
+		return fmt.Sprintf("%s(%s)", s.typ, v)
+	}
+	if s.Type == dbc.Integer {
+		v = "float64(" + v + ")"
+	}
+	if s.Factor != 1 {
+		v += "*" + num(s.Factor)
+	}
+	switch {
+	case s.Offset > 0:
+		v += " + " + num(s.Offset)
+	case s.Offset < 0:
+		v += " - " + num(-s.Offset)
+	}
+	return v
+}
+
+// toRaw returns the expression converting a non bool field to raw bits.
+func toRaw(s *signal) string {
+	if s.kind == kindInt || s.kind == kindEnum {
+		return fmt.Sprintf("uint64(m.%s)", s.field)
+	}
+	v := scaled(s)
+	switch s.Type {
+	case dbc.Float32:
+		return fmt.Sprintf("uint64(math.Float32bits(float32(%s)))", v)
+	case dbc.Float64:
+		return fmt.Sprintf("math.Float64bits(%s)", v)
+	}
+	return fmt.Sprintf("uint64(int64(math.Round(%s)))", v)
+}
+
+// scaled returns the expression of a float field with offset and factor undone.
+func scaled(s *signal) string {
+	v := "m." + s.field
+	switch {
+	case s.Offset > 0:
+		v = fmt.Sprintf("%s - %s", v, num(s.Offset))
+	case s.Offset < 0:
+		v = fmt.Sprintf("%s + %s", v, num(-s.Offset))
+	}
+	if s.Factor != 1 && s.Factor != 0 {
+		if s.Offset != 0 {
+			v = "(" + v + ")"
+		}
+		v = fmt.Sprintf("%s / %s", v, num(s.Factor))
+	}
+	return v
+}
+
+// rangeCheck returns the condition for a field out of range, empty when no check is needed.
+func rangeCheck(s *signal) string {
+	f := "m." + s.field
+	if s.Min == 0 && s.Max == 0 {
+		return ""
+	}
+	switch s.kind {
+	case kindFloat:
+		return fmt.Sprintf("%s < %s || %s > %s", f, num(s.Min), f, num(s.Max))
+	case kindInt:
+		lo, hi := limits(typeBits(s), s.Signed)
+		var checks []string
+		if s.Min > lo && s.Min <= hi {
+			checks = append(checks, fmt.Sprintf("%s < %s", f, num(s.Min)))
+		}
+		if s.Max < hi && s.Max >= lo {
+			checks = append(checks, fmt.Sprintf("%s > %s", f, num(s.Max)))
+		}
+		return strings.Join(checks, " || ")
+	}
+	return ""
+}
+
+// widthCheck returns the condition for a field not fitting into the signal's bits, empty when it always does.
+// rangeCheck does not cover this, DBC ranges may be [0|0] or wider than the signal.
+func widthCheck(s *signal) string {
+	if s.kind == kindBool || s.Type != dbc.Integer || s.Length >= 64 || s.kind == kindInt && typeBits(s) == s.Length {
+		return ""
+	}
+	lo, hi := limits(s.Length, s.Signed)
+	if s.kind == kindFloat {
+		v := fmt.Sprintf("math.Round(%s)", scaled(s))
+		return fmt.Sprintf("!(%s >= %s && %s <= %s)", v, num(lo), v, num(hi))
+	}
+	r := "r" + s.field
+	if s.Signed {
+		return fmt.Sprintf("int64(%s) < %d || int64(%s) > %d", r, -1<<(s.Length-1), r, 1<<(s.Length-1)-1)
+	}
+	return fmt.Sprintf("%s > %d", r, 1<<s.Length-1)
+}
+
+// limits returns the range of bits wide integers.
+func limits(bits int, signed bool) (lo, hi float64) {
+	if signed {
+		return -math.Ldexp(1, bits-1), math.Ldexp(1, bits-1) - 1
+	}
+	return 0, math.Ldexp(1, bits) - 1
+}
+
+// typeBits returns the size of an int field's type.
+func typeBits(s *signal) int {
+	bits, _ := strconv.Atoi(strings.TrimPrefix(strings.TrimPrefix(s.typ, "u"), "int"))
+	return bits
+}
+
+func describe(s *signal) string {
+	var b strings.Builder
+	b.WriteString(s.field)
+	if s.Unit != "" {
+		fmt.Fprintf(&b, " in %s", s.Unit)
+	}
+	if s.Min != 0 || s.Max != 0 {
+		fmt.Fprintf(&b, ", %s to %s", num(s.Min), num(s.Max))
+	}
+	b.WriteString(".")
+	return b.String()
+}
+
+func matchID(m *message) string {
+	if m.Extended {
+		return fmt.Sprintf("f.ID == %sID && f.IsExtended", m.name)
+	}
+	return fmt.Sprintf("f.ID == %sID && !f.IsExtended", m.name)
+}
+
+func order(s *signal) string {
+	if s.ByteOrder == dbc.LittleEndian {
+		return "dbc.LittleEndian"
+	}
+	return "dbc.BigEndian"
+}
+
+func num(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// goName turns a DBC name into an exported Go identifier, ENGINE_SPEED becomes EngineSpeed.
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if strings.ToUpper(part) == part {
+			part = strings.ToLower(part)
+		}
+		rs := []rune(part)
+		rs[0] = unicode.ToUpper(rs[0])
+		b.WriteString(string(rs))
+	}
+	s := b.String()
+	if s == "" {
+		return ""
+	}
+	if unicode.IsDigit([]rune(s)[0]) {
+		s = "X" + s
+	}
+	return s
+}
+
+// unique makes name unique in used, adding a number when taken.
+func unique(used map[string]bool, name string) string {
+	if name == "" {
+		name = "X"
+	}
+	n := name
+	for i := 2; used[n]; i++ {
+		n = fmt.Sprintf("%s%d", name, i)
+	}
+	used[n] = true
+	return n
+}
@@ -0,0 +1,70 @@
+// Command dbcgen generates Go types for the messages of a DBC file.
+//
+//	dbcgen [-pkg name] [-o file.go] file.dbc
+//
+// Each message becomes a struct with Marshal and Unmarshal methods to and from canframe.Frame,
+// value tables become enum types, and IDs and cycle times become constants.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lion187chen/socketcan-go/dbc"
+)
+
+func main() {
+	pkg := flag.String("pkg", "", "package name, default from the DBC file name")
+	out := flag.String("o", "", "output file, default stdout")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: dbcgen [-pkg name] [-o file.go] file.dbc")
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	db, err := dbc.ParseFile(path)
+	if err != nil {
+		fail(fmt.Errorf("%s: %w", path, err))
+	}
+	if *pkg == "" {
+		*pkg = packageName(path)
+	}
+	src, err := generate(db, *pkg, filepath.Base(path))
+	if err != nil {
+		fail(err)
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "dbcgen:", err)
+	os.Exit(1)
+}
+
+// packageName makes a package name from a file name, vehicle-CAN.dbc becomes vehiclecan.
+func packageName(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, base)
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "dbc" + name
+	}
+	return name
+}
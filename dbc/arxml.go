@@ -0,0 +1,574 @@
+package dbc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseARXMLFile parses an AUTOSAR system description, see ParseARXML().
+func ParseARXMLFile(path string, cluster string) (*Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseARXML(f, cluster)
+}
+
+// ParseARXML imports the CAN communication matrix of an AUTOSAR system description.
+// cluster selects a CAN-CLUSTER by short name, empty for the first.
+// Each CAN-FRAME-TRIGGERING becomes a message with the signals of the PDUs mapped into its frame,
+// at the PDU's position, scaled by the I-signals' compu-methods.
+// A MULTIPLEXED-I-PDU becomes a multiplexer switch named Selector with its dynamic parts as multiplexed signals.
+// Senders and receivers come from the frame ports of ECU instances.
+// Cycle times become GenMsgCycleTime, init values GenSigStartValue, the baudrate the network attribute Baudrate.
+// Other PDU kinds, like container or secured PDUs, are left out.
+func ParseARXML(r io.Reader, cluster string) (*Database, error) {
+	root, err := arRead(r)
+	if err != nil {
+		return nil, fmt.Errorf("arxml: %v", err)
+	}
+	a := arxml{paths: make(map[string]*arNode), db: new(Database).Init()}
+	a.index(root, "")
+
+	var c *arNode
+	for _, n := range root.findAll("CAN-CLUSTER") {
+		if cluster == "" || n.name() == cluster {
+			c = n
+			break
+		}
+	}
+	if c == nil {
+		return nil, fmt.Errorf("arxml: no CAN cluster %q", cluster)
+	}
+	a.db.Comment = c.child("DESC").text("L-2")
+	if baud := c.find("BAUDRATE"); baud != nil {
+		v, err := strconv.ParseInt(strings.TrimSpace(baud.value), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("arxml: cluster %s: invalid baudrate %q", c.name(), baud.value)
+		}
+		a.db.define(KindNetwork, "Baudrate", AttrInt, int64(0))
+		a.db.Attributes["Baudrate"] = v
+	}
+	for _, t := range c.findAll("CAN-FRAME-TRIGGERING") {
+		m, err := a.message(t)
+		if err != nil {
+			return nil, fmt.Errorf("arxml: frame triggering %s: %v", t.name(), err)
+		}
+		if err := a.db.AddMessage(m); err != nil {
+			return nil, fmt.Errorf("arxml: %v", err)
+		}
+	}
+	return a.db, nil
+}
+
+// ARXML private.
+
+// arNode is an XML element.
+type arNode struct {
+	tag      string
+	value    string
+	parent   *arNode
+	children []*arNode
+}
+
+// arRead reads the element tree of a document.
+func arRead(r io.Reader) (*arNode, error) {
+	d := xml.NewDecoder(r)
+	root := &arNode{}
+	cur := root
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &arNode{tag: t.Name.Local, parent: cur}
+			cur.children = append(cur.children, n)
+			cur = n
+		case xml.EndElement:
+			cur = cur.parent
+		case xml.CharData:
+			cur.value += string(t)
+		}
+	}
+}
+
+// child returns the first child with tag, nil if none. A nil node has no children.
+func (n *arNode) child(tag string) *arNode {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if c.tag == tag {
+			return c
+		}
+	}
+	return nil
+}
+
+// find returns the first descendant with tag, depth first.
+func (n *arNode) find(tag string) *arNode {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if c.tag == tag {
+			return c
+		}
+		if f := c.find(tag); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// findAll returns the descendants with tag, in document order.
+func (n *arNode) findAll(tag string) []*arNode {
+	var all []*arNode
+	if n == nil {
+		return all
+	}
+	for _, c := range n.children {
+		if c.tag == tag {
+			all = append(all, c)
+		}
+		all = append(all, c.findAll(tag)...)
+	}
+	return all
+}
+
+// text returns the trimmed text of the child with tag.
+func (n *arNode) text(tag string) string {
+	if c := n.child(tag); c != nil {
+		return strings.TrimSpace(c.value)
+	}
+	return ""
+}
+
+func (n *arNode) name() string {
+	return n.text("SHORT-NAME")
+}
+
+type arxml struct {
+	// paths are the elements with a SHORT-NAME by their absolute path.
+	paths map[string]*arNode
+	db    *Database
+}
+
+func (a *arxml) index(n *arNode, path string) {
+	if name := n.name(); name != "" {
+		path += "/" + name
+		a.paths[path] = n
+	}
+	for _, c := range n.children {
+		a.index(c, path)
+	}
+}
+
+// ref resolves the reference child with tag of n, nil without one.
+func (a *arxml) ref(n *arNode, tag string) (*arNode, error) {
+	return a.resolve(n.child(tag))
+}
+
+// resolve returns the element a reference points to, nil for no reference.
+func (a *arxml) resolve(r *arNode) (*arNode, error) {
+	if r == nil {
+		return nil, nil
+	}
+	path := strings.TrimSpace(r.value)
+	target, ok := a.paths[path]
+	if !ok {
+		return nil, fmt.Errorf("unresolved reference %s", path)
+	}
+	return target, nil
+}
+
+// node returns the node of an ECU instance, adding it on first use.
+func (a *arxml) node(ecu *arNode) string {
+	name := ecu.name()
+	if _, ok := a.db.Node(name); !ok {
+		n, _ := a.db.AddNode(name)
+		n.Comment = ecu.child("DESC").text("L-2")
+	}
+	return name
+}
+
+func (a *arxml) message(t *arNode) (*Message, error) {
+	frame, err := a.ref(t, "FRAME-REF")
+	if err != nil {
+		return nil, err
+	}
+	if frame == nil {
+		return nil, fmt.Errorf("no frame")
+	}
+	id, err := strconv.ParseUint(t.text("IDENTIFIER"), 0, 29)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identifier %q", t.text("IDENTIFIER"))
+	}
+	size, err := strconv.ParseUint(frame.text("FRAME-LENGTH"), 0, 7)
+	if err != nil {
+		return nil, fmt.Errorf("frame %s: invalid length %q", frame.name(), frame.text("FRAME-LENGTH"))
+	}
+	m := &Message{
+		ID:         uint32(id),
+		Extended:   t.text("CAN-ADDRESSING-MODE") == "EXTENDED",
+		Name:       frame.name(),
+		Size:       int(size),
+		Comment:    frame.child("DESC").text("L-2"),
+		Attributes: make(Attributes),
+	}
+
+	var receivers []string
+	for _, r := range t.findAll("FRAME-PORT-REF") {
+		port, err := a.resolve(r)
+		if err != nil {
+			return nil, err
+		}
+		ecu := port
+		for ecu != nil && ecu.tag != "ECU-INSTANCE" {
+			ecu = ecu.parent
+		}
+		if ecu == nil {
+			continue
+		}
+		name := a.node(ecu)
+		if port.text("COMMUNICATION-DIRECTION") == "OUT" {
+			m.Transmitters = append(m.Transmitters, name)
+		} else {
+			receivers = append(receivers, name)
+		}
+	}
+	if len(m.Transmitters) != 0 {
+		m.Sender = m.Transmitters[0]
+	}
+	if len(m.Transmitters) < 2 {
+		m.Transmitters = nil
+	}
+
+	for _, pm := range frame.findAll("PDU-TO-FRAME-MAPPING") {
+		pdu, err := a.ref(pm, "PDU-REF")
+		if err != nil {
+			return nil, err
+		}
+		if pdu == nil {
+			continue
+		}
+		offset, err := arInt(pm.text("START-POSITION"))
+		if err != nil {
+			return nil, fmt.Errorf("pdu %s: invalid start position", pdu.name())
+		}
+		if err := a.pdu(m, pdu, int(offset)); err != nil {
+			return nil, fmt.Errorf("pdu %s: %v", pdu.name(), err)
+		}
+	}
+	for _, s := range m.Signals {
+		s.Receivers = append(s.Receivers, receivers...)
+	}
+	return m, nil
+}
+
+// cycleTime returns the VALUE of the cycle time of pdu, nil if it has none.
+// AUTOSAR 4 has it in the true timing, next to the optional TIME-OFFSET, AUTOSAR 3 in a CYCLIC-TIMING of its own.
+func cycleTime(pdu *arNode) *arNode {
+	if v := pdu.find("TRANSMISSION-MODE-TRUE-TIMING").child("CYCLIC-TIMING").child("TIME-PERIOD").child("VALUE"); v != nil {
+		return v
+	}
+	for _, c := range pdu.findAll("CYCLIC-TIMING") {
+		if c.parent.tag == "TRANSMISSION-MODE-FALSE-TIMING" {
+			continue
+		}
+		if v := c.child("TIME-PERIOD").child("VALUE"); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// pdu adds the signals of pdu at bit offset to m.
+func (a *arxml) pdu(m *Message, pdu *arNode, offset int) error {
+	if v := cycleTime(pdu); v != nil {
+		sec, err := strconv.ParseFloat(strings.TrimSpace(v.value), 64)
+		if err != nil {
+			return fmt.Errorf("invalid cycle time %q", v.value)
+		}
+		a.db.define(KindMessage, "GenMsgCycleTime", AttrInt, int64(0))
+		m.Attributes["GenMsgCycleTime"] = int64(math.Round(sec * 1000))
+	}
+	if pdu.tag != "MULTIPLEXED-I-PDU" {
+		return a.signals(m, pdu, offset, nil)
+	}
+
+	start, err1 := arInt(pdu.text("SELECTOR-FIELD-START-POSITION"))
+	length, err2 := arInt(pdu.text("SELECTOR-FIELD-LENGTH"))
+	if err1 != nil || err2 != nil || length < 1 || length > 64 {
+		return fmt.Errorf("invalid selector field")
+	}
+	sw := &Signal{
+		Name:          "Selector",
+		StartBit:      int(start) + offset,
+		Length:        int(length),
+		ByteOrder:     arByteOrder(pdu.text("SELECTOR-FIELD-BYTE-ORDER")),
+		Factor:        1,
+		IsMultiplexer: true,
+		Attributes:    make(Attributes),
+	}
+	m.Signals = append(m.Signals, sw)
+	for _, s := range pdu.findAll("STATIC-PART") {
+		part, err := a.ref(s, "I-PDU-REF")
+		if err != nil {
+			return err
+		}
+		if part != nil {
+			if err := a.signals(m, part, offset, nil); err != nil {
+				return err
+			}
+		}
+	}
+	for _, alt := range pdu.findAll("DYNAMIC-PART-ALTERNATIVE") {
+		part, err := a.ref(alt, "I-PDU-REF")
+		if err != nil {
+			return err
+		}
+		code, err := arInt(alt.text("SELECTOR-FIELD-CODE"))
+		if err != nil || part == nil {
+			return fmt.Errorf("invalid dynamic part")
+		}
+		if err := a.signals(m, part, offset, &MuxRange{uint64(code), uint64(code)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signals adds the I-signals mapped into pdu, multiplexed by Selector with mux values.
+func (a *arxml) signals(m *Message, pdu *arNode, offset int, mux *MuxRange) error {
+	for _, sm := range pdu.findAll("I-SIGNAL-TO-I-PDU-MAPPING") {
+		is, err := a.ref(sm, "I-SIGNAL-REF")
+		if err == nil && is == nil {
+			// Autosar 3 names the reference SIGNAL-REF.
+			is, err = a.ref(sm, "SIGNAL-REF")
+		}
+		if err != nil {
+			return err
+		}
+		if is == nil {
+			if err := a.group(m, sm); err != nil {
+				return err
+			}
+			continue
+		}
+		start, err := arInt(sm.text("START-POSITION"))
+		if err != nil {
+			return fmt.Errorf("signal %s: invalid start position", is.name())
+		}
+		s, err := a.signal(is)
+		if err != nil {
+			return fmt.Errorf("signal %s: %v", is.name(), err)
+		}
+		s.StartBit = int(start) + offset
+		s.ByteOrder = arByteOrder(sm.text("PACKING-BYTE-ORDER"))
+		if mux != nil {
+			s.Multiplexer = "Selector"
+			s.MultiplexValues = []MuxRange{*mux}
+		}
+		if o, ok := m.Signal(s.Name); ok {
+			if mux == nil || !o.Multiplexed() || o.StartBit != s.StartBit || o.Length != s.Length {
+				return fmt.Errorf("signal %s mapped twice", s.Name)
+			}
+			// The same signal in several dynamic parts.
+			o.MultiplexValues = append(o.MultiplexValues, *mux)
+			continue
+		}
+		m.Signals = append(m.Signals, s)
+	}
+	return nil
+}
+
+// group adds the signal group of a mapping without I-signal.
+func (a *arxml) group(m *Message, sm *arNode) error {
+	g, err := a.ref(sm, "I-SIGNAL-GROUP-REF")
+	if err != nil || g == nil {
+		return err
+	}
+	sg := &SignalGroup{Name: g.name(), Repetitions: 1}
+	for _, r := range g.findAll("I-SIGNAL-REF") {
+		is, err := a.resolve(r)
+		if err != nil {
+			return err
+		}
+		sg.Signals = append(sg.Signals, is.name())
+	}
+	m.SignalGroups = append(m.SignalGroups, sg)
+	return nil
+}
+
+// signal converts an I-signal, without its position.
+func (a *arxml) signal(is *arNode) (*Signal, error) {
+	length, err := arInt(is.text("LENGTH"))
+	if err != nil || length < 1 || length > 64 {
+		return nil, fmt.Errorf("invalid length %q", is.text("LENGTH"))
+	}
+	s := &Signal{Name: is.name(), Length: int(length), Factor: 1, Attributes: make(Attributes)}
+	sys, err := a.ref(is, "SYSTEM-SIGNAL-REF")
+	if err != nil {
+		return nil, err
+	}
+	s.Comment = sys.child("DESC").text("L-2")
+	if s.Comment == "" {
+		s.Comment = is.child("DESC").text("L-2")
+	}
+
+	props := is.child("NETWORK-REPRESENTATION-PROPS")
+	if bt, err := a.ref(props.find("SW-DATA-DEF-PROPS-CONDITIONAL"), "BASE-TYPE-REF"); err != nil {
+		return nil, err
+	} else if bt != nil {
+		switch bt.text("BASE-TYPE-ENCODING") {
+		case "2C":
+			s.Signed = true
+		case "IEEE754":
+			s.Type, s.Signed = Float32, true
+			if s.Length == 64 {
+				s.Type = Float64
+			}
+		}
+	}
+
+	// The network representation overrides the compu-method of the system signal.
+	cmRef := props.find("COMPU-METHOD-REF")
+	if cmRef == nil {
+		cmRef = sys.child("PHYSICAL-PROPS").find("COMPU-METHOD-REF")
+	}
+	if cm, err := a.resolve(cmRef); err != nil {
+		return nil, err
+	} else if cm != nil {
+		if err := a.compu(s, cm); err != nil {
+			return nil, fmt.Errorf("compu-method %s: %v", cm.name(), err)
+		}
+	}
+	if s.Unit == "" {
+		if u, err := a.ref(sys.child("PHYSICAL-PROPS").find("SW-DATA-DEF-PROPS-CONDITIONAL"), "UNIT-REF"); err != nil {
+			return nil, err
+		} else if u != nil {
+			s.Unit = arUnit(u)
+		}
+	}
+
+	if v := is.child("INIT-VALUE").find("VALUE"); v != nil {
+		raw, err := strconv.ParseFloat(strings.TrimSpace(v.value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid init value %q", v.value)
+		}
+		a.db.define(KindSignal, "GenSigStartValue", AttrFloat, 0.0)
+		s.Attributes["GenSigStartValue"] = raw
+	}
+	return s, nil
+}
+
+// compu applies a compu-method: the first linear scale gives factor, offset and range, text scales value descriptions.
+func (a *arxml) compu(s *Signal, cm *arNode) error {
+	u, err := a.ref(cm, "UNIT-REF")
+	if err != nil {
+		return err
+	}
+	if u != nil {
+		s.Unit = arUnit(u)
+	}
+	linear := false
+	for _, sc := range cm.child("COMPU-INTERNAL-TO-PHYS").findAll("COMPU-SCALE") {
+		lo, errLo := arFloat(sc.text("LOWER-LIMIT"))
+		hi, errHi := arFloat(sc.text("UPPER-LIMIT"))
+		if vt := sc.child("COMPU-CONST").text("VT"); vt != "" {
+			if errLo != nil {
+				return fmt.Errorf("invalid lower limit %q", sc.text("LOWER-LIMIT"))
+			}
+			if errHi != nil {
+				hi = lo
+			}
+			if hi < lo || hi-lo > 0xFFFF || math.IsInf(lo, 0) {
+				return fmt.Errorf("invalid text range %g..%g", lo, hi)
+			}
+			if s.Values == nil {
+				s.Values = make(map[int64]string)
+			}
+			for raw := int64(lo); raw <= int64(hi); raw++ {
+				s.Values[raw] = vt
+			}
+			continue
+		}
+		coeffs := sc.child("COMPU-RATIONAL-COEFFS")
+		if coeffs == nil || linear {
+			continue
+		}
+		num := arCoeffs(coeffs.child("COMPU-NUMERATOR"))
+		den := arCoeffs(coeffs.child("COMPU-DENOMINATOR"))
+		if len(num) < 2 || len(num) > 2 || len(den) > 1 {
+			return fmt.Errorf("not linear")
+		}
+		d := 1.0
+		if len(den) == 1 {
+			d = den[0]
+		}
+		if d == 0 {
+			return fmt.Errorf("zero denominator")
+		}
+		linear = true
+		s.Offset, s.Factor = num[0]/d, num[1]/d
+		if errLo == nil && errHi == nil && !math.IsInf(lo, 0) && !math.IsInf(hi, 0) {
+			s.Min, s.Max = s.Physical(int64(lo)), s.Physical(int64(hi))
+			if s.Min > s.Max {
+				s.Min, s.Max = s.Max, s.Min
+			}
+		}
+	}
+	return nil
+}
+
+func arCoeffs(n *arNode) []float64 {
+	var v []float64
+	if n == nil {
+		return v
+	}
+	for _, c := range n.children {
+		if c.tag != "V" {
+			continue
+		}
+		f, err := arFloat(strings.TrimSpace(c.value))
+		if err != nil {
+			return nil
+		}
+		v = append(v, f)
+	}
+	return v
+}
+
+func arUnit(u *arNode) string {
+	if d := u.text("DISPLAY-NAME"); d != "" {
+		return d
+	}
+	return u.name()
+}
+
+func arByteOrder(packing string) ByteOrder {
+	if packing == "MOST-SIGNIFICANT-BYTE-FIRST" {
+		return BigEndian
+	}
+	return LittleEndian
+}
+
+func arInt(s string) (int64, error) {
+	return strconv.ParseInt(s, 0, 64)
+}
+
+func arFloat(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
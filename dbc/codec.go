@@ -0,0 +1,260 @@
+package dbc
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// Value is a decoded signal.
+type Value struct {
+	// Raw is sign extended for signed signals, the bits of the float for float signals.
+	Raw      int64
+	Physical float64
+	// Label is the value description of Raw, empty if there is none.
+	Label string
+}
+
+func (v Value) String() string {
+	if v.Label != "" {
+		return v.Label
+	}
+	return strconv.FormatFloat(v.Physical, 'g', -1, 64)
+}
+
+// Decode decodes the signals of a frame, see Message.Decode().
+func (db *Database) Decode(f *canframe.Frame) (*Message, map[string]Value, error) {
+	m, ok := db.FrameMessage(f)
+	if !ok {
+		return nil, nil, fmt.Errorf("no message with id %#x", f.ID)
+	}
+	return m, m.Decode(f.Data), nil
+}
+
+// Decode returns the physical values of the signals present in data.
+// Multiplexed signals are present when their multiplexer has a matching value,
+// signals that do not fit into data, e.g. of a short frame, are left out.
+func (m *Message) Decode(data []byte) map[string]Value {
+	values := make(map[string]Value, len(m.Signals))
+	raws := make(map[string]int64, len(m.Signals))
+	for _, s := range m.Signals {
+		if !s.fits(len(data)) {
+			continue
+		}
+		raws[s.Name] = s.Raw(data)
+	}
+	for _, s := range m.Signals {
+		raw, ok := raws[s.Name]
+		if !ok || !m.active(s, raws) {
+			continue
+		}
+		values[s.Name] = Value{Raw: raw, Physical: s.Physical(raw), Label: s.Values[raw]}
+	}
+	return values
+}
+
+// Encode builds the frame of m from physical values by signal name.
+// Values are range checked against the signals' Min and Max, unless both are 0.
+// Omitted signals get their GenSigStartValue, a raw value, or raw 0.
+// Multiplexed signals are only encoded when their multiplexer selects them, giving one that is not is an error.
+func (db *Database) Encode(m *Message, values map[string]float64) (canframe.Frame, error) {
+	f := canframe.Frame{ID: m.ID, IsExtended: m.Extended, Data: make([]byte, m.Size)}
+	raws := make(map[string]int64, len(m.Signals))
+	for name := range values {
+		if _, ok := m.Signal(name); !ok {
+			return f, fmt.Errorf("message %s has no signal %s", m.Name, name)
+		}
+	}
+	for _, s := range m.Signals {
+		if v, ok := values[s.Name]; ok {
+			raw, err := s.RawOf(v)
+			if err != nil {
+				return f, err
+			}
+			raws[s.Name] = raw
+			continue
+		}
+		raws[s.Name] = db.startValue(s)
+	}
+	for _, s := range m.Signals {
+		if !m.active(s, raws) {
+			if _, ok := values[s.Name]; ok {
+				return f, fmt.Errorf("signal %s is not selected by its multiplexer %s", s.Name, s.Multiplexer)
+			}
+			continue
+		}
+		if !s.fits(m.Size) {
+			return f, fmt.Errorf("signal %s does not fit into %d bytes", s.Name, m.Size)
+		}
+		Insert(f.Data, s.StartBit, s.Length, s.ByteOrder, uint64(raws[s.Name]))
+	}
+	return f, nil
+}
+
+// Raw extracts the raw value of s from data, which must be long enough.
+func (s *Signal) Raw(data []byte) int64 {
+	raw := Extract(data, s.StartBit, s.Length, s.ByteOrder)
+	if s.Signed && s.Type == Integer {
+		return SignExtend(raw, s.Length)
+	}
+	return int64(raw)
+}
+
+// Physical converts a raw value to the physical value.
+func (s *Signal) Physical(raw int64) float64 {
+	var v float64
+	switch {
+	case s.Type == Float32:
+		v = float64(math.Float32frombits(uint32(raw)))
+	case s.Type == Float64:
+		v = math.Float64frombits(uint64(raw))
+	case s.Signed:
+		v = float64(raw)
+	default:
+		v = float64(uint64(raw))
+	}
+	return v*s.Factor + s.Offset
+}
+
+// RawOf converts a physical value to the raw value, checking the range.
+func (s *Signal) RawOf(v float64) (int64, error) {
+	if (s.Min != 0 || s.Max != 0) && (v < s.Min || v > s.Max) {
+		return 0, fmt.Errorf("signal %s: %g out of range [%g, %g]", s.Name, v, s.Min, s.Max)
+	}
+	factor := s.Factor
+	if factor == 0 {
+		factor = 1
+	}
+	scaled := (v - s.Offset) / factor
+	switch s.Type {
+	case Float32:
+		return int64(math.Float32bits(float32(scaled))), nil
+	case Float64:
+		return int64(math.Float64bits(scaled)), nil
+	}
+
+	scaled = math.Round(scaled)
+	lo, hi := 0.0, math.Ldexp(1, s.Length)-1
+	if s.Signed {
+		lo, hi = -math.Ldexp(1, s.Length-1), math.Ldexp(1, s.Length-1)-1
+	}
+	if scaled < lo || scaled > hi {
+		return 0, fmt.Errorf("signal %s: %g does not fit into %d bits", s.Name, v, s.Length)
+	}
+	if !s.Signed && scaled >= math.Ldexp(1, 63) {
+		return int64(uint64(scaled)), nil
+	}
+	return int64(scaled), nil
+}
+
+// Lookup returns the physical value of a value description, to encode enums by label.
+func (s *Signal) Lookup(label string) (float64, bool) {
+	for raw, l := range s.Values {
+		if l == label {
+			return s.Physical(raw), true
+		}
+	}
+	return 0, false
+}
+
+// Extract returns length bits at start of data as DBC files number them.
+func Extract(data []byte, start, length int, order ByteOrder) uint64 {
+	var raw uint64
+	if order == LittleEndian {
+		for i := 0; i < length; i++ {
+			pos := start + i
+			raw |= uint64(data[pos/8]>>(pos%8)&1) << i
+		}
+		return raw
+	}
+	pos := motorolaPos(start)
+	for i := 0; i < length; i++ {
+		raw = raw<<1 | uint64(data[pos/8]>>(7-pos%8)&1)
+		pos++
+	}
+	return raw
+}
+
+// SignExtend turns the length low bits of raw into a signed value.
+func SignExtend(raw uint64, length int) int64 {
+	if length < 64 && raw&(1<<(length-1)) != 0 {
+		raw |= math.MaxUint64 << length
+	}
+	return int64(raw)
+}
+
+// Insert stores the length low bits of raw at start of data as DBC files number them.
+func Insert(data []byte, start, length int, order ByteOrder, raw uint64) {
+	if order == LittleEndian {
+		for i := 0; i < length; i++ {
+			pos := start + i
+			data[pos/8] = data[pos/8]&^(1<<(pos%8)) | byte(raw>>i&1)<<(pos%8)
+		}
+		return
+	}
+	pos := motorolaPos(start)
+	for i := length - 1; i >= 0; i-- {
+		bit := 7 - pos%8
+		data[pos/8] = data[pos/8]&^(1<<bit) | byte(raw>>i&1)<<bit
+		pos++
+	}
+}
+
+// Codec private.
+
+// motorolaPos turns the start bit of a big endian signal into its position counted MSB first from byte 0.
+func motorolaPos(start int) int {
+	return start/8*8 + 7 - start%8
+}
+
+// fits tells whether s lies within size bytes.
+func (s *Signal) fits(size int) bool {
+	if s.Length <= 0 || s.Length > 64 {
+		return false
+	}
+	if s.ByteOrder == LittleEndian {
+		return s.StartBit+s.Length <= size*8
+	}
+	return motorolaPos(s.StartBit)+s.Length <= size*8
+}
+
+// active tells whether s is present with the switch values in raws.
+func (m *Message) active(s *Signal, raws map[string]int64) bool {
+	// Multiplexers may chain, a loop in a broken file ends after every signal was visited.
+	for depth := 0; depth <= len(m.Signals); depth++ {
+		if !s.Multiplexed() {
+			return true
+		}
+		raw, ok := raws[s.Multiplexer]
+		if !ok || !inRanges(uint64(raw), s.MultiplexValues) {
+			return false
+		}
+		if s, ok = m.Signal(s.Multiplexer); !ok {
+			return false
+		}
+	}
+	return false
+}
+
+func inRanges(v uint64, ranges []MuxRange) bool {
+	for _, r := range ranges {
+		if v >= r.Min && v <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *Database) startValue(s *Signal) int64 {
+	v, ok := db.SignalAttribute(s, "GenSigStartValue")
+	if !ok {
+		return 0
+	}
+	n, ok := Number(v)
+	if !ok {
+		return 0
+	}
+	return int64(n)
+}
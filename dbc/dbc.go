@@ -0,0 +1,320 @@
+package dbc
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// ByteOrder of a signal.
+type ByteOrder int
+
+const (
+	// BigEndian is Motorola byte order, @0 in DBC files, the start bit is the most significant bit.
+	BigEndian ByteOrder = iota
+	// LittleEndian is Intel byte order, @1 in DBC files, the start bit is the least significant bit.
+	LittleEndian
+)
+
+func (o ByteOrder) String() string {
+	if o == LittleEndian {
+		return "little endian"
+	}
+	return "big endian"
+}
+
+// ValueType is how a signal's raw bits are interpreted.
+type ValueType int
+
+const (
+	Integer ValueType = iota
+	Float32
+	Float64
+)
+
+// ObjectKind is what an attribute or comment belongs to.
+type ObjectKind int
+
+const (
+	KindNetwork ObjectKind = iota
+	KindNode
+	KindMessage
+	KindSignal
+	KindEnvVar
+)
+
+// AttributeType is the value type of an attribute definition.
+type AttributeType int
+
+const (
+	AttrInt AttributeType = iota
+	AttrHex
+	AttrFloat
+	AttrString
+	AttrEnum
+)
+
+func (t AttributeType) String() string {
+	return [...]string{"INT", "HEX", "FLOAT", "STRING", "ENUM"}[t]
+}
+
+// AttributeDef is a BA_DEF_ attribute definition with its BA_DEF_DEF_ default.
+type AttributeDef struct {
+	Kind ObjectKind
+	Name string
+	Type AttributeType
+	// Min and Max limit INT, HEX and FLOAT attributes.
+	Min, Max float64
+	// Enum are the values of an ENUM attribute.
+	Enum []string
+	// Default is nil without BA_DEF_DEF_.
+	Default interface{}
+}
+
+// Attributes are attribute values by name.
+// Values are int64 or float64 for numbers, as written, and string for strings.
+// ENUM values are usually the int64 index into AttributeDef.Enum.
+type Attributes map[string]interface{}
+
+// Node is a network node, an ECU.
+type Node struct {
+	Name       string
+	Comment    string
+	Attributes Attributes
+}
+
+// ValueTable maps raw values to descriptions.
+type ValueTable struct {
+	Name   string
+	Values map[int64]string
+}
+
+// MuxRange is a range of multiplexer switch values.
+type MuxRange struct {
+	Min, Max uint64
+}
+
+// Signal is a signal of a message.
+type Signal struct {
+	Name string
+	// StartBit is as in DBC files, the LSB for little endian, the MSB for big endian signals.
+	StartBit  int
+	Length    int
+	ByteOrder ByteOrder
+	Signed    bool
+	Type      ValueType
+	// Physical value is raw * Factor + Offset.
+	Factor, Offset float64
+	Min, Max       float64
+	Unit           string
+	Receivers      []string
+
+	// IsMultiplexer is set on multiplexer switches.
+	IsMultiplexer bool
+	// Multiplexer is the switch this signal is multiplexed by, empty if not multiplexed.
+	Multiplexer string
+	// MultiplexValues are the switch values the signal is present with.
+	MultiplexValues []MuxRange
+
+	// Values are the VAL_ descriptions of raw values.
+	Values     map[int64]string
+	Comment    string
+	Attributes Attributes
+}
+
+// Multiplexed tells whether the signal is present only for some multiplexer values.
+func (s *Signal) Multiplexed() bool {
+	return s.Multiplexer != ""
+}
+
+// SignalGroup is a SIG_GROUP_ of a message.
+type SignalGroup struct {
+	Name        string
+	Repetitions int
+	Signals     []string
+}
+
+// Message is a frame layout.
+type Message struct {
+	// ID and Extended of a message in a database are changed by Database.SetMessageID().
+	ID       uint32
+	Extended bool
+	Name     string
+	// Size is the payload length in bytes.
+	Size   int
+	Sender string
+	// Transmitters are the BO_TX_BU_ nodes.
+	Transmitters []string
+	Signals      []*Signal
+	SignalGroups []*SignalGroup
+	Comment      string
+	Attributes   Attributes
+}
+
+// Signal returns the signal named name.
+func (m *Message) Signal(name string) (*Signal, bool) {
+	for _, s := range m.Signals {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Database is the content of a DBC file.
+type Database struct {
+	Version string
+	// NewSymbols are the NS_ entries.
+	NewSymbols []string
+	// BitTiming is the BS_ line after the colon, usually empty.
+	BitTiming   string
+	Nodes       []*Node
+	ValueTables []*ValueTable
+	Messages    []*Message
+	Comment     string
+	// AttributeDefs are in file order.
+	AttributeDefs []*AttributeDef
+	Attributes    Attributes
+	// Extra are statements kept as written, like environment variables.
+	Extra []string
+
+	byID map[uint32]*Message
+}
+
+// Init makes an empty database.
+func (db *Database) Init() *Database {
+	db.Attributes = make(Attributes)
+	db.byID = make(map[uint32]*Message)
+	return db
+}
+
+// Message returns the message with CAN id.
+func (db *Database) Message(id uint32, extended bool) (*Message, bool) {
+	m, ok := db.byID[key(id, extended)]
+	return m, ok
+}
+
+// FrameMessage returns the message of a frame.
+func (db *Database) FrameMessage(f *canframe.Frame) (*Message, bool) {
+	if f.IsError || f.IsRemote {
+		return nil, false
+	}
+	return db.Message(f.ID, f.IsExtended)
+}
+
+// MessageByName returns the message named name.
+func (db *Database) MessageByName(name string) (*Message, bool) {
+	for _, m := range db.Messages {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// Node returns the node named name.
+func (db *Database) Node(name string) (*Node, bool) {
+	for _, n := range db.Nodes {
+		if n.Name == name {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// ValueTable returns the value table named name.
+func (db *Database) ValueTable(name string) (*ValueTable, bool) {
+	for _, t := range db.ValueTables {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// AttributeDef returns the definition of attribute name for kind.
+func (db *Database) AttributeDef(kind ObjectKind, name string) (*AttributeDef, bool) {
+	for _, d := range db.AttributeDefs {
+		if d.Kind == kind && d.Name == name {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// MessageAttribute returns an attribute of m, or its default.
+func (db *Database) MessageAttribute(m *Message, name string) (interface{}, bool) {
+	return db.attribute(KindMessage, m.Attributes, name)
+}
+
+// SignalAttribute returns an attribute of s, or its default.
+func (db *Database) SignalAttribute(s *Signal, name string) (interface{}, bool) {
+	return db.attribute(KindSignal, s.Attributes, name)
+}
+
+// NodeAttribute returns an attribute of n, or its default.
+func (db *Database) NodeAttribute(n *Node, name string) (interface{}, bool) {
+	return db.attribute(KindNode, n.Attributes, name)
+}
+
+// CycleTime returns the GenMsgCycleTime of m, 0 if not cyclic.
+func (db *Database) CycleTime(m *Message) time.Duration {
+	v, ok := db.MessageAttribute(m, "GenMsgCycleTime")
+	if !ok {
+		return 0
+	}
+	ms, ok := Number(v)
+	if !ok {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// Number converts an attribute value to a number.
+func Number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Database private.
+
+func (db *Database) attribute(kind ObjectKind, own Attributes, name string) (interface{}, bool) {
+	if v, ok := own[name]; ok {
+		return v, true
+	}
+	if d, ok := db.AttributeDef(kind, name); ok && d.Default != nil {
+		return d.Default, true
+	}
+	return nil, false
+}
+
+// define adds an attribute definition unless there is one, for databases read from other formats.
+func (db *Database) define(kind ObjectKind, name string, typ AttributeType, def interface{}) {
+	if _, ok := db.AttributeDef(kind, name); !ok {
+		db.AttributeDefs = append(db.AttributeDefs, &AttributeDef{Kind: kind, Name: name, Type: typ, Default: def})
+	}
+}
+
+// index rebuilds the lookup by CAN ID.
+func (db *Database) index() {
+	db.byID = make(map[uint32]*Message, len(db.Messages))
+	for _, m := range db.Messages {
+		db.byID[key(m.ID, m.Extended)] = m
+	}
+}
+
+func key(id uint32, extended bool) uint32 {
+	if extended {
+		return id | 1<<31
+	}
+	return id
+}
@@ -0,0 +1,243 @@
+package dbc
+
+import (
+	"fmt"
+)
+
+// Editing keeps references between objects consistent, fields can be changed directly otherwise.
+
+// AddNode adds a node.
+func (db *Database) AddNode(name string) (*Node, error) {
+	if _, ok := db.Node(name); ok {
+		return nil, fmt.Errorf("node %s exists", name)
+	}
+	n := &Node{Name: name, Attributes: make(Attributes)}
+	db.Nodes = append(db.Nodes, n)
+	return n, nil
+}
+
+// RenameNode renames a node, in messages sent and signals received by it too.
+func (db *Database) RenameNode(old, name string) error {
+	n, ok := db.Node(old)
+	if !ok {
+		return fmt.Errorf("no node %s", old)
+	}
+	if _, ok := db.Node(name); ok {
+		return fmt.Errorf("node %s exists", name)
+	}
+	n.Name = name
+	db.replaceNode(old, name)
+	return nil
+}
+
+// RemoveNode removes a node, messages it sent get no sender.
+func (db *Database) RemoveNode(name string) bool {
+	for i, n := range db.Nodes {
+		if n.Name == name {
+			db.Nodes = append(db.Nodes[:i], db.Nodes[i+1:]...)
+			db.replaceNode(name, "")
+			return true
+		}
+	}
+	return false
+}
+
+// AddMessage adds m, its ID and name must be new.
+func (db *Database) AddMessage(m *Message) error {
+	if _, ok := db.Message(m.ID, m.Extended); ok {
+		return fmt.Errorf("message with id %#x exists", m.ID)
+	}
+	if _, ok := db.MessageByName(m.Name); ok {
+		return fmt.Errorf("message %s exists", m.Name)
+	}
+	if m.Attributes == nil {
+		m.Attributes = make(Attributes)
+	}
+	db.Messages = append(db.Messages, m)
+	db.byID[key(m.ID, m.Extended)] = m
+	return nil
+}
+
+// RemoveMessage removes m.
+func (db *Database) RemoveMessage(m *Message) bool {
+	for i, o := range db.Messages {
+		if o == m {
+			db.Messages = append(db.Messages[:i], db.Messages[i+1:]...)
+			delete(db.byID, key(m.ID, m.Extended))
+			return true
+		}
+	}
+	return false
+}
+
+// SetMessageID changes the CAN ID of m, use it rather than setting ID and Extended.
+func (db *Database) SetMessageID(m *Message, id uint32, extended bool) error {
+	if o, ok := db.Message(id, extended); ok && o != m {
+		return fmt.Errorf("message with id %#x exists", id)
+	}
+	delete(db.byID, key(m.ID, m.Extended))
+	m.ID, m.Extended = id, extended
+	db.byID[key(id, extended)] = m
+	return nil
+}
+
+// AddValueTable adds a value table.
+func (db *Database) AddValueTable(t *ValueTable) error {
+	if _, ok := db.ValueTable(t.Name); ok {
+		return fmt.Errorf("value table %s exists", t.Name)
+	}
+	db.ValueTables = append(db.ValueTables, t)
+	return nil
+}
+
+// RemoveValueTable removes the value table named name.
+func (db *Database) RemoveValueTable(name string) bool {
+	for i, t := range db.ValueTables {
+		if t.Name == name {
+			db.ValueTables = append(db.ValueTables[:i], db.ValueTables[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// DefineAttribute adds an attribute definition.
+func (db *Database) DefineAttribute(d *AttributeDef) error {
+	if _, ok := db.AttributeDef(d.Kind, d.Name); ok {
+		return fmt.Errorf("attribute %s exists", d.Name)
+	}
+	db.AttributeDefs = append(db.AttributeDefs, d)
+	return nil
+}
+
+// RemoveAttribute removes an attribute definition and its values.
+func (db *Database) RemoveAttribute(kind ObjectKind, name string) bool {
+	for i, d := range db.AttributeDefs {
+		if d.Kind != kind || d.Name != name {
+			continue
+		}
+		db.AttributeDefs = append(db.AttributeDefs[:i], db.AttributeDefs[i+1:]...)
+		switch kind {
+		case KindNetwork:
+			delete(db.Attributes, name)
+		case KindNode:
+			for _, n := range db.Nodes {
+				delete(n.Attributes, name)
+			}
+		case KindMessage:
+			for _, m := range db.Messages {
+				delete(m.Attributes, name)
+			}
+		case KindSignal:
+			for _, m := range db.Messages {
+				for _, s := range m.Signals {
+					delete(s.Attributes, name)
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// AddSignal adds s to m, its name must be new in m.
+func (m *Message) AddSignal(s *Signal) error {
+	if _, ok := m.Signal(s.Name); ok {
+		return fmt.Errorf("message %s has a signal %s", m.Name, s.Name)
+	}
+	if s.Length < 1 || s.Length > 64 {
+		return fmt.Errorf("signal %s: length %d", s.Name, s.Length)
+	}
+	if m.Size != 0 && !s.fits(m.Size) {
+		return fmt.Errorf("signal %s does not fit into %d bytes", s.Name, m.Size)
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(Attributes)
+	}
+	m.Signals = append(m.Signals, s)
+	return nil
+}
+
+// RenameSignal renames a signal, in multiplexer references and signal groups too.
+func (m *Message) RenameSignal(old, name string) error {
+	s, ok := m.Signal(old)
+	if !ok {
+		return fmt.Errorf("message %s has no signal %s", m.Name, old)
+	}
+	if _, ok := m.Signal(name); ok {
+		return fmt.Errorf("message %s has a signal %s", m.Name, name)
+	}
+	s.Name = name
+	for _, o := range m.Signals {
+		if o.Multiplexer == old {
+			o.Multiplexer = name
+		}
+	}
+	for _, g := range m.SignalGroups {
+		for i := range g.Signals {
+			if g.Signals[i] == old {
+				g.Signals[i] = name
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveSignal removes a signal, and from signal groups.
+// Signals multiplexed by it are left without multiplexer.
+func (m *Message) RemoveSignal(name string) bool {
+	for i, s := range m.Signals {
+		if s.Name != name {
+			continue
+		}
+		m.Signals = append(m.Signals[:i], m.Signals[i+1:]...)
+		for _, o := range m.Signals {
+			if o.Multiplexer == name {
+				o.Multiplexer = ""
+				o.MultiplexValues = nil
+			}
+		}
+		for _, g := range m.SignalGroups {
+			g.Signals = remove(g.Signals, name)
+		}
+		return true
+	}
+	return false
+}
+
+// Edit private.
+
+// replaceNode renames node references, or removes them when name is empty.
+func (db *Database) replaceNode(old, name string) {
+	for _, m := range db.Messages {
+		if m.Sender == old {
+			m.Sender = name
+		}
+		m.Transmitters = replace(m.Transmitters, old, name)
+		for _, s := range m.Signals {
+			s.Receivers = replace(s.Receivers, old, name)
+		}
+	}
+}
+
+func replace(list []string, old, name string) []string {
+	if name == "" {
+		return remove(list, old)
+	}
+	for i := range list {
+		if list[i] == old {
+			list[i] = name
+		}
+	}
+	return list
+}
+
+func remove(list []string, name string) []string {
+	out := list[:0]
+	for _, s := range list {
+		if s != name {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,292 @@
+package dbc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseKCDFile parses a Kayak KCD file, see ParseKCD().
+func ParseKCDFile(path string, bus string) (*Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseKCD(f, bus)
+}
+
+// ParseKCD parses a Kayak KCD file into a database.
+// A KCD file may describe several buses, bus selects one by name, empty for the first.
+// Message intervals become GenMsgCycleTime, the bus baudrate the network attribute Baudrate.
+func ParseKCD(r io.Reader, bus string) (*Database, error) {
+	var doc kcdNetwork
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("kcd: %v", err)
+	}
+	var b *kcdBus
+	for i := range doc.Buses {
+		if bus == "" || doc.Buses[i].Name == bus {
+			b = &doc.Buses[i]
+			break
+		}
+	}
+	if b == nil {
+		return nil, fmt.Errorf("kcd: no bus %q", bus)
+	}
+
+	db := new(Database).Init()
+	db.Version = doc.Document.Version
+	db.Comment = strings.TrimSpace(doc.Document.Text)
+	nodes := make(map[string]string, len(doc.Nodes))
+	for _, n := range doc.Nodes {
+		nodes[n.ID] = n.Name
+		db.Nodes = append(db.Nodes, &Node{Name: n.Name, Attributes: make(Attributes)})
+	}
+	if b.Baudrate != "" {
+		baud, err := strconv.ParseInt(b.Baudrate, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("kcd: bus %s: invalid baudrate %q", b.Name, b.Baudrate)
+		}
+		db.define(KindNetwork, "Baudrate", AttrInt, int64(0))
+		db.Attributes["Baudrate"] = baud
+	}
+	for i := range b.Messages {
+		m, err := b.Messages[i].message(db, nodes)
+		if err != nil {
+			return nil, fmt.Errorf("kcd: message %s: %v", b.Messages[i].Name, err)
+		}
+		if err := db.AddMessage(m); err != nil {
+			return nil, fmt.Errorf("kcd: %v", err)
+		}
+	}
+	return db, nil
+}
+
+// KCD private.
+
+type kcdNetwork struct {
+	Document struct {
+		Version string `xml:"version,attr"`
+		Text    string `xml:",chardata"`
+	} `xml:"Document"`
+	Nodes []kcdNode `xml:"Node"`
+	Buses []kcdBus  `xml:"Bus"`
+}
+
+type kcdNode struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+}
+
+type kcdRef struct {
+	ID string `xml:"id,attr"`
+}
+
+type kcdBus struct {
+	Name     string       `xml:"name,attr"`
+	Baudrate string       `xml:"baudrate,attr"`
+	Messages []kcdMessage `xml:"Message"`
+}
+
+type kcdMessage struct {
+	ID          string         `xml:"id,attr"`
+	Name        string         `xml:"name,attr"`
+	Length      string         `xml:"length,attr"`
+	Interval    string         `xml:"interval,attr"`
+	Format      string         `xml:"format,attr"`
+	Notes       string         `xml:"Notes"`
+	Producers   []kcdRef       `xml:"Producer>NodeRef"`
+	Signals     []kcdSignal    `xml:"Signal"`
+	Multiplexes []kcdMultiplex `xml:"Multiplex"`
+}
+
+type kcdSignal struct {
+	Name        string     `xml:"name,attr"`
+	Offset      string     `xml:"offset,attr"`
+	Length      string     `xml:"length,attr"`
+	Endianess   string     `xml:"endianess,attr"`
+	Notes       string     `xml:"Notes"`
+	Consumers   []kcdRef   `xml:"Consumer>NodeRef"`
+	Value       *kcdValue  `xml:"Value"`
+	Labels      []kcdLabel `xml:"LabelSet>Label"`
+	LabelGroups []kcdLabel `xml:"LabelSet>LabelGroup"`
+}
+
+type kcdValue struct {
+	Type      string `xml:"type,attr"`
+	Slope     string `xml:"slope,attr"`
+	Intercept string `xml:"intercept,attr"`
+	Unit      string `xml:"unit,attr"`
+	Min       string `xml:"min,attr"`
+	Max       string `xml:"max,attr"`
+}
+
+// kcdLabel is a Label with Value, or a LabelGroup with From and To.
+type kcdLabel struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+	From  string `xml:"from,attr"`
+	To    string `xml:"to,attr"`
+}
+
+// kcdMultiplex is a multiplexer switch with the signals of each value.
+type kcdMultiplex struct {
+	kcdSignal
+	Groups []struct {
+		Count   string      `xml:"count,attr"`
+		Signals []kcdSignal `xml:"Signal"`
+	} `xml:"MuxGroup"`
+}
+
+func (k *kcdMessage) message(db *Database, nodes map[string]string) (*Message, error) {
+	id, err := strconv.ParseUint(k.ID, 0, 29)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id %q", k.ID)
+	}
+	m := &Message{
+		ID:         uint32(id),
+		Extended:   k.Format == "extended",
+		Name:       k.Name,
+		Comment:    strings.TrimSpace(k.Notes),
+		Attributes: make(Attributes),
+	}
+	for i, p := range k.Producers {
+		if i == 0 {
+			m.Sender = nodes[p.ID]
+		}
+		m.Transmitters = append(m.Transmitters, nodes[p.ID])
+	}
+	if len(m.Transmitters) < 2 {
+		m.Transmitters = nil
+	}
+	if k.Interval != "" {
+		ms, err := strconv.ParseInt(k.Interval, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval %q", k.Interval)
+		}
+		db.define(KindMessage, "GenMsgCycleTime", AttrInt, int64(0))
+		m.Attributes["GenMsgCycleTime"] = ms
+	}
+
+	for i := range k.Signals {
+		s, err := k.Signals[i].signal(nodes)
+		if err != nil {
+			return nil, err
+		}
+		m.Signals = append(m.Signals, s)
+	}
+	for i := range k.Multiplexes {
+		x := &k.Multiplexes[i]
+		sw, err := x.signal(nodes)
+		if err != nil {
+			return nil, err
+		}
+		sw.IsMultiplexer = true
+		m.Signals = append(m.Signals, sw)
+		for _, g := range x.Groups {
+			count, err := strconv.ParseUint(g.Count, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("multiplexer %s: invalid count %q", sw.Name, g.Count)
+			}
+			for j := range g.Signals {
+				s, err := g.Signals[j].signal(nodes)
+				if err != nil {
+					return nil, err
+				}
+				s.Multiplexer = sw.Name
+				s.MultiplexValues = []MuxRange{{count, count}}
+				m.Signals = append(m.Signals, s)
+			}
+		}
+	}
+
+	switch k.Length {
+	case "", "auto":
+		for _, s := range m.Signals {
+			for !s.fits(m.Size) && m.Size < 64 {
+				m.Size++
+			}
+		}
+	default:
+		size, err := strconv.ParseUint(k.Length, 10, 7)
+		if err != nil {
+			return nil, fmt.Errorf("invalid length %q", k.Length)
+		}
+		m.Size = int(size)
+	}
+	return m, nil
+}
+
+// signal converts a KCD signal, whose offset counts big endian bits MSB first from byte 0.
+func (k *kcdSignal) signal(nodes map[string]string) (*Signal, error) {
+	s := &Signal{Name: k.Name, Length: 1, ByteOrder: LittleEndian, Factor: 1, Comment: strings.TrimSpace(k.Notes), Attributes: make(Attributes)}
+	offset, err := strconv.Atoi(k.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("signal %s: invalid offset %q", k.Name, k.Offset)
+	}
+	if k.Length != "" {
+		if s.Length, err = strconv.Atoi(k.Length); err != nil || s.Length < 1 || s.Length > 64 {
+			return nil, fmt.Errorf("signal %s: invalid length %q", k.Name, k.Length)
+		}
+	}
+	s.StartBit = offset
+	if k.Endianess == "big" {
+		s.ByteOrder = BigEndian
+		s.StartBit = motorolaPos(offset)
+	}
+	for _, c := range k.Consumers {
+		s.Receivers = append(s.Receivers, nodes[c.ID])
+	}
+
+	if v := k.Value; v != nil {
+		switch v.Type {
+		case "", "unsigned":
+		case "signed":
+			s.Signed = true
+		case "single":
+			s.Type, s.Signed = Float32, true
+		case "double":
+			s.Type, s.Signed = Float64, true
+		default:
+			return nil, fmt.Errorf("signal %s: invalid type %q", k.Name, v.Type)
+		}
+		for _, f := range []struct {
+			text string
+			v    *float64
+		}{{v.Slope, &s.Factor}, {v.Intercept, &s.Offset}, {v.Min, &s.Min}, {v.Max, &s.Max}} {
+			if f.text == "" {
+				continue
+			}
+			if *f.v, err = strconv.ParseFloat(f.text, 64); err != nil {
+				return nil, fmt.Errorf("signal %s: invalid number %q", k.Name, f.text)
+			}
+		}
+		s.Unit = v.Unit
+	}
+
+	if len(k.Labels)+len(k.LabelGroups) != 0 {
+		s.Values = make(map[int64]string)
+	}
+	for _, l := range k.Labels {
+		raw, err := strconv.ParseInt(l.Value, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("signal %s: invalid label value %q", k.Name, l.Value)
+		}
+		s.Values[raw] = l.Name
+	}
+	for _, l := range k.LabelGroups {
+		from, err1 := strconv.ParseInt(l.From, 0, 64)
+		to, err2 := strconv.ParseInt(l.To, 0, 64)
+		if err1 != nil || err2 != nil || to < from || to-from > 0xFFFF {
+			return nil, fmt.Errorf("signal %s: invalid label group %s", k.Name, l.Name)
+		}
+		for raw := from; raw <= to; raw++ {
+			s.Values[raw] = l.Name
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,862 @@
+package dbc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseFile parses a DBC file.
+func ParseFile(path string) (*Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse parses a DBC file. Statements it does not model, like environment variables, end up in Extra.
+func Parse(r io.Reader) (*Database, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{src: src, line: 1, db: new(Database).Init()}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	p.db.index()
+	resolveMultiplexers(p.db)
+	return p.db, nil
+}
+
+type parser struct {
+	src  []byte
+	pos  int
+	line int
+	db   *Database
+	// msg is the message SG_ lines belong to.
+	msg *Message
+	// muxRanges marks signals whose ranges came from SG_MUL_VAL_ rather than their mN.
+	muxRanges map[*Signal]bool
+}
+
+func (p *parser) parse() error {
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil
+		}
+		start := p.pos
+		kw, err := p.ident()
+		if err != nil {
+			return err
+		}
+		switch kw {
+		case "VERSION":
+			p.db.Version, err = p.str()
+		case "NS_":
+			err = p.newSymbols()
+		case "BS_":
+			if err = p.expect(':'); err == nil {
+				p.db.BitTiming = strings.TrimSpace(p.restOfLine())
+			}
+		case "BU_":
+			err = p.nodes()
+		case "VAL_TABLE_":
+			err = p.valueTable()
+		case "BO_":
+			err = p.message()
+		case "SG_":
+			err = p.signal()
+		case "BO_TX_BU_":
+			err = p.transmitters()
+		case "CM_":
+			err = p.comment(start)
+		case "BA_DEF_":
+			err = p.attributeDef()
+		case "BA_DEF_DEF_":
+			err = p.attributeDefault()
+		case "BA_":
+			err = p.attribute(start)
+		case "VAL_":
+			err = p.values(start)
+		case "SIG_VALTYPE_":
+			err = p.valueType()
+		case "SG_MUL_VAL_":
+			err = p.muxValues()
+		case "SIG_GROUP_":
+			err = p.signalGroup()
+		default:
+			err = p.extra(start)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Statements.
+
+func (p *parser) newSymbols() error {
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	p.restOfLine()
+	// The symbols are on the following indented lines.
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.pos++
+			p.line++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.db.NewSymbols = append(p.db.NewSymbols, strings.Fields(p.restOfLine())...)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *parser) nodes() error {
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	for _, name := range strings.Fields(p.restOfLine()) {
+		p.db.Nodes = append(p.db.Nodes, &Node{Name: name, Attributes: make(Attributes)})
+	}
+	return nil
+}
+
+func (p *parser) valueTable() error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	values, err := p.descriptions()
+	if err != nil {
+		return err
+	}
+	p.db.ValueTables = append(p.db.ValueTables, &ValueTable{Name: name, Values: values})
+	return nil
+}
+
+// message parses "BO_ id name: size sender".
+func (p *parser) message() error {
+	id, err := p.uint()
+	if err != nil {
+		return err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	size, err := p.uint()
+	if err != nil {
+		return err
+	}
+	sender, err := p.ident()
+	if err != nil {
+		return err
+	}
+	p.msg = &Message{
+		ID:         uint32(id) &^ (1 << 31),
+		Extended:   id&(1<<31) != 0,
+		Name:       name,
+		Size:       int(size),
+		Sender:     sender,
+		Attributes: make(Attributes),
+	}
+	p.db.Messages = append(p.db.Messages, p.msg)
+	return nil
+}
+
+// signal parses "SG_ name mux : start|length@order sign (factor,offset) [min|max] "unit" receivers".
+func (p *parser) signal() error {
+	if p.msg == nil {
+		return p.errorf("signal outside a message")
+	}
+	s := &Signal{Attributes: make(Attributes)}
+	var err error
+	if s.Name, err = p.ident(); err != nil {
+		return err
+	}
+	p.skipBlank()
+	if p.peek() != ':' {
+		mux, err := p.ident()
+		if err != nil {
+			return err
+		}
+		if err := parseMux(s, mux); err != nil {
+			return p.errorf("%v", err)
+		}
+	}
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	start, err := p.uint()
+	if err != nil {
+		return err
+	}
+	if err := p.expect('|'); err != nil {
+		return err
+	}
+	length, err := p.uint()
+	if err != nil {
+		return err
+	}
+	if err := p.expect('@'); err != nil {
+		return err
+	}
+	s.StartBit, s.Length = int(start), int(length)
+	switch p.next() {
+	case '0':
+		s.ByteOrder = BigEndian
+	case '1':
+		s.ByteOrder = LittleEndian
+	default:
+		return p.errorf("invalid byte order")
+	}
+	switch p.next() {
+	case '+':
+	case '-':
+		s.Signed = true
+	default:
+		return p.errorf("invalid sign")
+	}
+	if err := p.expect('('); err != nil {
+		return err
+	}
+	if s.Factor, err = p.float(); err != nil {
+		return err
+	}
+	if err := p.expect(','); err != nil {
+		return err
+	}
+	if s.Offset, err = p.float(); err != nil {
+		return err
+	}
+	if err := p.expect(')'); err != nil {
+		return err
+	}
+	if err := p.expect('['); err != nil {
+		return err
+	}
+	if s.Min, err = p.float(); err != nil {
+		return err
+	}
+	if err := p.expect('|'); err != nil {
+		return err
+	}
+	if s.Max, err = p.float(); err != nil {
+		return err
+	}
+	if err := p.expect(']'); err != nil {
+		return err
+	}
+	if s.Unit, err = p.str(); err != nil {
+		return err
+	}
+	for _, r := range strings.FieldsFunc(p.restOfLine(), func(c rune) bool { return c == ',' || c == ' ' || c == '\t' || c == '\r' }) {
+		s.Receivers = append(s.Receivers, r)
+	}
+	p.msg.Signals = append(p.msg.Signals, s)
+	return nil
+}
+
+// parseMux parses M, mN and mNM.
+func parseMux(s *Signal, mux string) error {
+	if mux == "M" {
+		s.IsMultiplexer = true
+		return nil
+	}
+	if len(mux) < 2 || mux[0] != 'm' {
+		return fmt.Errorf("invalid multiplexer indicator %q", mux)
+	}
+	digits := mux[1:]
+	if strings.HasSuffix(digits, "M") {
+		s.IsMultiplexer = true
+		digits = digits[:len(digits)-1]
+	}
+	v, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid multiplexer indicator %q", mux)
+	}
+	s.MultiplexValues = []MuxRange{{v, v}}
+	return nil
+}
+
+func (p *parser) transmitters() error {
+	m, err := p.messageRef()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	text, err := p.until(';')
+	if err != nil {
+		return err
+	}
+	for _, t := range strings.FieldsFunc(text, func(c rune) bool { return c == ',' || c == ' ' || c == '\t' || c == '\r' || c == '\n' }) {
+		m.Transmitters = append(m.Transmitters, t)
+	}
+	return nil
+}
+
+func (p *parser) comment(start int) error {
+	p.skipSpace()
+	var target *string
+	if p.peek() != '"' {
+		kind, err := p.ident()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case "BU_":
+			n, err := p.nodeRef()
+			if err != nil {
+				return err
+			}
+			target = &n.Comment
+		case "BO_":
+			m, err := p.messageRef()
+			if err != nil {
+				return err
+			}
+			target = &m.Comment
+		case "SG_":
+			s, err := p.signalRef()
+			if err != nil {
+				return err
+			}
+			target = &s.Comment
+		default:
+			return p.extra(start)
+		}
+	} else {
+		target = &p.db.Comment
+	}
+	text, err := p.str()
+	if err != nil {
+		return err
+	}
+	*target = text
+	return p.expect(';')
+}
+
+// attributeDef parses "BA_DEF_ kind "name" type args;".
+func (p *parser) attributeDef() error {
+	d := &AttributeDef{}
+	p.skipSpace()
+	if p.peek() != '"' {
+		kind, err := p.ident()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case "BU_":
+			d.Kind = KindNode
+		case "BO_":
+			d.Kind = KindMessage
+		case "SG_":
+			d.Kind = KindSignal
+		case "EV_":
+			d.Kind = KindEnvVar
+		default:
+			return p.errorf("unknown attribute object %s", kind)
+		}
+	}
+	var err error
+	if d.Name, err = p.str(); err != nil {
+		return err
+	}
+	typ, err := p.ident()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case "INT", "HEX", "FLOAT":
+		d.Type = map[string]AttributeType{"INT": AttrInt, "HEX": AttrHex, "FLOAT": AttrFloat}[typ]
+		p.skipSpace()
+		if p.peek() != ';' {
+			if d.Min, err = p.float(); err != nil {
+				return err
+			}
+			if d.Max, err = p.float(); err != nil {
+				return err
+			}
+		}
+	case "STRING":
+		d.Type = AttrString
+	case "ENUM":
+		d.Type = AttrEnum
+		for {
+			p.skipSpace()
+			if p.peek() == ';' {
+				break
+			}
+			v, err := p.str()
+			if err != nil {
+				return err
+			}
+			d.Enum = append(d.Enum, v)
+			p.skipSpace()
+			if p.peek() == ',' {
+				p.pos++
+			}
+		}
+	default:
+		return p.errorf("unknown attribute type %s", typ)
+	}
+	p.db.AttributeDefs = append(p.db.AttributeDefs, d)
+	return p.expect(';')
+}
+
+func (p *parser) attributeDefault() error {
+	name, err := p.str()
+	if err != nil {
+		return err
+	}
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	for _, d := range p.db.AttributeDefs {
+		if d.Name == name {
+			d.Default = v
+		}
+	}
+	return p.expect(';')
+}
+
+// attribute parses "BA_ "name" [kind object] value;".
+func (p *parser) attribute(start int) error {
+	name, err := p.str()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	attrs := p.db.Attributes
+	if c := p.peek(); c != '"' && c != '-' && c != '+' && c != '.' && (c < '0' || c > '9') {
+		kind, err := p.ident()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case "BU_":
+			n, err := p.nodeRef()
+			if err != nil {
+				return err
+			}
+			attrs = n.Attributes
+		case "BO_":
+			m, err := p.messageRef()
+			if err != nil {
+				return err
+			}
+			attrs = m.Attributes
+		case "SG_":
+			s, err := p.signalRef()
+			if err != nil {
+				return err
+			}
+			attrs = s.Attributes
+		default:
+			return p.extra(start)
+		}
+	}
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	attrs[name] = v
+	return p.expect(';')
+}
+
+// values parses "VAL_ id signal descriptions;", environment variable ones go to Extra.
+func (p *parser) values(start int) error {
+	p.skipSpace()
+	if c := p.peek(); c < '0' || c > '9' {
+		return p.extra(start)
+	}
+	s, err := p.signalRef()
+	if err != nil {
+		return err
+	}
+	s.Values, err = p.descriptions()
+	return err
+}
+
+// valueType parses "SIG_VALTYPE_ id signal : type;".
+func (p *parser) valueType() error {
+	s, err := p.signalRef()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	t, err := p.uint()
+	if err != nil {
+		return err
+	}
+	switch t {
+	case 0:
+		s.Type = Integer
+	case 1:
+		s.Type = Float32
+	case 2:
+		s.Type = Float64
+	default:
+		return p.errorf("invalid signal value type %d", t)
+	}
+	return p.expect(';')
+}
+
+// muxValues parses "SG_MUL_VAL_ id signal switch min-max, ...;".
+func (p *parser) muxValues() error {
+	s, err := p.signalRef()
+	if err != nil {
+		return err
+	}
+	if s.Multiplexer, err = p.ident(); err != nil {
+		return err
+	}
+	if p.muxRanges == nil {
+		p.muxRanges = make(map[*Signal]bool)
+	}
+	if !p.muxRanges[s] {
+		p.muxRanges[s] = true
+		s.MultiplexValues = nil
+	}
+	for {
+		p.skipSpace()
+		if p.peek() == ';' {
+			p.pos++
+			return nil
+		}
+		lo, err := p.uint()
+		if err != nil {
+			return err
+		}
+		if err := p.expect('-'); err != nil {
+			return err
+		}
+		hi, err := p.uint()
+		if err != nil {
+			return err
+		}
+		s.MultiplexValues = append(s.MultiplexValues, MuxRange{lo, hi})
+		p.skipSpace()
+		if p.peek() == ',' {
+			p.pos++
+		}
+	}
+}
+
+// signalGroup parses "SIG_GROUP_ id name repetitions : signals;".
+func (p *parser) signalGroup() error {
+	m, err := p.messageRef()
+	if err != nil {
+		return err
+	}
+	g := &SignalGroup{}
+	if g.Name, err = p.ident(); err != nil {
+		return err
+	}
+	reps, err := p.uint()
+	if err != nil {
+		return err
+	}
+	g.Repetitions = int(reps)
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	text, err := p.until(';')
+	if err != nil {
+		return err
+	}
+	g.Signals = strings.Fields(text)
+	m.SignalGroups = append(m.SignalGroups, g)
+	return nil
+}
+
+// extra keeps the statement from start up to its semicolon as written.
+func (p *parser) extra(start int) error {
+	if _, err := p.until(';'); err != nil {
+		return err
+	}
+	p.db.Extra = append(p.db.Extra, string(p.src[start:p.pos]))
+	return nil
+}
+
+// descriptions parses "value "description" ... ;".
+func (p *parser) descriptions() (map[int64]string, error) {
+	values := make(map[int64]string)
+	for {
+		p.skipSpace()
+		if p.peek() == ';' {
+			p.pos++
+			return values, nil
+		}
+		text := p.numberText()
+		v, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			// Some tools write raw values as floats.
+			f, ferr := strconv.ParseFloat(text, 64)
+			if ferr != nil {
+				return nil, p.errorf("invalid value %q", text)
+			}
+			v = int64(f)
+		}
+		desc, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		values[v] = desc
+	}
+}
+
+// References.
+
+func (p *parser) messageRef() (*Message, error) {
+	id, err := p.uint()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range p.db.Messages {
+		if key(m.ID, m.Extended) == uint32(id) {
+			return m, nil
+		}
+	}
+	return nil, p.errorf("unknown message %d", id)
+}
+
+func (p *parser) signalRef() (*Signal, error) {
+	m, err := p.messageRef()
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := m.Signal(name)
+	if !ok {
+		return nil, p.errorf("unknown signal %s in message %s", name, m.Name)
+	}
+	return s, nil
+}
+
+func (p *parser) nodeRef() (*Node, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	n, ok := p.db.Node(name)
+	if !ok {
+		return nil, p.errorf("unknown node %s", name)
+	}
+	return n, nil
+}
+
+// Tokens.
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.peek()
+	if p.pos < len(p.src) {
+		p.pos++
+	}
+	return c
+}
+
+// skipBlank skips spaces on this line.
+func (p *parser) skipBlank() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t' || p.src[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// skipSpace skips spaces and newlines.
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\n':
+			p.line++
+		case ' ', '\t', '\r':
+		default:
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *parser) expect(c byte) error {
+	p.skipSpace()
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) restOfLine() string {
+	end := bytes.IndexByte(p.src[p.pos:], '\n')
+	if end < 0 {
+		end = len(p.src) - p.pos
+	}
+	text := string(p.src[p.pos : p.pos+end])
+	p.pos += end
+	return text
+}
+
+// until returns the text up to c, outside strings, and skips c.
+func (p *parser) until(c byte) (string, error) {
+	start := p.pos
+	quoted := false
+	for ; p.pos < len(p.src); p.pos++ {
+		switch b := p.src[p.pos]; {
+		case b == '\n':
+			p.line++
+		case b == '\\' && quoted:
+			p.pos++
+		case b == '"':
+			quoted = !quoted
+		case b == c && !quoted:
+			p.pos++
+			return string(p.src[start : p.pos-1]), nil
+		}
+	}
+	return "", p.errorf("expected %q", c)
+}
+
+func (p *parser) ident() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || p.pos > start && c >= '0' && c <= '9' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	if p.pos == start {
+		return "", p.errorf("expected identifier")
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+// str parses a quoted string, \" and \\ are escapes.
+func (p *parser) str() (string, error) {
+	if err := p.expect('"'); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch {
+		case c == '"':
+			return sb.String(), nil
+		case c == '\\' && p.pos < len(p.src) && (p.src[p.pos] == '"' || p.src[p.pos] == '\\'):
+			sb.WriteByte(p.src[p.pos])
+			p.pos++
+		default:
+			if c == '\n' {
+				p.line++
+			}
+			sb.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// numberText returns a number as written, a sign is allowed at the start and after the exponent.
+func (p *parser) numberText() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c >= '0' && c <= '9' || c == '.' || c == 'e' || c == 'E':
+		case (c == '-' || c == '+') && (p.pos == start || p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E'):
+		default:
+			return string(p.src[start:p.pos])
+		}
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *parser) uint() (uint64, error) {
+	text := p.numberText()
+	v, err := strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		return 0, p.errorf("invalid number %q", text)
+	}
+	return v, nil
+}
+
+func (p *parser) float() (float64, error) {
+	text := p.numberText()
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, p.errorf("invalid number %q", text)
+	}
+	return v, nil
+}
+
+// value parses an attribute value, a string or a number.
+func (p *parser) value() (interface{}, error) {
+	p.skipSpace()
+	if p.peek() == '"' {
+		return p.str()
+	}
+	text := p.numberText()
+	if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return v, nil
+	}
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, p.errorf("invalid value %q", text)
+	}
+	return v, nil
+}
+
+// resolveMultiplexers points signals with mN to their message's M switch.
+func resolveMultiplexers(db *Database) {
+	for _, m := range db.Messages {
+		var sw string
+		for _, s := range m.Signals {
+			if s.IsMultiplexer && (sw == "" || len(s.MultiplexValues) == 0) {
+				sw = s.Name
+			}
+		}
+		for _, s := range m.Signals {
+			if len(s.MultiplexValues) != 0 && s.Multiplexer == "" {
+				s.Multiplexer = sw
+			}
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package dbc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseSYMFile parses a PCAN symbol file, see ParseSYM().
+func ParseSYMFile(path string) (*Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseSYM(f)
+}
+
+// ParseSYM parses a PCAN symbol file into a database.
+// The variants of a multiplexed symbol become one message with a multiplexer switch,
+// the Mux names become the switch's value descriptions.
+// CycleTime becomes GenMsgCycleTime, /d defaults GenSigStartValue.
+// Like in KCD files, Motorola start bits count MSB first from byte 0.
+func ParseSYM(r io.Reader) (*Database, error) {
+	p := symParser{
+		db:       new(Database).Init(),
+		enums:    make(map[string]map[int64]string),
+		signals:  make(map[string]*Signal),
+		messages: make(map[string]*Message),
+	}
+	if err := p.read(r); err != nil {
+		return nil, err
+	}
+	// Messages may use signals and enums defined further down, so those sections go first.
+	for _, sec := range []string{"ENUMS", "SIGNALS", ""} {
+		for _, l := range p.lines {
+			if (sec == "" && l.section != "ENUMS" && l.section != "SIGNALS") || l.section == sec {
+				if err := p.parseLine(l); err != nil {
+					return nil, fmt.Errorf("sym: line %d: %v", l.n, err)
+				}
+			}
+		}
+	}
+	for _, m := range p.order {
+		if err := p.db.AddMessage(m); err != nil {
+			return nil, fmt.Errorf("sym: %v", err)
+		}
+	}
+	return p.db, nil
+}
+
+// SYM private.
+
+type symLine struct {
+	n       int
+	section string
+	code    string
+	comment string
+}
+
+type symParser struct {
+	db    *Database
+	lines []symLine
+	enums map[string]map[int64]string
+	// signals are the {SIGNALS} definitions.
+	signals  map[string]*Signal
+	messages map[string]*Message
+	order    []*Message
+
+	// msg is the message of the current [symbol], mux its switch, muxValue the value.
+	msg      *Message
+	mux      *Signal
+	muxValue uint64
+}
+
+// read splits the file into lines of sections, joining enums written over several lines.
+func (p *symParser) read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	section := ""
+	var open *symLine
+	for n := 1; sc.Scan(); n++ {
+		code, comment := symSplit(sc.Text())
+		code = strings.TrimSpace(code)
+		if open != nil {
+			open.code += " " + code
+			if strings.Contains(code, ")") {
+				open = nil
+			}
+			continue
+		}
+		if code == "" {
+			continue
+		}
+		if strings.HasPrefix(code, "{") && strings.HasSuffix(code, "}") {
+			section = strings.ToUpper(code[1 : len(code)-1])
+			continue
+		}
+		p.lines = append(p.lines, symLine{n: n, section: section, code: code, comment: strings.TrimSpace(comment)})
+		if strings.HasPrefix(code, "enum ") && !strings.Contains(code, ")") {
+			open = &p.lines[len(p.lines)-1]
+		}
+	}
+	return sc.Err()
+}
+
+func (p *symParser) parseLine(l symLine) error {
+	if strings.HasPrefix(l.code, "[") && strings.HasSuffix(l.code, "]") {
+		if l.section == "SIGNALS" || l.section == "ENUMS" {
+			return fmt.Errorf("symbol in section %s", l.section)
+		}
+		name := l.code[1 : len(l.code)-1]
+		p.msg, p.mux = p.messages[name], nil
+		if p.msg == nil {
+			p.msg = &Message{Name: name, Attributes: make(Attributes)}
+			p.messages[name] = p.msg
+			p.order = append(p.order, p.msg)
+		}
+		return nil
+	}
+	if strings.HasPrefix(l.code, "enum ") {
+		return p.enum(l.code[len("enum "):])
+	}
+
+	eq := strings.IndexByte(l.code, '=')
+	if eq < 0 {
+		return fmt.Errorf("invalid line %q", l.code)
+	}
+	key, val := strings.TrimSpace(l.code[:eq]), strings.TrimSpace(l.code[eq+1:])
+	switch key {
+	case "FormatVersion":
+		return nil
+	case "Title":
+		p.db.Comment = strings.Trim(val, `"`)
+		return nil
+	}
+	if l.section == "SIGNALS" {
+		if key != "Sig" {
+			return fmt.Errorf("invalid line %q", l.code)
+		}
+		f := symFields(val)
+		if len(f) == 0 {
+			return fmt.Errorf("invalid signal %q", val)
+		}
+		s, err := p.signal(f[0], f[1:], l.comment)
+		if err != nil {
+			return err
+		}
+		p.signals[s.Name] = s
+		return nil
+	}
+	if p.msg == nil {
+		return fmt.Errorf("%s outside a symbol", key)
+	}
+
+	m := p.msg
+	switch key {
+	case "ID":
+		if i := strings.IndexByte(val, '-'); i >= 0 {
+			val = val[:i]
+		}
+		id, err := symUint(val)
+		if err != nil || id >= 1<<29 {
+			return fmt.Errorf("invalid id %q", val)
+		}
+		m.ID = uint32(id)
+	case "Type":
+		m.Extended = strings.HasSuffix(strings.ToLower(val), "extended")
+	case "DLC", "Len":
+		size, err := strconv.ParseUint(val, 10, 7)
+		if err != nil {
+			return fmt.Errorf("invalid length %q", val)
+		}
+		m.Size = int(size)
+	case "CycleTime":
+		f := strings.Fields(val)
+		if len(f) == 0 {
+			return fmt.Errorf("invalid cycle time %q", val)
+		}
+		ms, err := strconv.ParseInt(f[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cycle time %q", val)
+		}
+		p.db.define(KindMessage, "GenMsgCycleTime", AttrInt, int64(0))
+		m.Attributes["GenMsgCycleTime"] = ms
+	case "Mux":
+		return p.muxLine(val)
+	case "Var":
+		f := symFields(val)
+		if len(f) < 3 {
+			return fmt.Errorf("invalid variable %q", val)
+		}
+		start, length, err := symPosition(f[2])
+		if err != nil {
+			return err
+		}
+		s, err := p.signal(f[0], append([]string{f[1], strconv.Itoa(length)}, f[3:]...), l.comment)
+		if err != nil {
+			return err
+		}
+		return p.place(s, start)
+	case "Sig":
+		f := symFields(val)
+		if len(f) < 2 {
+			return fmt.Errorf("invalid signal %q", val)
+		}
+		def, ok := p.signals[f[0]]
+		if !ok {
+			return fmt.Errorf("no signal %s", f[0])
+		}
+		start, err := strconv.Atoi(f[1])
+		if err != nil {
+			return fmt.Errorf("invalid start %q", f[1])
+		}
+		s := *def
+		s.Attributes = make(Attributes)
+		for k, v := range def.Attributes {
+			s.Attributes[k] = v
+		}
+		for _, flag := range f[2:] {
+			if flag == "-m" {
+				s.ByteOrder = BigEndian
+			}
+		}
+		return p.place(&s, start)
+	}
+	// Other keys, like Timeout or Color, are of no use here.
+	return nil
+}
+
+// place adds s to the current message at start, multiplexed by the symbol's Mux.
+func (p *symParser) place(s *Signal, start int) error {
+	s.StartBit = start
+	if s.ByteOrder == BigEndian {
+		s.StartBit = motorolaPos(start)
+	}
+	if p.mux != nil {
+		s.Multiplexer = p.mux.Name
+		s.MultiplexValues = []MuxRange{{p.muxValue, p.muxValue}}
+	}
+	if o, ok := p.msg.Signal(s.Name); ok {
+		if p.mux == nil || !o.Multiplexed() || o.StartBit != s.StartBit || o.Length != s.Length {
+			return fmt.Errorf("symbol %s has a signal %s", p.msg.Name, s.Name)
+		}
+		// The same variable in several variants.
+		o.MultiplexValues = append(o.MultiplexValues, s.MultiplexValues...)
+		return nil
+	}
+	p.msg.Signals = append(p.msg.Signals, s)
+	return nil
+}
+
+// muxLine parses "name start,length value [-m]".
+func (p *symParser) muxLine(val string) error {
+	f := symFields(val)
+	if len(f) < 3 {
+		return fmt.Errorf("invalid mux %q", val)
+	}
+	start, length, err := symPosition(f[1])
+	if err != nil {
+		return err
+	}
+	v, err := symUint(f[2])
+	if err != nil {
+		return fmt.Errorf("invalid mux value %q", f[2])
+	}
+	order := LittleEndian
+	for _, flag := range f[3:] {
+		if flag == "-m" {
+			order = BigEndian
+		}
+	}
+	bit := start
+	if order == BigEndian {
+		bit = motorolaPos(start)
+	}
+	p.mux = nil
+	for _, s := range p.msg.Signals {
+		if s.IsMultiplexer && s.StartBit == bit && s.Length == length {
+			p.mux = s
+		}
+	}
+	if p.mux == nil {
+		p.mux = &Signal{Name: f[0], StartBit: bit, Length: length, ByteOrder: order, Factor: 1, IsMultiplexer: true, Values: make(map[int64]string), Attributes: make(Attributes)}
+		p.msg.Signals = append(p.msg.Signals, p.mux)
+	}
+	p.mux.Values[int64(v)] = f[0]
+	p.muxValue = v
+	return nil
+}
+
+// signal parses "type length [flags] [/options]" of a signal named name.
+func (p *symParser) signal(name string, f []string, comment string) (*Signal, error) {
+	if len(f) < 2 {
+		return nil, fmt.Errorf("invalid signal %s", name)
+	}
+	s := &Signal{Name: name, ByteOrder: LittleEndian, Factor: 1, Comment: comment, Attributes: make(Attributes)}
+	length, err := strconv.Atoi(f[1])
+	if err != nil {
+		return nil, fmt.Errorf("signal %s: invalid length %q", name, f[1])
+	}
+	s.Length = length
+	switch f[0] {
+	case "unsigned", "bit", "char", "string", "raw":
+	case "signed":
+		s.Signed = true
+	case "float":
+		s.Type, s.Signed = Float32, true
+	case "double":
+		s.Type, s.Signed = Float64, true
+	default:
+		return nil, fmt.Errorf("signal %s: invalid type %q", name, f[0])
+	}
+	if s.Length < 1 || s.Length > 64 || (s.Type == Float32 && s.Length != 32) || (s.Type == Float64 && s.Length != 64) {
+		return nil, fmt.Errorf("signal %s: invalid length %d", name, s.Length)
+	}
+
+	var start *float64
+	for _, opt := range f[2:] {
+		if opt == "-m" {
+			s.ByteOrder = BigEndian
+			continue
+		}
+		colon := strings.IndexByte(opt, ':')
+		if !strings.HasPrefix(opt, "/") || colon < 0 {
+			// Display flags like -h or -b.
+			continue
+		}
+		key, val := opt[1:colon], strings.Trim(opt[colon+1:], `"`)
+		switch key {
+		case "u":
+			s.Unit = val
+		case "e":
+			values, ok := p.enums[val]
+			if !ok {
+				return nil, fmt.Errorf("signal %s: no enum %s", name, val)
+			}
+			s.Values = values
+		case "f", "o", "min", "max", "d":
+			v, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return nil, fmt.Errorf("signal %s: invalid number %q", name, val)
+			}
+			switch key {
+			case "f":
+				s.Factor = v
+			case "o":
+				s.Offset = v
+			case "min":
+				s.Min = v
+			case "max":
+				s.Max = v
+			case "d":
+				start = &v
+			}
+		}
+	}
+	if start != nil && s.Factor != 0 {
+		p.db.define(KindSignal, "GenSigStartValue", AttrFloat, 0.0)
+		s.Attributes["GenSigStartValue"] = (*start - s.Offset) / s.Factor
+	}
+	return s, nil
+}
+
+// enum parses "Name(0="a", 1="b")".
+func (p *symParser) enum(text string) error {
+	open, end := strings.IndexByte(text, '('), strings.LastIndexByte(text, ')')
+	if open < 0 || end < open {
+		return fmt.Errorf("invalid enum %q", text)
+	}
+	name := strings.TrimSpace(text[:open])
+	values := make(map[int64]string)
+	body := text[open+1 : end]
+	for strings.TrimSpace(body) != "" {
+		eq := strings.IndexByte(body, '=')
+		if eq < 0 {
+			return fmt.Errorf("enum %s: invalid value", name)
+		}
+		raw, err := symUint(strings.TrimSpace(strings.TrimLeft(body[:eq], " ,")))
+		if err != nil {
+			return fmt.Errorf("enum %s: invalid value %q", name, body[:eq])
+		}
+		body = strings.TrimSpace(body[eq+1:])
+		if !strings.HasPrefix(body, `"`) {
+			return fmt.Errorf("enum %s: missing quotes", name)
+		}
+		q := strings.IndexByte(body[1:], '"')
+		if q < 0 {
+			return fmt.Errorf("enum %s: missing quotes", name)
+		}
+		values[int64(raw)] = body[1 : q+1]
+		body = body[q+2:]
+	}
+	p.enums[name] = values
+	p.db.ValueTables = append(p.db.ValueTables, &ValueTable{Name: name, Values: values})
+	return nil
+}
+
+// symSplit splits a line at a // comment outside quotes.
+func symSplit(line string) (code, comment string) {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(line[i:], "//"):
+			return line[:i], line[i+2:]
+		}
+	}
+	return line, ""
+}
+
+// symFields splits at spaces outside quotes.
+func symFields(s string) []string {
+	var f []string
+	quoted := false
+	start := -1
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || (!quoted && (s[i] == ' ' || s[i] == '\t')) {
+			if start >= 0 {
+				f = append(f, s[start:i])
+				start = -1
+			}
+			continue
+		}
+		if s[i] == '"' {
+			quoted = !quoted
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	return f
+}
+
+// symPosition parses "start,length".
+func symPosition(s string) (start, length int, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid position %q", s)
+	}
+	start, err1 := strconv.Atoi(parts[0])
+	length, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid position %q", s)
+	}
+	return start, length, nil
+}
+
+// symUint parses a decimal number, or a hex one with an h suffix.
+func symUint(s string) (uint64, error) {
+	if strings.HasSuffix(s, "h") || strings.HasSuffix(s, "H") {
+		return strconv.ParseUint(s[:len(s)-1], 16, 64)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
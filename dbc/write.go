@@ -0,0 +1,326 @@
+package dbc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteFile writes db as a DBC file.
+func (db *Database) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := db.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Write writes db as a DBC file, statements in the usual order.
+func (db *Database) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	x := &writer{w: bw, db: db, extra: make(map[string][]string)}
+	for _, e := range db.Extra {
+		kw := strings.Fields(e)[0]
+		x.extra[kw] = append(x.extra[kw], e)
+	}
+
+	fmt.Fprintf(bw, "VERSION %s\n\n\n", quote(db.Version))
+	fmt.Fprintf(bw, "NS_ : \n")
+	for _, s := range db.NewSymbols {
+		fmt.Fprintf(bw, "\t%s\n", s)
+	}
+	fmt.Fprintf(bw, "\nBS_:%s\n\n", prefixed(db.BitTiming))
+	names := make([]string, len(db.Nodes))
+	for i, n := range db.Nodes {
+		names[i] = n.Name
+	}
+	fmt.Fprintf(bw, "BU_:%s\n", prefixed(strings.Join(names, " ")))
+	for _, t := range db.ValueTables {
+		fmt.Fprintf(bw, "VAL_TABLE_ %s%s;\n", t.Name, descriptions(t.Values))
+	}
+	fmt.Fprintf(bw, "\n\n")
+
+	for _, m := range db.Messages {
+		x.message(m)
+	}
+	for _, m := range db.Messages {
+		if len(m.Transmitters) != 0 {
+			fmt.Fprintf(bw, "BO_TX_BU_ %d : %s;\n", key(m.ID, m.Extended), strings.Join(m.Transmitters, ","))
+		}
+	}
+	fmt.Fprintln(bw)
+	x.extras("EV_", "ENVVAR_DATA_", "SGTYPE_", "SGTYPE_VAL_")
+	fmt.Fprintln(bw)
+
+	x.comments()
+	x.attributes()
+	x.values()
+	x.extras("SIG_GROUP_")
+	for _, m := range db.Messages {
+		for _, g := range m.SignalGroups {
+			fmt.Fprintf(bw, "SIG_GROUP_ %d %s %d : %s;\n", key(m.ID, m.Extended), g.Name, g.Repetitions, strings.Join(g.Signals, " "))
+		}
+	}
+	x.extras("SIG_VALTYPE_", "SIG_TYPE_REF_")
+	for _, m := range db.Messages {
+		for _, s := range m.Signals {
+			if s.Type != Integer {
+				fmt.Fprintf(bw, "SIG_VALTYPE_ %d %s : %d;\n", key(m.ID, m.Extended), s.Name, s.Type)
+			}
+		}
+	}
+	x.extras("SG_MUL_VAL_")
+	for _, m := range db.Messages {
+		if !extendedMux(m) {
+			continue
+		}
+		for _, s := range m.Signals {
+			if !s.Multiplexed() {
+				continue
+			}
+			ranges := make([]string, len(s.MultiplexValues))
+			for i, r := range s.MultiplexValues {
+				ranges[i] = fmt.Sprintf("%d-%d", r.Min, r.Max)
+			}
+			fmt.Fprintf(bw, "SG_MUL_VAL_ %d %s %s %s;\n", key(m.ID, m.Extended), s.Name, s.Multiplexer, strings.Join(ranges, ", "))
+		}
+	}
+	x.rest()
+	return bw.Flush()
+}
+
+type writer struct {
+	w       *bufio.Writer
+	db      *Database
+	extra   map[string][]string
+	written map[string]bool
+}
+
+func (x *writer) message(m *Message) {
+	sender := m.Sender
+	if sender == "" {
+		sender = "Vector__XXX"
+	}
+	fmt.Fprintf(x.w, "BO_ %d %s: %d %s\n", key(m.ID, m.Extended), m.Name, m.Size, sender)
+	for _, s := range m.Signals {
+		sign := "+"
+		if s.Signed {
+			sign = "-"
+		}
+		order := 0
+		if s.ByteOrder == LittleEndian {
+			order = 1
+		}
+		receivers := strings.Join(s.Receivers, ",")
+		if receivers == "" {
+			receivers = "Vector__XXX"
+		}
+		fmt.Fprintf(x.w, " SG_ %s%s : %d|%d@%d%s (%s,%s) [%s|%s] %s %s\n",
+			s.Name, muxIndicator(s), s.StartBit, s.Length, order, sign,
+			num(s.Factor), num(s.Offset), num(s.Min), num(s.Max), quote(s.Unit), receivers)
+	}
+	fmt.Fprintln(x.w)
+}
+
+func (x *writer) comments() {
+	x.extras("CM_")
+	if x.db.Comment != "" {
+		fmt.Fprintf(x.w, "CM_ %s;\n", quote(x.db.Comment))
+	}
+	for _, n := range x.db.Nodes {
+		if n.Comment != "" {
+			fmt.Fprintf(x.w, "CM_ BU_ %s %s;\n", n.Name, quote(n.Comment))
+		}
+	}
+	for _, m := range x.db.Messages {
+		if m.Comment != "" {
+			fmt.Fprintf(x.w, "CM_ BO_ %d %s;\n", key(m.ID, m.Extended), quote(m.Comment))
+		}
+		for _, s := range m.Signals {
+			if s.Comment != "" {
+				fmt.Fprintf(x.w, "CM_ SG_ %d %s %s;\n", key(m.ID, m.Extended), s.Name, quote(s.Comment))
+			}
+		}
+	}
+}
+
+func (x *writer) attributes() {
+	for _, d := range x.db.AttributeDefs {
+		fmt.Fprintf(x.w, "BA_DEF_ %s %s %s", d.Kind.keyword(), quote(d.Name), d.Type)
+		switch d.Type {
+		case AttrInt, AttrHex, AttrFloat:
+			fmt.Fprintf(x.w, " %s %s", num(d.Min), num(d.Max))
+		case AttrString:
+			fmt.Fprintf(x.w, " ")
+		case AttrEnum:
+			enum := make([]string, len(d.Enum))
+			for i, e := range d.Enum {
+				enum[i] = quote(e)
+			}
+			fmt.Fprintf(x.w, "  %s", strings.Join(enum, ","))
+		}
+		fmt.Fprintf(x.w, ";\n")
+	}
+	x.extras("BA_DEF_SGTYPE_", "BA_DEF_REL_")
+	for _, d := range x.db.AttributeDefs {
+		if d.Default != nil && !x.defaultWritten(d.Name) {
+			fmt.Fprintf(x.w, "BA_DEF_DEF_  %s %s;\n", quote(d.Name), value(d.Default))
+		}
+	}
+	x.extras("BA_DEF_DEF_", "BA_DEF_DEF_REL_")
+
+	x.attributeValues(x.db.Attributes, "")
+	for _, n := range x.db.Nodes {
+		x.attributeValues(n.Attributes, "BU_ "+n.Name+" ")
+	}
+	for _, m := range x.db.Messages {
+		id := key(m.ID, m.Extended)
+		x.attributeValues(m.Attributes, fmt.Sprintf("BO_ %d ", id))
+		for _, s := range m.Signals {
+			x.attributeValues(s.Attributes, fmt.Sprintf("SG_ %d %s ", id, s.Name))
+		}
+	}
+	x.extras("BA_", "BA_SGTYPE_", "BA_REL_")
+}
+
+// defaultWritten tells whether a default for name, defined for several kinds, was written already.
+func (x *writer) defaultWritten(name string) bool {
+	if x.written == nil {
+		x.written = make(map[string]bool)
+	}
+	done := x.written[name]
+	x.written[name] = true
+	return done
+}
+
+func (x *writer) attributeValues(attrs Attributes, object string) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(x.w, "BA_ %s %s%s;\n", quote(name), object, value(attrs[name]))
+	}
+}
+
+func (x *writer) values() {
+	for _, m := range x.db.Messages {
+		for _, s := range m.Signals {
+			if len(s.Values) != 0 {
+				fmt.Fprintf(x.w, "VAL_ %d %s%s;\n", key(m.ID, m.Extended), s.Name, descriptions(s.Values))
+			}
+		}
+	}
+	x.extras("VAL_")
+}
+
+// extras writes the Extra statements with the keywords.
+func (x *writer) extras(keywords ...string) {
+	for _, kw := range keywords {
+		for _, e := range x.extra[kw] {
+			fmt.Fprintf(x.w, "%s\n", e)
+		}
+		delete(x.extra, kw)
+	}
+}
+
+// rest writes the Extra statements with keywords not written yet.
+func (x *writer) rest() {
+	for _, e := range x.db.Extra {
+		if _, ok := x.extra[strings.Fields(e)[0]]; ok {
+			fmt.Fprintf(x.w, "%s\n", e)
+		}
+	}
+}
+
+// extendedMux tells whether m needs SG_MUL_VAL_ lines.
+func extendedMux(m *Message) bool {
+	switches := 0
+	for _, s := range m.Signals {
+		if s.IsMultiplexer {
+			switches++
+		}
+		if !s.Multiplexed() {
+			continue
+		}
+		if s.IsMultiplexer || len(s.MultiplexValues) != 1 || s.MultiplexValues[0].Min != s.MultiplexValues[0].Max {
+			return true
+		}
+	}
+	return switches > 1
+}
+
+func muxIndicator(s *Signal) string {
+	switch {
+	case s.Multiplexed() && len(s.MultiplexValues) != 0:
+		ind := fmt.Sprintf(" m%d", s.MultiplexValues[0].Min)
+		if s.IsMultiplexer {
+			ind += "M"
+		}
+		return ind
+	case s.IsMultiplexer:
+		return " M"
+	}
+	return ""
+}
+
+func descriptions(values map[int64]string) string {
+	raws := make([]int64, 0, len(values))
+	for raw := range values {
+		raws = append(raws, raw)
+	}
+	sort.Slice(raws, func(i, j int) bool { return raws[i] > raws[j] })
+	var b strings.Builder
+	for _, raw := range raws {
+		fmt.Fprintf(&b, " %d %s", raw, quote(values[raw]))
+	}
+	b.WriteString(" ")
+	return b.String()
+}
+
+func value(v interface{}) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return num(v)
+	case string:
+		return quote(v)
+	}
+	return quote(fmt.Sprint(v))
+}
+
+// num formats v short, integers without exponent.
+func num(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e18 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// prefixed returns s after a space, or nothing when empty.
+func prefixed(s string) string {
+	if s == "" {
+		return ""
+	}
+	return " " + s
+}
+
+// keyword returns the DBC keyword of the kind, empty for the network.
+func (k ObjectKind) keyword() string {
+	return [...]string{"", "BU_", "BO_", "SG_", "EV_"}[k]
+}
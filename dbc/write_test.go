@@ -0,0 +1,168 @@
+package dbc
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDBC = `VERSION "1.2"
+
+
+NS_ :
+	NS_DESC_
+	CM_
+	BA_DEF_
+
+BS_:
+
+BU_: Engine Gateway Dash
+VAL_TABLE_ Gears 3 "D" 2 "N" 1 "R" 0 "P" ;
+
+
+BO_ 256 EngineData: 8 Engine
+ SG_ Speed : 0|16@1+ (0.25,0) [0|16383.75] "rpm" Gateway,Dash
+ SG_ Temp : 16|8@1- (1,-40) [-168|87] "degC" Dash
+ SG_ Gear : 24|2@1+ (1,0) [0|3] "" Dash
+ SG_ Pressure : 39|12@0+ (0.1,0) [0|409.5] "kPa" Dash
+
+BO_ 2566844692 Diag: 8 Gateway
+ SG_ Service M : 0|8@1+ (1,0) [0|255] "" Engine
+ SG_ Sub m1M : 8|8@1+ (1,0) [0|255] "" Engine
+ SG_ Value m2 : 16|32@1- (1,0) [0|0] "" Engine
+ SG_ Level m1 : 16|32@1+ (1,0) [0|0] "" Engine
+ SG_ Ratio m3 : 32|32@1- (1,0) [0|0] "" Engine
+
+BO_ 512 Dashboard: 2 Dash
+ SG_ Lamp : 0|1@1+ (1,0) [0|1] "" Engine
+
+BO_TX_BU_ 256 : Engine,Gateway;
+
+EV_ EngineMode: 0 [0|3] "" 0 1 DUMMY_NODE_VECTOR0 Vector__XXX;
+
+
+CM_ "Test network";
+CM_ BU_ Engine "Engine control unit";
+CM_ BO_ 256 "Engine state
+in two lines";
+CM_ SG_ 256 Temp "Coolant temperature";
+BA_DEF_ BO_  "GenMsgCycleTime" INT 0 10000;
+BA_DEF_ SG_  "GenSigStartValue" FLOAT 0 100000;
+BA_DEF_  "BusType" STRING ;
+BA_DEF_ BO_  "GenMsgSendType" ENUM  "Cyclic","OnChange";
+BA_DEF_DEF_  "GenMsgCycleTime" 0;
+BA_DEF_DEF_  "GenSigStartValue" 0;
+BA_DEF_DEF_  "BusType" "CAN";
+BA_DEF_DEF_  "GenMsgSendType" "Cyclic";
+BA_ "BusType" "CAN FD";
+BA_ "GenMsgCycleTime" BO_ 256 100;
+BA_ "GenMsgSendType" BO_ 512 1;
+BA_ "GenSigStartValue" SG_ 256 Temp 40;
+VAL_ 256 Gear 3 "D" 2 "N" 1 "R" 0 "P" ;
+SIG_GROUP_ 256 Powertrain 1 : Speed Gear;
+SIG_VALTYPE_ 2566844692 Ratio : 1;
+SG_MUL_VAL_ 2566844692 Sub Service 1-1;
+SG_MUL_VAL_ 2566844692 Level Sub 1-1, 3-4;
+SG_MUL_VAL_ 2566844692 Value Service 2-2;
+SG_MUL_VAL_ 2566844692 Ratio Service 3-3;
+`
+
+func TestWriteParse(t *testing.T) {
+	db, err := Parse(strings.NewReader(testDBC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := db.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	written := b.String()
+	again, err := Parse(&b)
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, written)
+	}
+	if !reflect.DeepEqual(again, db) {
+		t.Errorf("database changed by writing it:\n%s", written)
+	}
+
+	// A few of the parsed details, to know the comparison covers them.
+	m, ok := db.MessageByName("Diag")
+	if !ok || m.ID != 0x18FEF114 || !m.Extended {
+		t.Fatalf("Diag message %+v", m)
+	}
+	if s, _ := m.Signal("Level"); s.Multiplexer != "Sub" || !reflect.DeepEqual(s.MultiplexValues, []MuxRange{{1, 1}, {3, 4}}) {
+		t.Errorf("Level multiplexed by %s %v", s.Multiplexer, s.MultiplexValues)
+	}
+	if s, _ := m.Signal("Ratio"); s.Type != Float32 {
+		t.Errorf("Ratio of type %d", s.Type)
+	}
+	m, _ = db.MessageByName("EngineData")
+	if db.CycleTime(m) != 100*time.Millisecond {
+		t.Errorf("cycle time %v", db.CycleTime(m))
+	}
+	if m.Comment != "Engine state\nin two lines" || len(m.Transmitters) != 2 || len(m.SignalGroups) != 1 {
+		t.Errorf("EngineData %q %v %v", m.Comment, m.Transmitters, m.SignalGroups)
+	}
+	if len(db.Extra) != 1 || !strings.HasPrefix(db.Extra[0], "EV_ EngineMode") {
+		t.Errorf("extra %q", db.Extra)
+	}
+}
+
+func TestEditWriteParse(t *testing.T) {
+	db := new(Database).Init()
+	for _, name := range []string{"Engine", "Dash"} {
+		if _, err := db.AddNode(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := &Message{ID: 0x123, Name: "Status", Size: 8, Sender: "Engine"}
+	if err := db.AddMessage(m); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Signal{
+		{Name: "Speed", StartBit: 0, Length: 16, ByteOrder: LittleEndian, Factor: 0.5, Max: 32767.5, Unit: "km/h", Receivers: []string{"Dash"}},
+		{Name: "Torque", StartBit: 23, Length: 12, ByteOrder: BigEndian, Signed: true, Factor: 1, Offset: -100, Min: -2148, Max: 1947},
+		{Name: "Ratio", StartBit: 32, Length: 32, ByteOrder: LittleEndian, Type: Float32, Factor: 1},
+	} {
+		if err := m.AddSignal(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.RenameNode("Dash", "Cluster"); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := db.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	written := b.String()
+	again, err := Parse(&b)
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, written)
+	}
+	m, ok := again.Message(0x123, false)
+	if !ok {
+		t.Fatalf("no message in\n%s", written)
+	}
+	if s, _ := m.Signal("Speed"); !reflect.DeepEqual(s.Receivers, []string{"Cluster"}) {
+		t.Errorf("receivers %v", s.Receivers)
+	}
+
+	values := map[string]float64{"Speed": 123.5, "Torque": -1000, "Ratio": 0.75}
+	f, err := again.Encode(m, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := db.Decode(&f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range values {
+		if got[name].Physical != want {
+			t.Errorf("%s: %v, want %v", name, got[name].Physical, want)
+		}
+	}
+}
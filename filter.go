@@ -34,3 +34,11 @@ func NewExtInvFilter(id uint32) Filter {
 		Mask: unix.CAN_EFF_MASK,
 	}
 }
+
+// NewStdMaskFilter matches the standard data frames whose ID masked with mask equals id masked with mask.
+func NewStdMaskFilter(id uint32, mask uint32) Filter {
+	return Filter{
+		Id:   id & unix.CAN_SFF_MASK,
+		Mask: mask&unix.CAN_SFF_MASK | unix.CAN_EFF_FLAG | unix.CAN_RTR_FLAG,
+	}
+}
@@ -0,0 +1,198 @@
+package flash
+
+import (
+	"fmt"
+	"hash/crc32"
+
+	"github.com/lion187chen/socketcan-go/uds"
+)
+
+// Common routine identifiers of programming sequences.
+const (
+	RoutineEraseMemory       = 0xFF00
+	RoutineCheckMemory       = 0x0202
+	RoutineCheckDependencies = 0xFF01
+)
+
+// Stage of the programming sequence reported through Flasher.Progress.
+type Stage int
+
+const (
+	StageSession Stage = iota
+	StageSecurity
+	StageErase
+	StageDownload
+	StageCheck
+	StageReset
+	StageDone
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageSession:
+		return "session"
+	case StageSecurity:
+		return "security"
+	case StageErase:
+		return "erase"
+	case StageDownload:
+		return "download"
+	case StageCheck:
+		return "check"
+	case StageReset:
+		return "reset"
+	case StageDone:
+		return "done"
+	}
+	return fmt.Sprintf("Stage(%d)", int(s))
+}
+
+// Flasher runs the standard UDS programming sequence:
+// extended session, programming session, security access, then per segment
+// erase routine, RequestDownload, TransferData blocks, RequestTransferExit and
+// check routine, and finally an ECU reset.
+type Flasher struct {
+	client *uds.Client
+
+	// SecurityLevel is the requestSeed level unlocked with SeedKey, skipped when SeedKey is nil.
+	SecurityLevel uint8
+	SeedKey       uds.SeedKeyFunc
+	// DataFormat is the dataFormatIdentifier of RequestDownload, 0 for neither compressed nor encrypted.
+	DataFormat uint8
+	// EraseRoutine and CheckRoutine are skipped when 0.
+	EraseRoutine uint16
+	CheckRoutine uint16
+	// EraseOption and CheckOption build the routine option records of a segment.
+	// The defaults send address and size, and the CRC-32 of the data.
+	EraseOption func(seg *Segment) []byte
+	CheckOption func(seg *Segment) []byte
+	// Reset is the ECU reset type sent at the end, skipped when 0.
+	Reset uint8
+	// Progress is called as the sequence advances, done and total count bytes during StageDownload.
+	Progress func(stage Stage, done, total int)
+}
+
+func (my *Flasher) Init(client *uds.Client) *Flasher {
+	my.client = client
+	my.SecurityLevel = 0x01
+	my.EraseRoutine = RoutineEraseMemory
+	my.CheckRoutine = RoutineCheckMemory
+	my.EraseOption = addressAndSize
+	my.CheckOption = checksum
+	my.Reset = uds.ResetHard
+	return my
+}
+
+// Flash will block until all segments are programmed or a step failed.
+func (my *Flasher) Flash(segs []Segment) error {
+	total := 0
+	for _, seg := range segs {
+		total += len(seg.Data)
+	}
+
+	my.progress(StageSession, 0, total)
+	if err := my.client.SessionControl(uds.SessionExtended); err != nil {
+		return fmt.Errorf("couldn't enter extended session: %w", err)
+	}
+	if err := my.client.SessionControl(uds.SessionProgramming); err != nil {
+		return fmt.Errorf("couldn't enter programming session: %w", err)
+	}
+
+	if my.SeedKey != nil {
+		my.progress(StageSecurity, 0, total)
+		if err := my.client.SecurityAccess(my.SecurityLevel, my.SeedKey); err != nil {
+			return fmt.Errorf("couldn't unlock security level %#02x: %w", my.SecurityLevel, err)
+		}
+	}
+
+	done := 0
+	for i := range segs {
+		seg := &segs[i]
+		if my.EraseRoutine != 0 {
+			my.progress(StageErase, done, total)
+			if err := my.routine(my.EraseRoutine, my.EraseOption(seg)); err != nil {
+				return fmt.Errorf("couldn't erase %#08x: %w", seg.Address, err)
+			}
+		}
+
+		if err := my.download(seg, &done, total); err != nil {
+			return fmt.Errorf("couldn't download %#08x: %w", seg.Address, err)
+		}
+
+		if my.CheckRoutine != 0 {
+			my.progress(StageCheck, done, total)
+			if err := my.routine(my.CheckRoutine, my.CheckOption(seg)); err != nil {
+				return fmt.Errorf("couldn't check %#08x: %w", seg.Address, err)
+			}
+		}
+	}
+
+	if my.Reset != 0 {
+		my.progress(StageReset, done, total)
+		if err := my.client.ECUReset(my.Reset); err != nil {
+			return fmt.Errorf("couldn't reset: %w", err)
+		}
+	}
+	my.progress(StageDone, done, total)
+	return nil
+}
+
+// Flasher private.
+
+func (my *Flasher) download(seg *Segment, done *int, total int) error {
+	my.progress(StageDownload, *done, total)
+	max, err := my.client.RequestDownload(my.DataFormat, seg.Address, uint32(len(seg.Data)))
+	if err != nil {
+		return err
+	}
+	// maxNumberOfBlockLength counts the service identifier and block sequence counter too.
+	block := max - 2
+	if block <= 0 {
+		return fmt.Errorf("invalid maxNumberOfBlockLength %d", max)
+	}
+
+	counter := uint8(1)
+	for off := 0; off < len(seg.Data); off += block {
+		end := off + block
+		if end > len(seg.Data) {
+			end = len(seg.Data)
+		}
+		if _, err := my.client.TransferData(counter, seg.Data[off:end]); err != nil {
+			return err
+		}
+		counter++
+		*done += end - off
+		my.progress(StageDownload, *done, total)
+	}
+
+	_, err = my.client.RequestTransferExit(nil)
+	return err
+}
+
+// routine starts a routine, a non-zero first status byte counts as failure.
+func (my *Flasher) routine(id uint16, option []byte) error {
+	status, err := my.client.RoutineControl(uds.RoutineStart, id, option)
+	if err != nil {
+		return err
+	}
+	if len(status) > 0 && status[0] != 0 {
+		return fmt.Errorf("routine %#04x failed with status %#02x", id, status[0])
+	}
+	return nil
+}
+
+func (my *Flasher) progress(stage Stage, done, total int) {
+	if my.Progress != nil {
+		my.Progress(stage, done, total)
+	}
+}
+
+func addressAndSize(seg *Segment) []byte {
+	a, n := seg.Address, uint32(len(seg.Data))
+	return []byte{0x44, byte(a >> 24), byte(a >> 16), byte(a >> 8), byte(a), byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+}
+
+func checksum(seg *Segment) []byte {
+	sum := crc32.ChecksumIEEE(seg.Data)
+	return []byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}
+}
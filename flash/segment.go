@@ -0,0 +1,178 @@
+package flash
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Segment is a contiguous block of memory.
+type Segment struct {
+	Address uint32
+	Data    []byte
+}
+
+// Parse reads an Intel HEX or Motorola S-record file, telling them apart by the first record.
+func Parse(r io.Reader) ([]Segment, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't detect file format: %w", err)
+		}
+		switch b[0] {
+		case ':':
+			return ParseIntelHex(br)
+		case 'S', 's':
+			return ParseSRecord(br)
+		case '\r', '\n', ' ', '\t':
+			br.ReadByte()
+		default:
+			return nil, fmt.Errorf("unknown file format")
+		}
+	}
+}
+
+// ParseIntelHex reads an Intel HEX file into merged segments.
+func ParseIntelHex(r io.Reader) ([]Segment, error) {
+	var chunks []Segment
+	var base uint32
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if text[0] != ':' {
+			return nil, fmt.Errorf("line %d: missing ':'", line)
+		}
+		rec, err := hex.DecodeString(text[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(rec) < 5 || len(rec) != int(rec[0])+5 {
+			return nil, fmt.Errorf("line %d: invalid record length", line)
+		}
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("line %d: checksum mismatch", line)
+		}
+
+		data := rec[4 : len(rec)-1]
+		switch rec[3] {
+		case 0x00:
+			addr := base + (uint32(rec[1])<<8 | uint32(rec[2]))
+			chunks = append(chunks, Segment{Address: addr, Data: data})
+		case 0x01:
+			return merge(chunks)
+		case 0x02:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %d: invalid extended segment address", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 4
+		case 0x04:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %d: invalid extended linear address", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 16
+		case 0x03, 0x05:
+			// Start addresses don't matter for flashing.
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type %#02x", line, rec[3])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return merge(chunks)
+}
+
+// ParseSRecord reads a Motorola S-record file into merged segments.
+func ParseSRecord(r io.Reader) ([]Segment, error) {
+	var chunks []Segment
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if len(text) < 4 || text[0] != 'S' && text[0] != 's' {
+			return nil, fmt.Errorf("line %d: missing 'S'", line)
+		}
+		rec, err := hex.DecodeString(text[2:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(rec) < 1 || len(rec) != int(rec[0])+1 {
+			return nil, fmt.Errorf("line %d: invalid record length", line)
+		}
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0xFF {
+			return nil, fmt.Errorf("line %d: checksum mismatch", line)
+		}
+
+		var addrLen int
+		switch text[1] {
+		case '1':
+			addrLen = 2
+		case '2':
+			addrLen = 3
+		case '3':
+			addrLen = 4
+		case '0', '5', '6':
+			// Header and record counts.
+			continue
+		case '7', '8', '9':
+			return merge(chunks)
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type S%c", line, text[1])
+		}
+		if len(rec) < 2+addrLen {
+			return nil, fmt.Errorf("line %d: record too short", line)
+		}
+		var addr uint32
+		for _, b := range rec[1 : 1+addrLen] {
+			addr = addr<<8 | uint32(b)
+		}
+		chunks = append(chunks, Segment{Address: addr, Data: rec[1+addrLen : len(rec)-1]})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return merge(chunks)
+}
+
+// merge sorts chunks and joins the contiguous ones, overlaps are an error.
+func merge(chunks []Segment) ([]Segment, error) {
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].Address < chunks[j].Address
+	})
+	var segs []Segment
+	for _, c := range chunks {
+		if len(c.Data) == 0 {
+			continue
+		}
+		if n := len(segs); n > 0 {
+			last := &segs[n-1]
+			end := uint64(last.Address) + uint64(len(last.Data))
+			if uint64(c.Address) < end {
+				return nil, fmt.Errorf("data at %#08x overlaps", c.Address)
+			}
+			if uint64(c.Address) == end {
+				last.Data = append(last.Data, c.Data...)
+				continue
+			}
+		}
+		segs = append(segs, Segment{Address: c.Address, Data: append([]byte(nil), c.Data...)})
+	}
+	return segs, nil
+}
@@ -0,0 +1,185 @@
+package isotp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// Default timeouts, N_Bs and N_Cr of ISO 15765-2.
+const (
+	DefaultTimeout = 1000 * time.Millisecond
+	maxWait        = 10
+)
+
+// Conn is an ISO 15765-2 (ISO-TP) connection between a pair of CAN IDs on classic CAN.
+// It is not safe for concurrent use.
+type Conn struct {
+	bus      socketcan.Bus
+	txID     uint32
+	rxID     uint32
+	extended bool
+	rx       *Receiver
+
+	// Timeout waiting for flow control or the next consecutive frame.
+	Timeout time.Duration
+	// Padding fills frames up to 8 bytes with PadByte.
+	Padding bool
+	PadByte byte
+}
+
+// txID is the ID we send on, rxID the one we receive on, extended selects 29 bit IDs.
+// blockSize and stMin are announced to the sender of incoming messages.
+func (my *Conn) Init(bus socketcan.Bus, txID, rxID uint32, extended bool, blockSize, stMin uint8) *Conn {
+	my.bus = bus
+	my.txID = txID
+	my.rxID = rxID
+	my.extended = extended
+	my.rx = new(Receiver).Init(blockSize, stMin)
+	my.Timeout = DefaultTimeout
+	my.Padding = true
+	my.PadByte = 0xCC
+	return my
+}
+
+// Send will block until the whole message is sent.
+func (my *Conn) Send(data []byte) error {
+	if len(data) <= 7 {
+		return my.sendFrame(append([]byte{byte(len(data))}, data...))
+	}
+	if len(data) > MaxLen {
+		return fmt.Errorf("message too long: %d bytes", len(data))
+	}
+
+	var ff []byte
+	if len(data) <= 0xFFF {
+		ff = []byte{pciFF<<4 | byte(len(data)>>8), byte(len(data))}
+	} else {
+		ff = []byte{pciFF << 4, 0, byte(len(data) >> 24), byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}
+	}
+	n := 8 - len(ff)
+	if err := my.sendFrame(append(ff, data[:n]...)); err != nil {
+		return err
+	}
+
+	sn := byte(1)
+	for n < len(data) {
+		bs, stMin, err := my.waitFlowControl()
+		if err != nil {
+			return err
+		}
+		for i := 0; n < len(data) && (bs == 0 || i < int(bs)); i++ {
+			if i > 0 {
+				time.Sleep(stMin)
+			}
+			end := n + 7
+			if end > len(data) {
+				end = len(data)
+			}
+			if err := my.sendFrame(append([]byte{pciCF<<4 | sn}, data[n:end]...)); err != nil {
+				return err
+			}
+			sn = (sn + 1) & 0xF
+			n = end
+		}
+	}
+	return nil
+}
+
+// Receive will block until a complete message arrived.
+// timeout limits the wait for its first frame, a zero timeout blocks without limit.
+func (my *Conn) Receive(timeout time.Duration) ([]byte, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	my.rx.Reset()
+	for {
+		d, err := my.rcvData(deadline)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, fc, err := my.rx.Feed(d)
+		if fc != nil {
+			if err := my.sendFrame(fc); err != nil {
+				return nil, err
+			}
+		}
+		if err != nil && !errors.Is(err, errUnexpectedCF) {
+			return nil, err
+		}
+		if msg != nil {
+			return msg, nil
+		}
+		if my.rx.Busy() {
+			deadline = time.Now().Add(my.Timeout)
+		}
+	}
+}
+
+// Conn private.
+
+// rcvData returns the payload of the next frame on rxID.
+func (my *Conn) rcvData(deadline time.Time) ([]byte, error) {
+	for {
+		f, err := socketcan.RcvFrameUntil(my.bus, deadline)
+		if err != nil {
+			return nil, err
+		}
+		if f.ID == my.rxID && f.IsExtended == my.extended && !f.IsRemote && !f.IsError {
+			return f.Data, nil
+		}
+	}
+}
+
+func (my *Conn) waitFlowControl() (uint8, time.Duration, error) {
+	deadline := time.Now().Add(my.Timeout)
+	for wait := 0; ; {
+		d, err := my.rcvData(deadline)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(d) < 3 || d[0]>>4 != pciFC {
+			continue
+		}
+		switch d[0] & 0xF {
+		case fsCTS:
+			return d[1], STmin(d[2]), nil
+		case fsWait:
+			wait++
+			if wait > maxWait {
+				return 0, 0, errors.New("too many flow control waits")
+			}
+			deadline = time.Now().Add(my.Timeout)
+		case fsOverflow:
+			return 0, 0, errors.New("receiver overflow")
+		default:
+			return 0, 0, fmt.Errorf("invalid flow status %d", d[0]&0xF)
+		}
+	}
+}
+
+func (my *Conn) sendFrame(d []byte) error {
+	for my.Padding && len(d) < canframe.FRAME_MAX_DATA_LEN {
+		d = append(d, my.PadByte)
+	}
+	f := canframe.Frame{ID: my.txID, Data: d, IsExtended: my.extended}
+	_, err := my.bus.SendFrame(&f)
+	return err
+}
+
+// STmin decodes the separation time byte of a flow control frame.
+func STmin(b byte) time.Duration {
+	switch {
+	case b <= 0x7F:
+		return time.Duration(b) * time.Millisecond
+	case b >= 0xF1 && b <= 0xF9:
+		return time.Duration(b-0xF0) * 100 * time.Microsecond
+	}
+	// Reserved values mean the longest time.
+	return 127 * time.Millisecond
+}
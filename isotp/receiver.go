@@ -0,0 +1,122 @@
+package isotp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Protocol control information types, the high nibble of the first byte.
+const (
+	pciSF = 0x0
+	pciFF = 0x1
+	pciCF = 0x2
+	pciFC = 0x3
+)
+
+// Flow status of a flow control frame.
+const (
+	fsCTS      = 0x0
+	fsWait     = 0x1
+	fsOverflow = 0x2
+)
+
+// MaxLen is the longest message this package sends or accepts.
+const MaxLen = 1 << 20
+
+var errUnexpectedCF = errors.New("unexpected consecutive frame")
+
+// Receiver reassembles the messages of one sender frame by frame.
+// Conn uses it, it is exported to receive from several senders at once, as OBD functional requests do.
+type Receiver struct {
+	blockSize uint8
+	stMin     uint8
+	data      []byte
+	size      int
+	sn        uint8
+	block     int
+}
+
+// blockSize and stMin are announced in our flow control frames, 0 means no limit.
+func (my *Receiver) Init(blockSize, stMin uint8) *Receiver {
+	my.blockSize = blockSize
+	my.stMin = stMin
+	my.Reset()
+	return my
+}
+
+// Reset drops a half received message.
+func (my *Receiver) Reset() {
+	my.data = nil
+	my.size = 0
+}
+
+// Busy reports whether a multi frame message is being received.
+func (my *Receiver) Busy() bool {
+	return my.data != nil
+}
+
+// Feed processes the payload of one frame from the sender.
+// msg is the complete message once it is done. fc is the flow control frame payload
+// to send back when non-nil. Flow control frames are the sender's business and are ignored.
+func (my *Receiver) Feed(d []byte) (msg []byte, fc []byte, err error) {
+	if len(d) == 0 {
+		return nil, nil, nil
+	}
+
+	switch d[0] >> 4 {
+	case pciSF:
+		my.Reset()
+		n := int(d[0] & 0xF)
+		if n == 0 || n > len(d)-1 {
+			return nil, nil, fmt.Errorf("invalid single frame length %d", n)
+		}
+		return append([]byte(nil), d[1:1+n]...), nil, nil
+	case pciFF:
+		if len(d) < 8 {
+			return nil, nil, fmt.Errorf("first frame too short: %d bytes", len(d))
+		}
+		my.Reset()
+		size := int(d[0]&0xF)<<8 | int(d[1])
+		payload := d[2:]
+		if size == 0 {
+			size = int(d[2])<<24 | int(d[3])<<16 | int(d[4])<<8 | int(d[5])
+			payload = d[6:]
+		}
+		if size > MaxLen {
+			return nil, []byte{pciFC<<4 | fsOverflow, 0, 0}, fmt.Errorf("message too long: %d bytes", size)
+		}
+		if size <= len(payload) {
+			return nil, nil, fmt.Errorf("invalid first frame length %d", size)
+		}
+		my.size = size
+		my.data = append(make([]byte, 0, size), payload...)
+		my.sn = 1
+		my.block = 0
+		return nil, my.flowControl(), nil
+	case pciCF:
+		if my.data == nil {
+			return nil, nil, errUnexpectedCF
+		}
+		if d[0]&0xF != my.sn {
+			my.Reset()
+			return nil, nil, fmt.Errorf("wrong sequence number %d", d[0]&0xF)
+		}
+		my.sn = (my.sn + 1) & 0xF
+		my.data = append(my.data, d[1:]...)
+		if len(my.data) >= my.size {
+			msg = my.data[:my.size]
+			my.Reset()
+			return msg, nil, nil
+		}
+		my.block++
+		if my.blockSize != 0 && my.block == int(my.blockSize) {
+			my.block = 0
+			return nil, my.flowControl(), nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func (my *Receiver) flowControl() []byte {
+	return []byte{pciFC<<4 | fsCTS, my.blockSize, my.stMin}
+}
@@ -0,0 +1,232 @@
+package j1939
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LampStatus is the 2 bit on/off state of a diagnostic lamp, see J1939-73.
+type LampStatus uint8
+
+const (
+	LampOff LampStatus = iota
+	LampOn
+	LampErr
+	LampNA
+)
+
+// LampFlash is the 2 bit flash state of a diagnostic lamp.
+type LampFlash uint8
+
+const (
+	FlashSlow LampFlash = iota
+	FlashFast
+	FlashReserved
+	FlashOff
+)
+
+// Lamp is one diagnostic lamp.
+type Lamp struct {
+	Status LampStatus
+	Flash  LampFlash
+}
+
+// Lamps are the four lamps leading DM1 and DM2.
+type Lamps struct {
+	MalfunctionIndicator Lamp
+	RedStop              Lamp
+	AmberWarning         Lamp
+	Protect              Lamp
+}
+
+// ConversionMethod tells how the SPN is laid out in a DTC.
+type ConversionMethod uint8
+
+const (
+	// ConversionV4 is the current layout, SPN least significant byte first. Sent with CM bit 0.
+	ConversionV4 ConversionMethod = iota
+	// ConversionV1 is the legacy layout, SPN most significant byte first. Sent with CM bit 1.
+	ConversionV1
+)
+
+// DTC is a diagnostic trouble code.
+type DTC struct {
+	SPN uint32
+	FMI uint8
+	// OC is the 7 bit occurrence count, 0x7F if not available.
+	OC uint8
+	CM ConversionMethod
+}
+
+// DM is the payload of DM1 (active) and DM2 (previously active) messages.
+type DM struct {
+	Lamps Lamps
+	DTCs  []DTC
+}
+
+// Acknowledgement control bytes.
+const (
+	AckPositive      = 0
+	AckNegative      = 1
+	AckAccessDenied  = 2
+	AckCannotRespond = 3
+)
+
+// AckError is returned when a node answered a request with anything but a positive acknowledgement.
+type AckError struct {
+	Source  uint8
+	PGN     PGN
+	Control uint8
+}
+
+func (e *AckError) Error() string {
+	return fmt.Sprintf("request for PGN %d rejected by %#02x, control %d", e.PGN, e.Source, e.Control)
+}
+
+// DecodeDM decodes a DM1 or DM2 payload.
+// Each DTC is decoded with the conversion method its CM bit announces.
+func DecodeDM(data []byte) (DM, error) {
+	var dm DM
+	if len(data) < 2 {
+		return dm, fmt.Errorf("DM too short: %d bytes", len(data))
+	}
+	dm.Lamps = Lamps{
+		MalfunctionIndicator: Lamp{LampStatus(data[0]>>6) & 3, LampFlash(data[1]>>6) & 3},
+		RedStop:              Lamp{LampStatus(data[0]>>4) & 3, LampFlash(data[1]>>4) & 3},
+		AmberWarning:         Lamp{LampStatus(data[0]>>2) & 3, LampFlash(data[1]>>2) & 3},
+		Protect:              Lamp{LampStatus(data[0]) & 3, LampFlash(data[1]) & 3},
+	}
+
+	for b := data[2:]; len(b) >= 4; b = b[4:] {
+		cm := ConversionV4
+		if b[3]&0x80 != 0 {
+			cm = ConversionV1
+		}
+		dtc := DecodeDTC(b, cm)
+		// All zeros is "no DTC", all ones is padding.
+		if dtc.SPN == 0 && dtc.FMI == 0 || dtc.SPN == 0x7FFFF && dtc.FMI == 0x1F {
+			continue
+		}
+		dm.DTCs = append(dm.DTCs, dtc)
+	}
+	return dm, nil
+}
+
+// DecodeDTC decodes the 4 byte DTC at the start of b with the given conversion method.
+func DecodeDTC(b []byte, cm ConversionMethod) DTC {
+	dtc := DTC{
+		FMI: b[2] & 0x1F,
+		OC:  b[3] & 0x7F,
+		CM:  cm,
+	}
+	if cm == ConversionV1 {
+		dtc.SPN = uint32(b[0])<<11 | uint32(b[1])<<3 | uint32(b[2]>>5)
+	} else {
+		dtc.SPN = uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2]>>5)<<16
+	}
+	return dtc
+}
+
+// Encode is the 4 byte wire form of the DTC.
+func (dtc DTC) Encode() []byte {
+	b := make([]byte, 4)
+	if dtc.CM == ConversionV1 {
+		b[0] = byte(dtc.SPN >> 11)
+		b[1] = byte(dtc.SPN >> 3)
+		b[2] = byte(dtc.SPN&0x7)<<5 | dtc.FMI&0x1F
+		b[3] = 0x80 | dtc.OC&0x7F
+	} else {
+		b[0] = byte(dtc.SPN)
+		b[1] = byte(dtc.SPN >> 8)
+		b[2] = byte(dtc.SPN>>16&0x7)<<5 | dtc.FMI&0x1F
+		b[3] = dtc.OC & 0x7F
+	}
+	return b
+}
+
+// Encode is the DM1/DM2 payload, padded to 8 bytes.
+func (dm *DM) Encode() []byte {
+	l := &dm.Lamps
+	data := []byte{
+		byte(l.MalfunctionIndicator.Status&3)<<6 | byte(l.RedStop.Status&3)<<4 | byte(l.AmberWarning.Status&3)<<2 | byte(l.Protect.Status&3),
+		byte(l.MalfunctionIndicator.Flash&3)<<6 | byte(l.RedStop.Flash&3)<<4 | byte(l.AmberWarning.Flash&3)<<2 | byte(l.Protect.Flash&3),
+	}
+	if len(dm.DTCs) == 0 {
+		return append(data, 0, 0, 0, 0, 0xFF, 0xFF)
+	}
+	for _, dtc := range dm.DTCs {
+		data = append(data, dtc.Encode()...)
+	}
+	for len(data) < 8 {
+		data = append(data, 0xFF)
+	}
+	return data
+}
+
+// ReceiveDM1 will block until the next DM1 from source arrived, use AddrGlobal for any source.
+func (my *J1939) ReceiveDM1(source uint8, timeout time.Duration) (uint8, DM, error) {
+	msg, err := my.receive(timeout, func(m *Message) bool {
+		return m.PGN == PGNDM1 && (source == AddrGlobal || m.Source == source)
+	})
+	if err != nil {
+		return 0, DM{}, err
+	}
+	dm, err := DecodeDM(msg.Data)
+	return msg.Source, dm, err
+}
+
+// RequestDM2 requests the previously active DTCs from dest and waits for its DM2.
+func (my *J1939) RequestDM2(dest uint8, timeout time.Duration) (DM, error) {
+	if err := my.Request(PGNDM2, dest); err != nil {
+		return DM{}, err
+	}
+	msg, err := my.waitResponse(PGNDM2, dest, timeout)
+	if err != nil {
+		return DM{}, err
+	}
+	return DecodeDM(msg.Data)
+}
+
+// ClearDM3 asks dest to clear its previously active DTCs.
+// For a specific dest it waits for the acknowledgement, a global request is not acknowledged.
+func (my *J1939) ClearDM3(dest uint8, timeout time.Duration) error {
+	return my.clear(PGNDM3, dest, timeout)
+}
+
+// ClearDM11 asks dest to clear its active DTCs, acknowledged like ClearDM3().
+func (my *J1939) ClearDM11(dest uint8, timeout time.Duration) error {
+	return my.clear(PGNDM11, dest, timeout)
+}
+
+func (my *J1939) clear(pgn PGN, dest uint8, timeout time.Duration) error {
+	if err := my.Request(pgn, dest); err != nil {
+		return err
+	}
+	if dest == AddrGlobal {
+		return nil
+	}
+	_, err := my.waitResponse(pgn, dest, timeout)
+	var ack *AckError
+	if errors.As(err, &ack) && ack.Control == AckPositive {
+		return nil
+	}
+	return err
+}
+
+// waitResponse waits for pgn from source, an acknowledgement for pgn is returned as *AckError.
+func (my *J1939) waitResponse(pgn PGN, source uint8, timeout time.Duration) (Message, error) {
+	msg, err := my.receive(timeout, func(m *Message) bool {
+		if source != AddrGlobal && m.Source != source {
+			return false
+		}
+		return m.PGN == pgn || m.PGN == PGNAck && len(m.Data) >= 8 && tpPGN(m.Data) == pgn
+	})
+	if err != nil {
+		return msg, err
+	}
+	if msg.PGN == PGNAck {
+		return msg, &AckError{Source: msg.Source, PGN: pgn, Control: msg.Data[0]}
+	}
+	return msg, nil
+}
@@ -0,0 +1,61 @@
+package j1939
+
+// PGN is a J1939 parameter group number.
+type PGN uint32
+
+// Some well known PGNs.
+const (
+	PGNAck     PGN = 0xE800 // Acknowledgement
+	PGNRequest PGN = 0xEA00 // Request
+	PGNTPDT    PGN = 0xEB00 // Transport protocol data transfer
+	PGNTPCM    PGN = 0xEC00 // Transport protocol connection management
+	PGNDM1     PGN = 0xFECA // Active diagnostic trouble codes
+	PGNDM2     PGN = 0xFECB // Previously active diagnostic trouble codes
+	PGNDM3     PGN = 0xFECC // Diagnostic data clear/reset of previously active DTCs
+	PGNDM11    PGN = 0xFED3 // Diagnostic data clear/reset for active DTCs
+)
+
+const (
+	// AddrGlobal is the global (broadcast) destination address.
+	AddrGlobal uint8 = 0xFF
+	// AddrNull is the null address used by nodes without a claimed address.
+	AddrNull uint8 = 0xFE
+)
+
+// IsPDU1 reports whether the PGN is destination specific (PDU format < 240).
+func (pgn PGN) IsPDU1() bool {
+	return (pgn>>8)&0xFF < 240
+}
+
+// ID is a decoded 29 bit J1939 CAN identifier.
+type ID struct {
+	Priority uint8
+	PGN      PGN
+	Source   uint8
+	// Dest is AddrGlobal for PDU2 PGNs.
+	Dest uint8
+}
+
+// ParseID splits a 29 bit CAN ID into its J1939 fields.
+func ParseID(canID uint32) ID {
+	id := ID{
+		Priority: uint8(canID>>26) & 0x7,
+		PGN:      PGN(canID>>8) & 0x3FFFF,
+		Source:   uint8(canID),
+		Dest:     AddrGlobal,
+	}
+	if id.PGN.IsPDU1() {
+		id.Dest = uint8(id.PGN)
+		id.PGN &^= 0xFF
+	}
+	return id
+}
+
+// CanID builds the 29 bit CAN ID.
+func (id ID) CanID() uint32 {
+	pgn := uint32(id.PGN) & 0x3FFFF
+	if id.PGN.IsPDU1() {
+		pgn = pgn&^0xFF | uint32(id.Dest)
+	}
+	return uint32(id.Priority&0x7)<<26 | pgn<<8 | uint32(id.Source)
+}
@@ -0,0 +1,411 @@
+package j1939
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+)
+
+// Transport protocol limits and timeouts, see J1939-21.
+const (
+	MaxDataLen = 1785
+
+	tpPacketLen = 7
+	bamGap      = 50 * time.Millisecond
+	timeoutT1   = 750 * time.Millisecond
+	timeoutT2   = 1250 * time.Millisecond
+	timeoutT3   = 1250 * time.Millisecond
+	timeoutT4   = 1050 * time.Millisecond
+	maxPending  = 64
+)
+
+// TP.CM control bytes.
+const (
+	cmRTS   = 0x10
+	cmCTS   = 0x11
+	cmEOMA  = 0x13
+	cmBAM   = 0x20
+	cmAbort = 0xFF
+)
+
+// TP.CM abort reasons.
+const (
+	abortTimeout     = 3
+	abortBadSequence = 7
+)
+
+// Message is a J1939 parameter group, Data may be longer than one CAN frame.
+type Message struct {
+	Priority uint8
+	PGN      PGN
+	Source   uint8
+	// Dest is AddrGlobal for broadcasts and PDU2 PGNs.
+	Dest uint8
+	Data []byte
+}
+
+// J1939 sends and receives J1939 messages on a Bus, running the transport protocol
+// (BAM and RTS/CTS) for messages longer than 8 bytes.
+// It is not safe for concurrent use.
+type J1939 struct {
+	bus      socketcan.Bus
+	addr     uint8
+	pending  []Message
+	sessions map[uint16]*session
+}
+
+// A transport session we are receiving, keyed by source<<8|dest.
+type session struct {
+	bam      bool
+	priority uint8
+	pgn      PGN
+	size     int
+	packets  int
+	next     int
+	window   int
+	max      int
+	data     []byte
+	deadline time.Time
+}
+
+// addr is our own source address.
+func (my *J1939) Init(bus socketcan.Bus, addr uint8) *J1939 {
+	my.bus = bus
+	my.addr = addr
+	my.pending = nil
+	my.sessions = make(map[uint16]*session)
+	return my
+}
+
+// Our own source address.
+func (my *J1939) Addr() uint8 {
+	return my.addr
+}
+
+// Send will block until the message is sent, for RTS/CTS transfers until the receiver acknowledged it.
+// The source address is always our own.
+func (my *J1939) Send(msg *Message) error {
+	if len(msg.Data) <= canframe.FRAME_MAX_DATA_LEN {
+		return my.sendFrame(msg.Priority, msg.PGN, msg.Dest, msg.Data)
+	}
+	if len(msg.Data) > MaxDataLen {
+		return fmt.Errorf("message too long: %d bytes", len(msg.Data))
+	}
+	if !msg.PGN.IsPDU1() || msg.Dest == AddrGlobal {
+		return my.sendBAM(msg)
+	}
+	return my.sendRTS(msg)
+}
+
+// Receive will block until a complete message for us or a broadcast arrived.
+// A zero timeout blocks without limit.
+func (my *J1939) Receive(timeout time.Duration) (Message, error) {
+	return my.receive(timeout, nil)
+}
+
+// Request sends a request (PGN 59904) for pgn to dest, which may be AddrGlobal.
+func (my *J1939) Request(pgn PGN, dest uint8) error {
+	return my.Send(&Message{
+		Priority: 6,
+		PGN:      PGNRequest,
+		Dest:     dest,
+		Data:     []byte{byte(pgn), byte(pgn >> 8), byte(pgn >> 16)},
+	})
+}
+
+// J1939 private.
+
+// receive returns the first message match accepts, keeping the others for later calls.
+func (my *J1939) receive(timeout time.Duration, match func(*Message) bool) (Message, error) {
+	for i := range my.pending {
+		if match == nil || match(&my.pending[i]) {
+			msg := my.pending[i]
+			my.pending = append(my.pending[:i], my.pending[i+1:]...)
+			return msg, nil
+		}
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		f, err := socketcan.RcvFrameUntil(my.bus, my.wakeup(deadline))
+		my.expire()
+		if errors.Is(err, socketcan.ErrTimeout) {
+			if !deadline.IsZero() && !time.Now().Before(deadline) {
+				return Message{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return Message{}, err
+		}
+
+		msg, ok := my.handle(&f)
+		if !ok {
+			continue
+		}
+		if match == nil || match(&msg) {
+			return msg, nil
+		}
+		my.keep(msg)
+	}
+}
+
+func (my *J1939) keep(msg Message) {
+	if len(my.pending) >= maxPending {
+		my.pending = my.pending[1:]
+	}
+	my.pending = append(my.pending, msg)
+}
+
+// wakeup is the earlier of deadline and the first session timeout.
+func (my *J1939) wakeup(deadline time.Time) time.Time {
+	for _, s := range my.sessions {
+		if deadline.IsZero() || s.deadline.Before(deadline) {
+			deadline = s.deadline
+		}
+	}
+	return deadline
+}
+
+func (my *J1939) expire() {
+	now := time.Now()
+	for key, s := range my.sessions {
+		if now.Before(s.deadline) {
+			continue
+		}
+		if !s.bam {
+			my.abort(uint8(key>>8), s.pgn, abortTimeout)
+		}
+		delete(my.sessions, key)
+	}
+}
+
+// handle processes one received frame and returns a message once one is complete.
+func (my *J1939) handle(f *canframe.Frame) (Message, bool) {
+	if !f.IsExtended || f.IsRemote || f.IsError {
+		return Message{}, false
+	}
+	id := ParseID(f.ID)
+	if id.Dest != my.addr && id.Dest != AddrGlobal {
+		return Message{}, false
+	}
+
+	switch id.PGN {
+	case PGNTPCM:
+		my.handleCM(id, f.Data)
+		return Message{}, false
+	case PGNTPDT:
+		return my.handleDT(id, f.Data)
+	}
+	return Message{
+		Priority: id.Priority,
+		PGN:      id.PGN,
+		Source:   id.Source,
+		Dest:     id.Dest,
+		Data:     append([]byte(nil), f.Data...),
+	}, true
+}
+
+func (my *J1939) handleCM(id ID, d []byte) {
+	if len(d) < 8 {
+		return
+	}
+	key := uint16(id.Source)<<8 | uint16(id.Dest)
+	pgn := tpPGN(d)
+	size := int(d[1]) | int(d[2])<<8
+	packets := int(d[3])
+
+	switch d[0] {
+	case cmBAM:
+		if id.Dest != AddrGlobal || !validSize(size, packets) {
+			return
+		}
+		my.sessions[key] = &session{
+			bam:      true,
+			priority: id.Priority,
+			pgn:      pgn,
+			size:     size,
+			packets:  packets,
+			next:     1,
+			data:     make([]byte, 0, packets*tpPacketLen),
+			deadline: time.Now().Add(timeoutT1),
+		}
+	case cmRTS:
+		if id.Dest != my.addr || !validSize(size, packets) {
+			return
+		}
+		s := &session{
+			priority: id.Priority,
+			pgn:      pgn,
+			size:     size,
+			packets:  packets,
+			next:     1,
+			max:      int(d[4]),
+			data:     make([]byte, 0, packets*tpPacketLen),
+		}
+		my.sessions[key] = s
+		my.sendCTS(id.Source, s)
+	case cmAbort:
+		delete(my.sessions, key)
+	}
+}
+
+func (my *J1939) handleDT(id ID, d []byte) (Message, bool) {
+	key := uint16(id.Source)<<8 | uint16(id.Dest)
+	s, ok := my.sessions[key]
+	if !ok || len(d) < 1 {
+		return Message{}, false
+	}
+	if int(d[0]) != s.next {
+		if !s.bam {
+			my.abort(id.Source, s.pgn, abortBadSequence)
+		}
+		delete(my.sessions, key)
+		return Message{}, false
+	}
+
+	payload := d[1:]
+	if len(payload) > tpPacketLen {
+		payload = payload[:tpPacketLen]
+	}
+	s.data = append(s.data, payload...)
+	s.next++
+	s.deadline = time.Now().Add(timeoutT1)
+
+	if s.next <= s.packets {
+		if !s.bam && s.next > s.window {
+			my.sendCTS(id.Source, s)
+		}
+		return Message{}, false
+	}
+
+	delete(my.sessions, key)
+	if len(s.data) < s.size {
+		return Message{}, false
+	}
+	if !s.bam {
+		my.sendFrame(7, PGNTPCM, id.Source, tpCM(cmEOMA, s.size, s.packets, 0xFF, s.pgn))
+	}
+	return Message{
+		Priority: s.priority,
+		PGN:      s.pgn,
+		Source:   id.Source,
+		Dest:     id.Dest,
+		Data:     s.data[:s.size],
+	}, true
+}
+
+func (my *J1939) sendCTS(dest uint8, s *session) {
+	n := s.packets - s.next + 1
+	if s.max != 0 && n > s.max {
+		n = s.max
+	}
+	s.window = s.next + n - 1
+	s.deadline = time.Now().Add(timeoutT2)
+	my.sendFrame(7, PGNTPCM, dest, []byte{cmCTS, byte(n), byte(s.next), 0xFF, 0xFF, byte(s.pgn), byte(s.pgn >> 8), byte(s.pgn >> 16)})
+}
+
+func (my *J1939) abort(dest uint8, pgn PGN, reason uint8) {
+	my.sendFrame(7, PGNTPCM, dest, []byte{cmAbort, reason, 0xFF, 0xFF, 0xFF, byte(pgn), byte(pgn >> 8), byte(pgn >> 16)})
+}
+
+func (my *J1939) sendBAM(msg *Message) error {
+	packets := (len(msg.Data) + tpPacketLen - 1) / tpPacketLen
+	err := my.sendFrame(7, PGNTPCM, AddrGlobal, tpCM(cmBAM, len(msg.Data), packets, 0xFF, msg.PGN))
+	if err != nil {
+		return err
+	}
+	for seq := 1; seq <= packets; seq++ {
+		time.Sleep(bamGap)
+		if err := my.sendDT(AddrGlobal, seq, msg.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (my *J1939) sendRTS(msg *Message) error {
+	packets := (len(msg.Data) + tpPacketLen - 1) / tpPacketLen
+	err := my.sendFrame(7, PGNTPCM, msg.Dest, tpCM(cmRTS, len(msg.Data), packets, 0xFF, msg.PGN))
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeoutT3)
+	for {
+		f, err := socketcan.RcvFrameUntil(my.bus, deadline)
+		if err != nil {
+			if errors.Is(err, socketcan.ErrTimeout) {
+				my.abort(msg.Dest, msg.PGN, abortTimeout)
+			}
+			return err
+		}
+
+		id := ParseID(f.ID)
+		if !f.IsExtended || id.PGN != PGNTPCM || id.Source != msg.Dest || id.Dest != my.addr ||
+			len(f.Data) < 8 || tpPGN(f.Data) != msg.PGN {
+			if m, ok := my.handle(&f); ok {
+				my.keep(m)
+			}
+			continue
+		}
+
+		switch f.Data[0] {
+		case cmCTS:
+			n, next := int(f.Data[1]), int(f.Data[2])
+			if n == 0 {
+				deadline = time.Now().Add(timeoutT4)
+				continue
+			}
+			for seq := next; seq < next+n && seq <= packets; seq++ {
+				if err := my.sendDT(msg.Dest, seq, msg.Data); err != nil {
+					return err
+				}
+			}
+			deadline = time.Now().Add(timeoutT3)
+		case cmEOMA:
+			return nil
+		case cmAbort:
+			return fmt.Errorf("transfer aborted by %#02x, reason %d", msg.Dest, f.Data[1])
+		}
+	}
+}
+
+func (my *J1939) sendDT(dest uint8, seq int, data []byte) error {
+	d := []byte{byte(seq), 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	start := (seq - 1) * tpPacketLen
+	end := start + tpPacketLen
+	if end > len(data) {
+		end = len(data)
+	}
+	copy(d[1:], data[start:end])
+	return my.sendFrame(7, PGNTPDT, dest, d)
+}
+
+func (my *J1939) sendFrame(priority uint8, pgn PGN, dest uint8, data []byte) error {
+	f := canframe.Frame{
+		ID:         ID{Priority: priority, PGN: pgn, Source: my.addr, Dest: dest}.CanID(),
+		Data:       data,
+		IsExtended: true,
+	}
+	_, err := my.bus.SendFrame(&f)
+	return err
+}
+
+func tpCM(ctrl byte, size, packets int, max byte, pgn PGN) []byte {
+	return []byte{ctrl, byte(size), byte(size >> 8), byte(packets), max, byte(pgn), byte(pgn >> 8), byte(pgn >> 16)}
+}
+
+func tpPGN(d []byte) PGN {
+	return PGN(d[5]) | PGN(d[6])<<8 | PGN(d[7])<<16
+}
+
+func validSize(size, packets int) bool {
+	return size > canframe.FRAME_MAX_DATA_LEN && size <= MaxDataLen && packets == (size+tpPacketLen-1)/tpPacketLen
+}
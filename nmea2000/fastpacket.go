@@ -0,0 +1,124 @@
+package nmea2000
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/j1939"
+)
+
+// MaxFastPacketLen is the longest payload a fast packet carries, 6 bytes in frame 0 and 7 in up to 31 more.
+const MaxFastPacketLen = 223
+
+// An unfinished fast packet is dropped after this long without a new frame.
+const fastPacketTimeout = 750 * time.Millisecond
+
+var fastPacketPGNs = map[j1939.PGN]bool{
+	126208: true, 126464: true, 126720: true, 126983: true, 126984: true, 126985: true,
+	126986: true, 126987: true, 126988: true, 126996: true, 126998: true, 127233: true,
+	127237: true, 127489: true, 127496: true, 127497: true, 127498: true, 127503: true,
+	127504: true, 127506: true, 127507: true, 127509: true, 127510: true, 127511: true,
+	127512: true, 127513: true, 127514: true, 128275: true, 128520: true, 129029: true,
+	129038: true, 129039: true, 129040: true, 129041: true, 129044: true, 129045: true,
+	129284: true, 129285: true, 129301: true, 129302: true, 129538: true, 129540: true,
+	129541: true, 129542: true, 129545: true, 129547: true, 129549: true, 129551: true,
+	129556: true, 129792: true, 129793: true, 129794: true, 129795: true, 129796: true,
+	129797: true, 129798: true, 129799: true, 129800: true, 129801: true, 129802: true,
+	129803: true, 129804: true, 129805: true, 129806: true, 129807: true, 129808: true,
+	129809: true, 129810: true, 130052: true, 130053: true, 130054: true, 130060: true,
+	130061: true, 130064: true, 130065: true, 130066: true, 130067: true, 130068: true,
+	130069: true, 130070: true, 130071: true, 130072: true, 130073: true, 130074: true,
+	130320: true, 130321: true, 130322: true, 130323: true, 130324: true, 130560: true,
+	130561: true, 130562: true, 130563: true, 130564: true, 130565: true, 130566: true,
+	130567: true, 130569: true, 130570: true, 130571: true, 130572: true, 130573: true,
+	130574: true, 130577: true, 130578: true, 130816: true, 130817: true, 130818: true,
+	130819: true, 130820: true, 130821: true, 130822: true, 130823: true, 130824: true,
+	130825: true, 130827: true, 130828: true, 130830: true, 130831: true, 130832: true,
+	130834: true, 130835: true, 130836: true, 130837: true, 130838: true, 130839: true,
+	130840: true, 130842: true, 130843: true, 130845: true, 130846: true, 130847: true,
+	130850: true, 130851: true, 130856: true, 130880: true, 130881: true, 130944: true,
+}
+
+// IsFastPacket reports whether pgn is sent as a fast packet.
+func IsFastPacket(pgn j1939.PGN) bool {
+	return fastPacketPGNs[pgn]
+}
+
+// RegisterFastPacket marks a (proprietary) pgn as fast packet. Call it before any NMEA2000 is in use.
+func RegisterFastPacket(pgn j1939.PGN) {
+	fastPacketPGNs[pgn] = true
+}
+
+// Fragment splits data into the 8 byte frame payloads of one fast packet.
+// seq is the 3 bit sequence counter, the sender should increase it for each packet of a PGN.
+func Fragment(seq uint8, data []byte) ([][]byte, error) {
+	if len(data) > MaxFastPacketLen {
+		return nil, fmt.Errorf("fast packet too long: %d bytes", len(data))
+	}
+	seq = (seq & 0x7) << 5
+
+	first := []byte{seq, byte(len(data)), 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	n := copy(first[2:], data)
+	frames := [][]byte{first}
+	for index := byte(1); n < len(data); index++ {
+		f := []byte{seq | index, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+		n += copy(f[1:], data[n:])
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
+
+// Assembler reassembles fast packets from their frames, one packet per source and PGN at a time.
+type Assembler struct {
+	packets map[uint64]*fastPacket
+}
+
+type fastPacket struct {
+	seq      uint8
+	next     uint8
+	size     int
+	data     []byte
+	deadline time.Time
+}
+
+func (my *Assembler) Init() *Assembler {
+	my.packets = make(map[uint64]*fastPacket)
+	return my
+}
+
+// Add feeds one frame payload of pgn from source and returns the payload once the packet is complete.
+// Out of order frames discard the packet.
+func (my *Assembler) Add(source uint8, pgn j1939.PGN, frame []byte) ([]byte, bool) {
+	if len(frame) < 2 {
+		return nil, false
+	}
+	key := uint64(pgn)<<8 | uint64(source)
+	seq, index := frame[0]>>5, frame[0]&0x1F
+	now := time.Now()
+
+	p, ok := my.packets[key]
+	if index == 0 {
+		size := int(frame[1])
+		if size > MaxFastPacketLen {
+			delete(my.packets, key)
+			return nil, false
+		}
+		p = &fastPacket{seq: seq, next: 1, size: size, data: make([]byte, 0, size+6)}
+		p.data = append(p.data, frame[2:]...)
+		my.packets[key] = p
+	} else {
+		if !ok || p.seq != seq || p.next != index || now.After(p.deadline) {
+			delete(my.packets, key)
+			return nil, false
+		}
+		p.data = append(p.data, frame[1:]...)
+		p.next++
+	}
+	p.deadline = now.Add(fastPacketTimeout)
+
+	if len(p.data) < p.size {
+		return nil, false
+	}
+	delete(my.packets, key)
+	return p.data[:p.size], true
+}
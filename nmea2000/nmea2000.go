@@ -0,0 +1,82 @@
+package nmea2000
+
+import (
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/j1939"
+)
+
+// NMEA2000 sends and receives NMEA 2000 messages on a Bus.
+// It runs on J1939 framing and transport, and adds fast packet handling for the PGNs IsFastPacket() reports.
+// It is not safe for concurrent use.
+type NMEA2000 struct {
+	j   *j1939.J1939
+	fp  *Assembler
+	seq map[j1939.PGN]uint8
+}
+
+// addr is our own source address.
+func (my *NMEA2000) Init(bus socketcan.Bus, addr uint8) *NMEA2000 {
+	my.j = new(j1939.J1939).Init(bus, addr)
+	my.fp = new(Assembler).Init()
+	my.seq = make(map[j1939.PGN]uint8)
+	return my
+}
+
+// The J1939 layer underneath, e.g. to send requests.
+func (my *NMEA2000) J1939() *j1939.J1939 {
+	return my.j
+}
+
+// Send will block until all frames of the message are sent.
+// Fast packet PGNs are always sent as fast packets, others longer than 8 bytes use the J1939 transport protocol.
+func (my *NMEA2000) Send(msg *j1939.Message) error {
+	if !IsFastPacket(msg.PGN) {
+		return my.j.Send(msg)
+	}
+
+	seq := my.seq[msg.PGN]
+	my.seq[msg.PGN] = seq + 1
+	frames, err := Fragment(seq, msg.Data)
+	if err != nil {
+		return err
+	}
+	for _, data := range frames {
+		err = my.j.Send(&j1939.Message{Priority: msg.Priority, PGN: msg.PGN, Dest: msg.Dest, Data: data})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Receive will block until a complete message arrived, fast packets are returned reassembled.
+// A zero timeout blocks without limit.
+func (my *NMEA2000) Receive(timeout time.Duration) (j1939.Message, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		var remain time.Duration
+		if !deadline.IsZero() {
+			remain = time.Until(deadline)
+			if remain <= 0 {
+				return j1939.Message{}, socketcan.ErrTimeout
+			}
+		}
+		msg, err := my.j.Receive(remain)
+		if err != nil {
+			return msg, err
+		}
+		// Longer ones came through the J1939 transport protocol.
+		if !IsFastPacket(msg.PGN) || len(msg.Data) > 8 {
+			return msg, nil
+		}
+		if data, ok := my.fp.Add(msg.Source, msg.PGN, msg.Data); ok {
+			msg.Data = data
+			return msg, nil
+		}
+	}
+}
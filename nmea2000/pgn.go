@@ -0,0 +1,532 @@
+package nmea2000
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lion187chen/socketcan-go/j1939"
+)
+
+// Decoder is implemented by the decoded form of every supported PGN.
+// Values the sender marked as not available decode as NaN.
+type Decoder interface {
+	Unmarshal(data []byte) error
+}
+
+// ErrUnknownPGN is returned by Decode() for PGNs without a decoder.
+var ErrUnknownPGN = errors.New("unknown PGN")
+
+var decoders = map[j1939.PGN]func() Decoder{
+	126992: func() Decoder { return new(SystemTime) },
+	127250: func() Decoder { return new(VesselHeading) },
+	127251: func() Decoder { return new(RateOfTurn) },
+	127257: func() Decoder { return new(Attitude) },
+	127488: func() Decoder { return new(EngineRapid) },
+	127489: func() Decoder { return new(EngineDynamic) },
+	127505: func() Decoder { return new(FluidLevel) },
+	127508: func() Decoder { return new(BatteryStatus) },
+	128259: func() Decoder { return new(Speed) },
+	128267: func() Decoder { return new(WaterDepth) },
+	129025: func() Decoder { return new(PositionRapid) },
+	129026: func() Decoder { return new(COGSOGRapid) },
+	129029: func() Decoder { return new(GNSSPosition) },
+	130306: func() Decoder { return new(Wind) },
+	130310: func() Decoder { return new(Environment) },
+	130312: func() Decoder { return new(Temperature) },
+}
+
+// Decode decodes a received message into one of the PGN types of this package.
+func Decode(msg *j1939.Message) (Decoder, error) {
+	newDecoder, ok := decoders[msg.PGN]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownPGN, msg.PGN)
+	}
+	d := newDecoder()
+	if err := d.Unmarshal(msg.Data); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SystemTime is PGN 126992.
+type SystemTime struct {
+	SID    uint8
+	Source uint8
+	// Zero if not available.
+	Time time.Time
+}
+
+func (my *SystemTime) Unmarshal(data []byte) error {
+	if err := checkLen(126992, data, 8); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.Source = data[1] & 0xF
+	my.Time = dateTime(data[2:])
+	return nil
+}
+
+// HeadingReference tells whether a heading is true or magnetic.
+type HeadingReference uint8
+
+const (
+	HeadingTrue HeadingReference = iota
+	HeadingMagnetic
+	HeadingError
+	HeadingNA
+)
+
+// VesselHeading is PGN 127250, angles in degrees.
+type VesselHeading struct {
+	SID       uint8
+	Heading   float64
+	Deviation float64
+	Variation float64
+	Reference HeadingReference
+}
+
+func (my *VesselHeading) Unmarshal(data []byte) error {
+	if err := checkLen(127250, data, 8); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.Heading = degrees(u16(data[1:], 0.0001))
+	my.Deviation = degrees(i16(data[3:], 0.0001))
+	my.Variation = degrees(i16(data[5:], 0.0001))
+	my.Reference = HeadingReference(data[7] & 0x3)
+	return nil
+}
+
+// RateOfTurn is PGN 127251, Rate in degrees per second.
+type RateOfTurn struct {
+	SID  uint8
+	Rate float64
+}
+
+func (my *RateOfTurn) Unmarshal(data []byte) error {
+	if err := checkLen(127251, data, 5); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.Rate = degrees(i32(data[1:], 3.125e-8))
+	return nil
+}
+
+// Attitude is PGN 127257, angles in degrees.
+type Attitude struct {
+	SID   uint8
+	Yaw   float64
+	Pitch float64
+	Roll  float64
+}
+
+func (my *Attitude) Unmarshal(data []byte) error {
+	if err := checkLen(127257, data, 7); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.Yaw = degrees(i16(data[1:], 0.0001))
+	my.Pitch = degrees(i16(data[3:], 0.0001))
+	my.Roll = degrees(i16(data[5:], 0.0001))
+	return nil
+}
+
+// EngineRapid is PGN 127488, engine parameters rapid update.
+type EngineRapid struct {
+	Instance uint8
+	// rpm
+	Speed float64
+	// Pa
+	BoostPressure float64
+	// %
+	TiltTrim float64
+}
+
+func (my *EngineRapid) Unmarshal(data []byte) error {
+	if err := checkLen(127488, data, 6); err != nil {
+		return err
+	}
+	my.Instance = data[0]
+	my.Speed = u16(data[1:], 0.25)
+	my.BoostPressure = u16(data[3:], 100)
+	my.TiltTrim = i8(data[5:], 1)
+	return nil
+}
+
+// EngineDynamic is PGN 127489, engine parameters dynamic.
+type EngineDynamic struct {
+	Instance uint8
+	// Pa
+	OilPressure float64
+	// °C
+	OilTemperature float64
+	// °C
+	Temperature float64
+	// V
+	AlternatorPotential float64
+	// L/h
+	FuelRate float64
+	// s
+	TotalEngineHours float64
+	// Pa
+	CoolantPressure float64
+	// Pa
+	FuelPressure float64
+	Status1      uint16
+	Status2      uint16
+	// %
+	Load float64
+	// %
+	Torque float64
+}
+
+func (my *EngineDynamic) Unmarshal(data []byte) error {
+	if err := checkLen(127489, data, 26); err != nil {
+		return err
+	}
+	my.Instance = data[0]
+	my.OilPressure = u16(data[1:], 100)
+	my.OilTemperature = celsius(u16(data[3:], 0.1))
+	my.Temperature = celsius(u16(data[5:], 0.01))
+	my.AlternatorPotential = i16(data[7:], 0.01)
+	my.FuelRate = i16(data[9:], 0.1)
+	my.TotalEngineHours = u32(data[11:], 1)
+	my.CoolantPressure = u16(data[15:], 100)
+	my.FuelPressure = u16(data[17:], 1000)
+	my.Status1 = uint16(data[20]) | uint16(data[21])<<8
+	my.Status2 = uint16(data[22]) | uint16(data[23])<<8
+	my.Load = i8(data[24:], 1)
+	my.Torque = i8(data[25:], 1)
+	return nil
+}
+
+// FluidLevel is PGN 127505.
+type FluidLevel struct {
+	Instance uint8
+	// 0 fuel, 1 fresh water, 2 waste water, 3 live well, 4 oil, 5 black water.
+	Type uint8
+	// %
+	Level float64
+	// L
+	Capacity float64
+}
+
+func (my *FluidLevel) Unmarshal(data []byte) error {
+	if err := checkLen(127505, data, 7); err != nil {
+		return err
+	}
+	my.Instance = data[0] & 0xF
+	my.Type = data[0] >> 4
+	my.Level = i16(data[1:], 0.004)
+	my.Capacity = u32(data[3:], 0.1)
+	return nil
+}
+
+// BatteryStatus is PGN 127508.
+type BatteryStatus struct {
+	Instance uint8
+	// V
+	Voltage float64
+	// A
+	Current float64
+	// °C
+	Temperature float64
+	SID         uint8
+}
+
+func (my *BatteryStatus) Unmarshal(data []byte) error {
+	if err := checkLen(127508, data, 8); err != nil {
+		return err
+	}
+	my.Instance = data[0]
+	my.Voltage = i16(data[1:], 0.01)
+	my.Current = i16(data[3:], 0.1)
+	my.Temperature = celsius(u16(data[5:], 0.01))
+	my.SID = data[7]
+	return nil
+}
+
+// Speed is PGN 128259, speeds in m/s.
+type Speed struct {
+	SID             uint8
+	WaterReference  float64
+	GroundReference float64
+	// 0 paddle wheel, 1 pitot tube, 2 doppler, 3 correlation, 4 electro magnetic.
+	Type uint8
+}
+
+func (my *Speed) Unmarshal(data []byte) error {
+	if err := checkLen(128259, data, 6); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.WaterReference = u16(data[1:], 0.01)
+	my.GroundReference = u16(data[3:], 0.01)
+	my.Type = data[5]
+	return nil
+}
+
+// WaterDepth is PGN 128267, in m.
+type WaterDepth struct {
+	SID uint8
+	// Below the transducer.
+	Depth float64
+	// Positive to the water line, negative to the keel.
+	Offset float64
+	Range  float64
+}
+
+func (my *WaterDepth) Unmarshal(data []byte) error {
+	if err := checkLen(128267, data, 7); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.Depth = u32(data[1:], 0.01)
+	my.Offset = i16(data[5:], 0.001)
+	my.Range = math.NaN()
+	if len(data) > 7 {
+		my.Range = u8(data[7:], 10)
+	}
+	return nil
+}
+
+// PositionRapid is PGN 129025, in degrees.
+type PositionRapid struct {
+	Latitude  float64
+	Longitude float64
+}
+
+func (my *PositionRapid) Unmarshal(data []byte) error {
+	if err := checkLen(129025, data, 8); err != nil {
+		return err
+	}
+	my.Latitude = i32(data[0:], 1e-7)
+	my.Longitude = i32(data[4:], 1e-7)
+	return nil
+}
+
+// COGSOGRapid is PGN 129026.
+type COGSOGRapid struct {
+	SID       uint8
+	Reference HeadingReference
+	// degrees
+	COG float64
+	// m/s
+	SOG float64
+}
+
+func (my *COGSOGRapid) Unmarshal(data []byte) error {
+	if err := checkLen(129026, data, 6); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.Reference = HeadingReference(data[1] & 0x3)
+	my.COG = degrees(u16(data[2:], 0.0001))
+	my.SOG = u16(data[4:], 0.01)
+	return nil
+}
+
+// GNSSPosition is PGN 129029.
+type GNSSPosition struct {
+	SID uint8
+	// Zero if not available.
+	Time time.Time
+	// degrees
+	Latitude  float64
+	Longitude float64
+	// m
+	Altitude float64
+	// 0 GPS, 1 GLONASS, 2 GPS+GLONASS, ...
+	Type uint8
+	// 0 no GNSS, 1 GNSS fix, 2 DGNSS, ...
+	Method     uint8
+	Integrity  uint8
+	Satellites uint8
+	HDOP       float64
+	PDOP       float64
+	// m
+	GeoidalSeparation float64
+}
+
+func (my *GNSSPosition) Unmarshal(data []byte) error {
+	if err := checkLen(129029, data, 43); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.Time = dateTime(data[1:])
+	my.Latitude = i64(data[7:], 1e-16)
+	my.Longitude = i64(data[15:], 1e-16)
+	my.Altitude = i64(data[23:], 1e-6)
+	my.Type = data[31] & 0xF
+	my.Method = data[31] >> 4
+	my.Integrity = data[32] & 0x3
+	my.Satellites = data[33]
+	my.HDOP = i16(data[34:], 0.01)
+	my.PDOP = i16(data[36:], 0.01)
+	my.GeoidalSeparation = i32(data[38:], 0.01)
+	return nil
+}
+
+// WindReference tells what a wind angle is relative to.
+type WindReference uint8
+
+const (
+	WindTrueNorth WindReference = iota
+	WindMagneticNorth
+	WindApparent
+	WindTrueBoat
+	WindTrueWater
+)
+
+// Wind is PGN 130306.
+type Wind struct {
+	SID uint8
+	// m/s
+	Speed float64
+	// degrees
+	Angle     float64
+	Reference WindReference
+}
+
+func (my *Wind) Unmarshal(data []byte) error {
+	if err := checkLen(130306, data, 6); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.Speed = u16(data[1:], 0.01)
+	my.Angle = degrees(u16(data[3:], 0.0001))
+	my.Reference = WindReference(data[5] & 0x7)
+	return nil
+}
+
+// Environment is PGN 130310, outside environmental parameters.
+type Environment struct {
+	SID uint8
+	// °C
+	WaterTemperature float64
+	// °C
+	AirTemperature float64
+	// Pa
+	AtmosphericPressure float64
+}
+
+func (my *Environment) Unmarshal(data []byte) error {
+	if err := checkLen(130310, data, 7); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.WaterTemperature = celsius(u16(data[1:], 0.01))
+	my.AirTemperature = celsius(u16(data[3:], 0.01))
+	my.AtmosphericPressure = u16(data[5:], 100)
+	return nil
+}
+
+// Temperature is PGN 130312.
+type Temperature struct {
+	SID      uint8
+	Instance uint8
+	// 0 sea, 1 outside, 2 inside, 3 engine room, 4 main cabin, ...
+	Source uint8
+	// °C
+	Actual float64
+	// °C
+	Set float64
+}
+
+func (my *Temperature) Unmarshal(data []byte) error {
+	if err := checkLen(130312, data, 7); err != nil {
+		return err
+	}
+	my.SID = data[0]
+	my.Instance = data[1]
+	my.Source = data[2]
+	my.Actual = celsius(u16(data[3:], 0.01))
+	my.Set = celsius(u16(data[5:], 0.01))
+	return nil
+}
+
+// Field helpers, little endian, all bits set means not available.
+
+func checkLen(pgn j1939.PGN, data []byte, n int) error {
+	if len(data) < n {
+		return fmt.Errorf("PGN %d too short, expected: %d bytes, got: %d bytes", pgn, n, len(data))
+	}
+	return nil
+}
+
+func u8(b []byte, res float64) float64 {
+	if b[0] == 0xFF {
+		return math.NaN()
+	}
+	return float64(b[0]) * res
+}
+
+func i8(b []byte, res float64) float64 {
+	if b[0] == 0x7F {
+		return math.NaN()
+	}
+	return float64(int8(b[0])) * res
+}
+
+func u16(b []byte, res float64) float64 {
+	v := uint16(b[0]) | uint16(b[1])<<8
+	if v == 0xFFFF {
+		return math.NaN()
+	}
+	return float64(v) * res
+}
+
+func i16(b []byte, res float64) float64 {
+	v := int16(uint16(b[0]) | uint16(b[1])<<8)
+	if v == math.MaxInt16 {
+		return math.NaN()
+	}
+	return float64(v) * res
+}
+
+func u32(b []byte, res float64) float64 {
+	v := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+	if v == 0xFFFFFFFF {
+		return math.NaN()
+	}
+	return float64(v) * res
+}
+
+func i32(b []byte, res float64) float64 {
+	v := int32(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
+	if v == math.MaxInt32 {
+		return math.NaN()
+	}
+	return float64(v) * res
+}
+
+func i64(b []byte, res float64) float64 {
+	var v uint64
+	for i := 7; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	if int64(v) == math.MaxInt64 {
+		return math.NaN()
+	}
+	return float64(int64(v)) * res
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+func celsius(kelvin float64) float64 {
+	return kelvin - 273.15
+}
+
+// dateTime decodes the 2 byte days since 1970 and 4 byte 0.0001 s since midnight pair.
+func dateTime(b []byte) time.Time {
+	days := uint16(b[0]) | uint16(b[1])<<8
+	ticks := uint32(b[2]) | uint32(b[3])<<8 | uint32(b[4])<<16 | uint32(b[5])<<24
+	if days == 0xFFFF || ticks == 0xFFFFFFFF {
+		return time.Time{}
+	}
+	return time.Unix(int64(days)*86400, 0).UTC().Add(time.Duration(ticks) * 100 * time.Microsecond)
+}
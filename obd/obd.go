@@ -0,0 +1,324 @@
+package obd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+	"github.com/lion187chen/socketcan-go/canframe"
+	"github.com/lion187chen/socketcan-go/isotp"
+)
+
+// Functional request IDs and the response ID ranges of ISO 15765-4.
+const (
+	FunctionalID    = 0x7DF
+	FunctionalExtID = 0x18DB33F1
+
+	responseID    = 0x7E8
+	responseExtID = 0x18DAF100
+)
+
+// Services (modes).
+const (
+	ModeCurrentData      = 0x01
+	ModeFreezeFrame      = 0x02
+	ModeStoredDTC        = 0x03
+	ModeClearDTC         = 0x04
+	ModePendingDTC       = 0x07
+	ModeVehicleInfo      = 0x09
+	ModePermanentDTC     = 0x0A
+	positiveResponse     = 0x40
+	negativeResponse     = 0x7F
+	nrcResponsePending   = 0x78
+	responsePendingLimit = 5 * time.Second
+)
+
+// Default time to wait for responses, P2 of ISO 15765-4 plus margin.
+const DefaultTimeout = 100 * time.Millisecond
+
+// Response is the answer of one ECU, Data follows the response service identifier.
+type Response struct {
+	// ECU is the CAN ID the ECU responds on.
+	ECU  uint32
+	Data []byte
+}
+
+// OBD is an OBD-II (SAE J1979) scan tool using functional addressing over ISO-TP.
+// It is not safe for concurrent use.
+type OBD struct {
+	bus      socketcan.Bus
+	extended bool
+	rx       map[uint32]*isotp.Receiver
+
+	// Timeout is how long to wait for responses after the last frame.
+	Timeout time.Duration
+}
+
+// extended selects 29 bit addressing (0x18DB33F1), 11 bit (0x7DF) otherwise.
+func (my *OBD) Init(bus socketcan.Bus, extended bool) *OBD {
+	my.bus = bus
+	my.extended = extended
+	my.Timeout = DefaultTimeout
+	return my
+}
+
+// Query sends a functional request and collects the positive responses of all ECUs, ordered by ECU.
+// The request must fit into a single frame.
+func (my *OBD) Query(req []byte) ([]Response, error) {
+	if len(req) == 0 || len(req) > 7 {
+		return nil, fmt.Errorf("invalid request length %d", len(req))
+	}
+	id := uint32(FunctionalID)
+	if my.extended {
+		id = FunctionalExtID
+	}
+	if err := my.send(id, append([]byte{byte(len(req))}, req...)); err != nil {
+		return nil, err
+	}
+
+	my.rx = make(map[uint32]*isotp.Receiver)
+	var res []Response
+	deadline := time.Now().Add(my.Timeout)
+	for {
+		f, err := socketcan.RcvFrameUntil(my.bus, deadline)
+		if socketcan.IsTimeout(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !my.isResponse(&f) {
+			continue
+		}
+
+		rx, ok := my.rx[f.ID]
+		if !ok {
+			rx = new(isotp.Receiver).Init(0, 0)
+			my.rx[f.ID] = rx
+		}
+		msg, fc, err := rx.Feed(f.Data)
+		if fc != nil {
+			if err := my.send(my.physicalID(f.ID), fc); err != nil {
+				return nil, err
+			}
+		}
+		deadline = time.Now().Add(my.Timeout)
+		if err != nil || msg == nil {
+			continue
+		}
+
+		switch {
+		case msg[0] == negativeResponse && len(msg) >= 3 && msg[1] == req[0] && msg[2] == nrcResponsePending:
+			deadline = time.Now().Add(responsePendingLimit)
+		case msg[0] == req[0]+positiveResponse:
+			res = append(res, Response{ECU: f.ID, Data: msg[1:]})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ECU < res[j].ECU
+	})
+	return res, nil
+}
+
+// CurrentData reads a mode 01 PID from all ECUs.
+func (my *OBD) CurrentData(pid uint8) (map[uint32]Value, error) {
+	return my.values(pid, []byte{ModeCurrentData, pid}, 1)
+}
+
+// FreezeFrame reads a mode 02 PID of a freeze frame from all ECUs.
+func (my *OBD) FreezeFrame(pid, frame uint8) (map[uint32]Value, error) {
+	return my.values(pid, []byte{ModeFreezeFrame, pid, frame}, 2)
+}
+
+// SupportedPIDs returns the PIDs each ECU supports in mode 01, 02 or 09.
+func (my *OBD) SupportedPIDs(mode uint8) (map[uint32][]uint8, error) {
+	supported := make(map[uint32][]uint8)
+	ecus := map[uint32]bool{}
+	for base := 0; base < 0x100; base += 0x20 {
+		req := []byte{mode, byte(base)}
+		if mode == ModeFreezeFrame {
+			req = append(req, 0)
+		}
+		res, err := my.Query(req)
+		if err != nil {
+			return nil, err
+		}
+
+		more := false
+		for _, r := range res {
+			if len(r.Data) < len(req)+3 || r.Data[0] != byte(base) {
+				continue
+			}
+			bits := r.Data[len(req)-1:]
+			if base > 0 && !ecus[r.ECU] {
+				continue
+			}
+			ecus[r.ECU] = false
+			for i := 0; i < 32 && base+i+1 <= 0xFF; i++ {
+				if bits[i/8]&(0x80>>(i%8)) != 0 {
+					supported[r.ECU] = append(supported[r.ECU], uint8(base+i+1))
+				}
+			}
+			// The last PID of a range announces the next range.
+			if bits[3]&1 != 0 {
+				ecus[r.ECU] = true
+				more = true
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	return supported, nil
+}
+
+// StoredDTCs reads the confirmed DTCs (mode 03) from all ECUs.
+func (my *OBD) StoredDTCs() (map[uint32][]DTC, error) {
+	return my.dtcs(ModeStoredDTC)
+}
+
+// PendingDTCs reads the pending DTCs (mode 07) from all ECUs.
+func (my *OBD) PendingDTCs() (map[uint32][]DTC, error) {
+	return my.dtcs(ModePendingDTC)
+}
+
+// PermanentDTCs reads the permanent DTCs (mode 0A) from all ECUs.
+func (my *OBD) PermanentDTCs() (map[uint32][]DTC, error) {
+	return my.dtcs(ModePermanentDTC)
+}
+
+// ClearDTCs clears the DTCs and diagnostic information (mode 04) and returns the ECUs that confirmed.
+func (my *OBD) ClearDTCs() ([]uint32, error) {
+	res, err := my.Query([]byte{ModeClearDTC})
+	if err != nil {
+		return nil, err
+	}
+	var ecus []uint32
+	for _, r := range res {
+		ecus = append(ecus, r.ECU)
+	}
+	return ecus, nil
+}
+
+// VIN reads the vehicle identification number (mode 09 PID 02).
+func (my *OBD) VIN() (map[uint32]string, error) {
+	items, err := my.vehicleInfo(0x02, 17)
+	if err != nil {
+		return nil, err
+	}
+	vins := make(map[uint32]string)
+	for ecu, list := range items {
+		if len(list) > 0 {
+			vins[ecu] = list[0]
+		}
+	}
+	return vins, nil
+}
+
+// CalibrationIDs reads the calibration IDs (mode 09 PID 04).
+func (my *OBD) CalibrationIDs() (map[uint32][]string, error) {
+	return my.vehicleInfo(0x04, 16)
+}
+
+// OBD private.
+
+func (my *OBD) values(pid uint8, req []byte, skip int) (map[uint32]Value, error) {
+	res, err := my.Query(req)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[uint32]Value)
+	for _, r := range res {
+		if len(r.Data) < skip || r.Data[0] != pid {
+			continue
+		}
+		v, err := DecodePID(pid, r.Data[skip:])
+		if err != nil {
+			return nil, fmt.Errorf("ECU %#x: %w", r.ECU, err)
+		}
+		values[r.ECU] = v
+	}
+	return values, nil
+}
+
+func (my *OBD) dtcs(mode uint8) (map[uint32][]DTC, error) {
+	res, err := my.Query([]byte{mode})
+	if err != nil {
+		return nil, err
+	}
+	dtcs := make(map[uint32][]DTC)
+	for _, r := range res {
+		// On CAN the number of DTCs leads the list.
+		if len(r.Data) < 1 {
+			continue
+		}
+		list := []DTC{}
+		for b := r.Data[1:]; len(b) >= 2; b = b[2:] {
+			if dtc := DTC(uint16(b[0])<<8 | uint16(b[1])); dtc != 0 {
+				list = append(list, dtc)
+			}
+		}
+		dtcs[r.ECU] = list
+	}
+	return dtcs, nil
+}
+
+// vehicleInfo reads a mode 09 PID made of fixed size, zero padded strings.
+func (my *OBD) vehicleInfo(pid uint8, size int) (map[uint32][]string, error) {
+	res, err := my.Query([]byte{ModeVehicleInfo, pid})
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[uint32][]string)
+	for _, r := range res {
+		if len(r.Data) < 2 || r.Data[0] != pid {
+			continue
+		}
+		var list []string
+		for b := r.Data[2:]; len(b) >= size; b = b[size:] {
+			list = append(list, trimString(b[:size]))
+		}
+		items[r.ECU] = list
+	}
+	return items, nil
+}
+
+func (my *OBD) isResponse(f *canframe.Frame) bool {
+	if f.IsExtended != my.extended || f.IsRemote || f.IsError {
+		return false
+	}
+	if my.extended {
+		return f.ID&0xFFFFFF00 == responseExtID
+	}
+	return f.ID >= responseID && f.ID <= responseID+7
+}
+
+// physicalID is the ID flow control frames to an ECU go to.
+func (my *OBD) physicalID(ecu uint32) uint32 {
+	if my.extended {
+		return 0x18DA00F1 | (ecu&0xFF)<<8
+	}
+	return ecu - 8
+}
+
+func (my *OBD) send(id uint32, d []byte) error {
+	for len(d) < canframe.FRAME_MAX_DATA_LEN {
+		d = append(d, 0xCC)
+	}
+	f := canframe.Frame{ID: id, Data: d, IsExtended: my.extended}
+	_, err := my.bus.SendFrame(&f)
+	return err
+}
+
+func trimString(b []byte) string {
+	start, end := 0, len(b)
+	for start < end && (b[start] == 0 || b[start] == ' ') {
+		start++
+	}
+	for end > start && (b[end-1] == 0 || b[end-1] == ' ') {
+		end--
+	}
+	return string(b[start:end])
+}
@@ -0,0 +1,159 @@
+package obd
+
+import (
+	"fmt"
+)
+
+// Value is a decoded PID.
+type Value struct {
+	PID   uint8
+	Name  string
+	Value float64
+	Unit  string
+	// Raw are the data bytes after the PID.
+	Raw []byte
+}
+
+type pidInfo struct {
+	name   string
+	unit   string
+	size   int
+	decode func(b []byte) float64
+}
+
+func ab(b []byte) float64 {
+	return float64(uint16(b[0])<<8 | uint16(b[1]))
+}
+
+func percent(b []byte) float64 {
+	return float64(b[0]) * 100 / 255
+}
+
+func trim(b []byte) float64 {
+	return float64(b[0])*100/128 - 100
+}
+
+func temperature(b []byte) float64 {
+	return float64(b[0]) - 40
+}
+
+var pids = map[uint8]pidInfo{
+	0x01: {"monitor status", "DTCs", 4, func(b []byte) float64 { return float64(b[0] & 0x7F) }},
+	0x04: {"calculated engine load", "%", 1, percent},
+	0x05: {"engine coolant temperature", "°C", 1, temperature},
+	0x06: {"short term fuel trim bank 1", "%", 1, trim},
+	0x07: {"long term fuel trim bank 1", "%", 1, trim},
+	0x08: {"short term fuel trim bank 2", "%", 1, trim},
+	0x09: {"long term fuel trim bank 2", "%", 1, trim},
+	0x0A: {"fuel pressure", "kPa", 1, func(b []byte) float64 { return float64(b[0]) * 3 }},
+	0x0B: {"intake manifold absolute pressure", "kPa", 1, func(b []byte) float64 { return float64(b[0]) }},
+	0x0C: {"engine speed", "rpm", 2, func(b []byte) float64 { return ab(b) / 4 }},
+	0x0D: {"vehicle speed", "km/h", 1, func(b []byte) float64 { return float64(b[0]) }},
+	0x0E: {"timing advance", "°", 1, func(b []byte) float64 { return float64(b[0])/2 - 64 }},
+	0x0F: {"intake air temperature", "°C", 1, temperature},
+	0x10: {"mass air flow rate", "g/s", 2, func(b []byte) float64 { return ab(b) / 100 }},
+	0x11: {"throttle position", "%", 1, percent},
+	0x1F: {"run time since engine start", "s", 2, ab},
+	0x21: {"distance traveled with MIL on", "km", 2, ab},
+	0x22: {"fuel rail pressure", "kPa", 2, func(b []byte) float64 { return ab(b) * 0.079 }},
+	0x23: {"fuel rail gauge pressure", "kPa", 2, func(b []byte) float64 { return ab(b) * 10 }},
+	0x2C: {"commanded EGR", "%", 1, percent},
+	0x2F: {"fuel tank level input", "%", 1, percent},
+	0x31: {"distance traveled since codes cleared", "km", 2, ab},
+	0x33: {"absolute barometric pressure", "kPa", 1, func(b []byte) float64 { return float64(b[0]) }},
+	0x42: {"control module voltage", "V", 2, func(b []byte) float64 { return ab(b) / 1000 }},
+	0x43: {"absolute load value", "%", 2, func(b []byte) float64 { return ab(b) * 100 / 255 }},
+	0x44: {"commanded air-fuel equivalence ratio", "", 2, func(b []byte) float64 { return ab(b) * 2 / 65536 }},
+	0x45: {"relative throttle position", "%", 1, percent},
+	0x46: {"ambient air temperature", "°C", 1, temperature},
+	0x47: {"absolute throttle position B", "%", 1, percent},
+	0x49: {"accelerator pedal position D", "%", 1, percent},
+	0x4A: {"accelerator pedal position E", "%", 1, percent},
+	0x4C: {"commanded throttle actuator", "%", 1, percent},
+	0x4D: {"time run with MIL on", "min", 2, ab},
+	0x4E: {"time since trouble codes cleared", "min", 2, ab},
+	0x51: {"fuel type", "", 1, func(b []byte) float64 { return float64(b[0]) }},
+	0x52: {"ethanol fuel", "%", 1, percent},
+	0x5A: {"relative accelerator pedal position", "%", 1, percent},
+	0x5B: {"hybrid battery pack remaining life", "%", 1, percent},
+	0x5C: {"engine oil temperature", "°C", 1, temperature},
+	0x5D: {"fuel injection timing", "°", 2, func(b []byte) float64 { return ab(b)/128 - 210 }},
+	0x5E: {"engine fuel rate", "L/h", 2, func(b []byte) float64 { return ab(b) / 20 }},
+	0x61: {"driver's demand engine torque", "%", 1, func(b []byte) float64 { return float64(b[0]) - 125 }},
+	0x62: {"actual engine torque", "%", 1, func(b []byte) float64 { return float64(b[0]) - 125 }},
+	0x63: {"engine reference torque", "Nm", 2, ab},
+	0xA6: {"odometer", "km", 4, func(b []byte) float64 {
+		return float64(uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8|uint32(b[3])) / 10
+	}},
+}
+
+// DecodePID decodes the data bytes following a mode 01 or 02 PID into engineering units.
+// Unknown PIDs are returned with their raw bytes only.
+func DecodePID(pid uint8, data []byte) (Value, error) {
+	v := Value{PID: pid, Raw: append([]byte(nil), data...)}
+	info, ok := pids[pid]
+	if !ok {
+		return v, nil
+	}
+	if len(data) < info.size {
+		return v, fmt.Errorf("PID %#02x too short, expected: %d bytes, got: %d bytes", pid, info.size, len(data))
+	}
+	v.Name = info.name
+	v.Unit = info.unit
+	v.Value = info.decode(data)
+	return v, nil
+}
+
+// MonitorStatus is PID 01.
+type MonitorStatus struct {
+	MIL      bool
+	DTCCount int
+	// Compression ignition (diesel) engine.
+	Diesel bool
+}
+
+// DecodeMonitorStatus decodes the raw bytes of PID 01.
+func DecodeMonitorStatus(raw []byte) (MonitorStatus, error) {
+	if len(raw) < 2 {
+		return MonitorStatus{}, fmt.Errorf("PID 0x01 too short: %d bytes", len(raw))
+	}
+	return MonitorStatus{
+		MIL:      raw[0]&0x80 != 0,
+		DTCCount: int(raw[0] & 0x7F),
+		Diesel:   raw[1]&0x08 != 0,
+	}, nil
+}
+
+// DTC is a 2 byte diagnostic trouble code as read by modes 03, 07 and 0A.
+type DTC uint16
+
+// String formats the code like "P0301".
+func (dtc DTC) String() string {
+	return fmt.Sprintf("%c%d%03X", "PCBU"[dtc>>14], (dtc>>12)&0x3, uint16(dtc)&0xFFF)
+}
+
+// ParseDTC parses a code like "P0301".
+func ParseDTC(s string) (DTC, error) {
+	var letter rune
+	var digit, rest uint16
+	if len(s) != 5 {
+		return 0, fmt.Errorf("invalid DTC %q", s)
+	}
+	if _, err := fmt.Sscanf(s, "%c%1d%03X", &letter, &digit, &rest); err != nil || digit > 3 {
+		return 0, fmt.Errorf("invalid DTC %q", s)
+	}
+	var system uint16
+	switch letter {
+	case 'P':
+		system = 0
+	case 'C':
+		system = 1
+	case 'B':
+		system = 2
+	case 'U':
+		system = 3
+	default:
+		return 0, fmt.Errorf("invalid DTC %q", s)
+	}
+	return DTC(system<<14 | digit<<12 | rest), nil
+}
@@ -0,0 +1,324 @@
+package uds
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default client timeouts, P2 for the first response and P2* after a response pending.
+const (
+	DefaultP2     = 150 * time.Millisecond
+	DefaultP2Star = 5 * time.Second
+	p2Margin      = 50 * time.Millisecond
+)
+
+// SeedKeyFunc computes the SecurityAccess key for a seed, level is the odd requestSeed level.
+type SeedKeyFunc func(level uint8, seed []byte) ([]byte, error)
+
+// DTC is a 3 byte diagnostic trouble code with its status byte.
+type DTC struct {
+	Code   uint32
+	Status uint8
+}
+
+// Client is a UDS (ISO 14229) tester.
+// Requests are serialized, it is safe to call the methods from several goroutines.
+type Client struct {
+	tp Transport
+	mu sync.Mutex
+
+	// P2 and P2Star are updated from the DiagnosticSessionControl response.
+	P2     time.Duration
+	P2Star time.Duration
+
+	stopKeepAlive chan struct{}
+	keepAliveDone chan struct{}
+}
+
+func (my *Client) Init(tp Transport) *Client {
+	my.tp = tp
+	my.P2 = DefaultP2
+	my.P2Star = DefaultP2Star
+	return my
+}
+
+// Request sends a raw request and returns the positive response.
+// Response pending (0x78) negative responses extend the wait to P2*,
+// any other negative response is returned as *NegativeResponseError.
+func (my *Client) Request(req []byte) ([]byte, error) {
+	if len(req) == 0 {
+		return nil, errors.New("empty request")
+	}
+	my.mu.Lock()
+	defer my.mu.Unlock()
+
+	if err := my.tp.Send(req); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(my.P2)
+	for {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, fmt.Errorf("no response to service %#02x", req[0])
+		}
+		res, err := my.tp.Receive(timeout)
+		if err != nil {
+			return nil, err
+		}
+		if len(res) == 0 {
+			continue
+		}
+
+		switch {
+		case res[0] == SIDNegativeResponse && len(res) >= 3 && res[1] == req[0]:
+			if res[2] == NRCResponsePending {
+				deadline = time.Now().Add(my.P2Star)
+				continue
+			}
+			return nil, &NegativeResponseError{SID: req[0], NRC: res[2]}
+		case res[0] == req[0]+positiveResponse:
+			return res, nil
+		}
+		// Anything else is a late response to an earlier request.
+	}
+}
+
+// SessionControl switches to a diagnostic session and takes over the server's P2 timings.
+func (my *Client) SessionControl(session uint8) error {
+	res, err := my.Request([]byte{SIDDiagnosticSessionControl, session})
+	if err != nil {
+		return err
+	}
+	if len(res) >= 6 {
+		p2 := time.Duration(uint16(res[2])<<8|uint16(res[3])) * time.Millisecond
+		p2Star := time.Duration(uint16(res[4])<<8|uint16(res[5])) * 10 * time.Millisecond
+		my.mu.Lock()
+		my.P2 = p2 + p2Margin
+		my.P2Star = p2Star + p2Margin
+		my.mu.Unlock()
+	}
+	return nil
+}
+
+// ECUReset asks the server to reset, see the Reset constants.
+func (my *Client) ECUReset(resetType uint8) error {
+	_, err := my.Request([]byte{SIDECUReset, resetType})
+	return err
+}
+
+// SecurityAccess unlocks level (odd, the requestSeed level) with the key fn computes.
+// A zero seed means the level is unlocked already.
+func (my *Client) SecurityAccess(level uint8, fn SeedKeyFunc) error {
+	if level%2 == 0 {
+		return fmt.Errorf("security level %#02x is not a requestSeed level", level)
+	}
+	res, err := my.Request([]byte{SIDSecurityAccess, level})
+	if err != nil {
+		return err
+	}
+	if len(res) < 3 {
+		return fmt.Errorf("response too short: %d bytes", len(res))
+	}
+	if res[1] != level {
+		return fmt.Errorf("response for wrong security level %#02x", res[1])
+	}
+	seed := res[2:]
+	unlocked := true
+	for _, b := range seed {
+		if b != 0 {
+			unlocked = false
+		}
+	}
+	if unlocked {
+		return nil
+	}
+
+	key, err := fn(level, seed)
+	if err != nil {
+		return fmt.Errorf("couldn't compute key: %w", err)
+	}
+	_, err = my.Request(append([]byte{SIDSecurityAccess, level + 1}, key...))
+	return err
+}
+
+// ReadDataByIdentifier returns the data record of did.
+func (my *Client) ReadDataByIdentifier(did uint16) ([]byte, error) {
+	res, err := my.Request([]byte{SIDReadDataByIdentifier, byte(did >> 8), byte(did)})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) < 3 || uint16(res[1])<<8|uint16(res[2]) != did {
+		return nil, fmt.Errorf("response for wrong identifier")
+	}
+	return res[3:], nil
+}
+
+// WriteDataByIdentifier writes the data record of did.
+func (my *Client) WriteDataByIdentifier(did uint16, data []byte) error {
+	_, err := my.Request(append([]byte{SIDWriteDataByIdentifier, byte(did >> 8), byte(did)}, data...))
+	return err
+}
+
+// ReadDTCInformation runs any subfunction and returns the response after the subfunction byte.
+func (my *Client) ReadDTCInformation(subfunction uint8, params ...byte) ([]byte, error) {
+	res, err := my.Request(append([]byte{SIDReadDTCInformation, subfunction}, params...))
+	if err != nil {
+		return nil, err
+	}
+	if len(res) < 2 || res[1] != subfunction {
+		return nil, fmt.Errorf("response for wrong subfunction")
+	}
+	return res[2:], nil
+}
+
+// NumberOfDTCByStatusMask returns the availability mask, the DTC format and the number of matching DTCs.
+func (my *Client) NumberOfDTCByStatusMask(mask uint8) (uint8, uint8, uint16, error) {
+	res, err := my.ReadDTCInformation(ReportNumberOfDTCByStatusMask, mask)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if len(res) < 4 {
+		return 0, 0, 0, fmt.Errorf("response too short: %d bytes", len(res))
+	}
+	return res[0], res[1], uint16(res[2])<<8 | uint16(res[3]), nil
+}
+
+// DTCByStatusMask returns the availability mask and the DTCs matching mask.
+func (my *Client) DTCByStatusMask(mask uint8) (uint8, []DTC, error) {
+	return my.dtcList(ReportDTCByStatusMask, mask)
+}
+
+// SupportedDTC returns the availability mask and all DTCs the server supports.
+func (my *Client) SupportedDTC() (uint8, []DTC, error) {
+	return my.dtcList(ReportSupportedDTC)
+}
+
+// DTCSnapshotRecord returns the raw snapshot record of dtc, record 0xFF for all.
+func (my *Client) DTCSnapshotRecord(dtc uint32, record uint8) ([]byte, error) {
+	return my.ReadDTCInformation(ReportDTCSnapshotRecordByDTCNumber, byte(dtc>>16), byte(dtc>>8), byte(dtc), record)
+}
+
+// DTCExtDataRecord returns the raw extended data record of dtc, record 0xFF for all.
+func (my *Client) DTCExtDataRecord(dtc uint32, record uint8) ([]byte, error) {
+	return my.ReadDTCInformation(ReportDTCExtDataRecordByDTCNumber, byte(dtc>>16), byte(dtc>>8), byte(dtc), record)
+}
+
+// ClearDTC clears a DTC group, 0xFFFFFF for all.
+func (my *Client) ClearDTC(group uint32) error {
+	_, err := my.Request([]byte{SIDClearDiagnosticInformation, byte(group >> 16), byte(group >> 8), byte(group)})
+	return err
+}
+
+// RoutineControl starts, stops or queries routine id and returns the routine status record.
+func (my *Client) RoutineControl(control uint8, id uint16, option []byte) ([]byte, error) {
+	res, err := my.Request(append([]byte{SIDRoutineControl, control, byte(id >> 8), byte(id)}, option...))
+	if err != nil {
+		return nil, err
+	}
+	if len(res) < 4 || uint16(res[2])<<8|uint16(res[3]) != id {
+		return nil, fmt.Errorf("response for wrong routine")
+	}
+	return res[4:], nil
+}
+
+// RequestDownload announces a download of size bytes to address, both sent as 4 bytes,
+// and returns maxNumberOfBlockLength, the longest TransferData request the server accepts.
+func (my *Client) RequestDownload(dataFormat uint8, address, size uint32) (int, error) {
+	res, err := my.Request([]byte{SIDRequestDownload, dataFormat, 0x44,
+		byte(address >> 24), byte(address >> 16), byte(address >> 8), byte(address),
+		byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)})
+	if err != nil {
+		return 0, err
+	}
+	if len(res) < 2 {
+		return 0, fmt.Errorf("response too short: %d bytes", len(res))
+	}
+	n := int(res[1] >> 4)
+	if n == 0 || len(res) < 2+n {
+		return 0, fmt.Errorf("invalid length format %#02x", res[1])
+	}
+	max := 0
+	for _, b := range res[2 : 2+n] {
+		max = max<<8 | int(b)
+	}
+	return max, nil
+}
+
+// TransferData sends one block, counter starts at 1 and wraps from 0xFF to 0x00.
+func (my *Client) TransferData(counter uint8, data []byte) ([]byte, error) {
+	res, err := my.Request(append([]byte{SIDTransferData, counter}, data...))
+	if err != nil {
+		return nil, err
+	}
+	if len(res) < 2 || res[1] != counter {
+		return nil, fmt.Errorf("response for wrong block")
+	}
+	return res[2:], nil
+}
+
+// RequestTransferExit ends a download.
+func (my *Client) RequestTransferExit(params []byte) ([]byte, error) {
+	res, err := my.Request(append([]byte{SIDRequestTransferExit}, params...))
+	if err != nil {
+		return nil, err
+	}
+	return res[1:], nil
+}
+
+// TesterPresent sends one tester present and waits for its response.
+func (my *Client) TesterPresent() error {
+	_, err := my.Request([]byte{SIDTesterPresent, 0x00})
+	return err
+}
+
+// StartTesterPresent keeps the session alive, sending tester present without response every interval.
+func (my *Client) StartTesterPresent(interval time.Duration) {
+	my.StopTesterPresent()
+	stop, done := make(chan struct{}), make(chan struct{})
+	my.stopKeepAlive, my.keepAliveDone = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				my.mu.Lock()
+				my.tp.Send([]byte{SIDTesterPresent, suppressPositiveResponse})
+				my.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// StopTesterPresent stops what StartTesterPresent() started.
+func (my *Client) StopTesterPresent() {
+	if my.stopKeepAlive == nil {
+		return
+	}
+	close(my.stopKeepAlive)
+	<-my.keepAliveDone
+	my.stopKeepAlive, my.keepAliveDone = nil, nil
+}
+
+// Client private.
+
+func (my *Client) dtcList(subfunction uint8, params ...byte) (uint8, []DTC, error) {
+	res, err := my.ReadDTCInformation(subfunction, params...)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(res) < 1 {
+		return 0, nil, fmt.Errorf("response too short: %d bytes", len(res))
+	}
+	var dtcs []DTC
+	for b := res[1:]; len(b) >= 4; b = b[4:] {
+		dtcs = append(dtcs, DTC{Code: uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]), Status: b[3]})
+	}
+	return res[0], dtcs, nil
+}
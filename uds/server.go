@@ -0,0 +1,403 @@
+package uds
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lion187chen/socketcan-go"
+)
+
+// Server timings, P2 and P2* are reported to the client in the session control response.
+const (
+	DefaultServerP2     = 50 * time.Millisecond
+	DefaultServerP2Star = 2 * time.Second
+	DefaultS3           = 5 * time.Second
+	maxKeyAttempts      = 3
+	securityDelay       = 10 * time.Second
+)
+
+// HandlerFunc serves one request, req starts with the service identifier.
+// It returns the positive response after the response service identifier,
+// or an error, a *NegativeResponseError for a specific NRC.
+type HandlerFunc func(req []byte) ([]byte, error)
+
+// Service is a registered service.
+type Service struct {
+	Handler HandlerFunc
+	// Sessions the service is available in, all if empty.
+	Sessions []uint8
+	// Security is the requestSeed level that has to be unlocked, 0 for none.
+	Security uint8
+	// SubFunction services honour the suppress positive response bit.
+	SubFunction bool
+}
+
+// DataIdentifier is a registered data identifier served by ReadDataByIdentifier and WriteDataByIdentifier.
+// Read or Write may be nil for write or read only identifiers.
+type DataIdentifier struct {
+	Read     func() ([]byte, error)
+	Write    func(data []byte) error
+	Sessions []uint8
+	// Security is the requestSeed level that has to be unlocked to write, 0 for none.
+	Security uint8
+}
+
+// NRC is a shorthand for handlers to answer with a negative response code.
+func NRC(code uint8) error {
+	return &NegativeResponseError{NRC: code}
+}
+
+// Server is a UDS (ISO 14229) server, it lets a Go process act as an ECU.
+// Session control, ECU reset, security access, tester present and data identifiers are built in,
+// everything else is served by registered handlers.
+type Server struct {
+	tp       Transport
+	mu       sync.Mutex
+	services map[uint8]*Service
+	dids     map[uint16]*DataIdentifier
+	stop     chan struct{}
+
+	session    uint8
+	security   uint8
+	seedLevel  uint8
+	seed       []byte
+	attempts   int
+	delayUntil time.Time
+	lastReq    time.Time
+
+	// Sessions SessionControl accepts.
+	Sessions []uint8
+	P2       time.Duration
+	P2Star   time.Duration
+	// S3 returns to the default session when no request arrived for this long.
+	S3 time.Duration
+	// SeedKey computes the expected key, SecurityAccess is refused without it.
+	SeedKey SeedKeyFunc
+	// OnReset is called after the ECUReset response was sent.
+	OnReset func(resetType uint8)
+}
+
+func (my *Server) Init(tp Transport) *Server {
+	my.tp = tp
+	my.services = make(map[uint8]*Service)
+	my.dids = make(map[uint16]*DataIdentifier)
+	my.stop = make(chan struct{})
+	my.session = SessionDefault
+	my.Sessions = []uint8{SessionDefault, SessionProgramming, SessionExtended}
+	my.P2 = DefaultServerP2
+	my.P2Star = DefaultServerP2Star
+	my.S3 = DefaultS3
+
+	my.Register(SIDDiagnosticSessionControl, Service{Handler: my.sessionControl, SubFunction: true})
+	my.Register(SIDECUReset, Service{Handler: my.ecuReset, SubFunction: true})
+	my.Register(SIDSecurityAccess, Service{Handler: my.securityAccess, SubFunction: true})
+	my.Register(SIDTesterPresent, Service{Handler: my.testerPresent, SubFunction: true})
+	my.Register(SIDReadDataByIdentifier, Service{Handler: my.readDataByIdentifier})
+	my.Register(SIDWriteDataByIdentifier, Service{Handler: my.writeDataByIdentifier})
+	return my
+}
+
+// Register adds or replaces the service sid, built in services may be replaced too.
+func (my *Server) Register(sid uint8, svc Service) {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	my.services[sid] = &svc
+}
+
+// RegisterDID adds or replaces a data identifier.
+func (my *Server) RegisterDID(did uint16, d DataIdentifier) {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	my.dids[did] = &d
+}
+
+// Session is the active diagnostic session.
+func (my *Server) Session() uint8 {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	return my.session
+}
+
+// Security is the unlocked requestSeed level, 0 when locked.
+func (my *Server) Security() uint8 {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	return my.security
+}
+
+// Serve will block serving requests until Stop() was called or the transport failed.
+func (my *Server) Serve() error {
+	for {
+		select {
+		case <-my.stop:
+			return nil
+		default:
+		}
+
+		req, err := my.tp.Receive(100 * time.Millisecond)
+		if err != nil {
+			if socketcan.IsTimeout(err) {
+				my.checkS3()
+				continue
+			}
+			return err
+		}
+		if len(req) == 0 {
+			continue
+		}
+		if err := my.serve(req); err != nil {
+			return err
+		}
+	}
+}
+
+// Stop makes Serve() return after the request in progress.
+func (my *Server) Stop() {
+	close(my.stop)
+}
+
+// Server private.
+
+func (my *Server) serve(req []byte) error {
+	my.mu.Lock()
+	my.lastReq = time.Now()
+	svc, ok := my.services[req[0]]
+	var nrc uint8
+	switch {
+	case !ok:
+		nrc = NRCServiceNotSupported
+	case !contains(svc.Sessions, my.session):
+		nrc = NRCServiceNotSupportedInActiveSession
+	case svc.SubFunction && len(req) < 2:
+		nrc = NRCIncorrectMessageLengthOrInvalidFormat
+	case svc.Security != 0 && svc.Security != my.security:
+		nrc = NRCSecurityAccessDenied
+	}
+	my.mu.Unlock()
+	if nrc != 0 {
+		return my.negative(req[0], nrc)
+	}
+
+	res, err := my.call(req, svc.Handler)
+	if err != nil {
+		var nr *NegativeResponseError
+		if !errors.As(err, &nr) {
+			return my.negative(req[0], NRCGeneralReject)
+		}
+		return my.negative(req[0], nr.NRC)
+	}
+	if svc.SubFunction && req[1]&suppressPositiveResponse != 0 {
+		return nil
+	}
+	return my.tp.Send(append([]byte{req[0] + positiveResponse}, res...))
+}
+
+// call runs the handler, sending response pending while it takes longer than P2.
+func (my *Server) call(req []byte, fn HandlerFunc) ([]byte, error) {
+	type result struct {
+		res []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := fn(req)
+		done <- result{res, err}
+	}()
+
+	timer := time.NewTimer(my.P2 / 2)
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-done:
+			return r.res, r.err
+		case <-timer.C:
+			if err := my.negative(req[0], NRCResponsePending); err != nil {
+				return nil, err
+			}
+			timer.Reset(my.P2Star / 2)
+		}
+	}
+}
+
+func (my *Server) negative(sid, nrc uint8) error {
+	return my.tp.Send([]byte{SIDNegativeResponse, sid, nrc})
+}
+
+func (my *Server) checkS3() {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	if my.session != SessionDefault && time.Since(my.lastReq) > my.S3 {
+		my.session = SessionDefault
+		my.lock()
+	}
+}
+
+func (my *Server) lock() {
+	my.security = 0
+	my.seed = nil
+}
+
+func (my *Server) sessionControl(req []byte) ([]byte, error) {
+	if len(req) != 2 {
+		return nil, NRC(NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	session := req[1] &^ suppressPositiveResponse
+	if !contains(my.Sessions, session) {
+		return nil, NRC(NRCSubFunctionNotSupported)
+	}
+
+	my.mu.Lock()
+	my.session = session
+	my.lock()
+	my.mu.Unlock()
+
+	p2 := uint16(my.P2 / time.Millisecond)
+	p2Star := uint16(my.P2Star / (10 * time.Millisecond))
+	return []byte{session, byte(p2 >> 8), byte(p2), byte(p2Star >> 8), byte(p2Star)}, nil
+}
+
+func (my *Server) ecuReset(req []byte) ([]byte, error) {
+	if len(req) != 2 {
+		return nil, NRC(NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	resetType := req[1] &^ suppressPositiveResponse
+	if resetType < ResetHard || resetType > ResetSoft {
+		return nil, NRC(NRCSubFunctionNotSupported)
+	}
+
+	my.mu.Lock()
+	my.session = SessionDefault
+	my.lock()
+	my.mu.Unlock()
+	if my.OnReset != nil {
+		// Reset after the response went out.
+		time.AfterFunc(my.P2, func() { my.OnReset(resetType) })
+	}
+	return []byte{resetType}, nil
+}
+
+func (my *Server) securityAccess(req []byte) ([]byte, error) {
+	level := req[1] &^ suppressPositiveResponse
+	if my.SeedKey == nil || level == 0 || level > 0x7E {
+		return nil, NRC(NRCSubFunctionNotSupported)
+	}
+	my.mu.Lock()
+	defer my.mu.Unlock()
+
+	// requestSeed
+	if level%2 == 1 {
+		if len(req) < 2 {
+			return nil, NRC(NRCIncorrectMessageLengthOrInvalidFormat)
+		}
+		if time.Now().Before(my.delayUntil) {
+			return nil, NRC(NRCRequiredTimeDelayNotExpired)
+		}
+		if my.security == level {
+			return []byte{level, 0, 0, 0, 0}, nil
+		}
+		my.seed = make([]byte, 4)
+		if _, err := rand.Read(my.seed); err != nil {
+			return nil, err
+		}
+		my.seedLevel = level
+		return append([]byte{level}, my.seed...), nil
+	}
+
+	// sendKey
+	if my.seed == nil || my.seedLevel != level-1 {
+		return nil, NRC(NRCRequestSequenceError)
+	}
+	seed := my.seed
+	my.seed = nil
+	key, err := my.SeedKey(level-1, seed)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(key, req[2:]) {
+		my.attempts++
+		if my.attempts >= maxKeyAttempts {
+			my.attempts = 0
+			my.delayUntil = time.Now().Add(securityDelay)
+			return nil, NRC(NRCExceededNumberOfAttempts)
+		}
+		return nil, NRC(NRCInvalidKey)
+	}
+	my.attempts = 0
+	my.security = level - 1
+	return []byte{level}, nil
+}
+
+func (my *Server) testerPresent(req []byte) ([]byte, error) {
+	if len(req) != 2 {
+		return nil, NRC(NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	if req[1]&^suppressPositiveResponse != 0 {
+		return nil, NRC(NRCSubFunctionNotSupported)
+	}
+	return []byte{req[1] &^ suppressPositiveResponse}, nil
+}
+
+func (my *Server) readDataByIdentifier(req []byte) ([]byte, error) {
+	if len(req) < 3 || len(req)%2 != 1 {
+		return nil, NRC(NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	var res []byte
+	for b := req[1:]; len(b) >= 2; b = b[2:] {
+		did := uint16(b[0])<<8 | uint16(b[1])
+		d, err := my.did(did, false)
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.Read()
+		if err != nil {
+			return nil, err
+		}
+		res = append(append(res, b[0], b[1]), data...)
+	}
+	return res, nil
+}
+
+func (my *Server) writeDataByIdentifier(req []byte) ([]byte, error) {
+	if len(req) < 4 {
+		return nil, NRC(NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	did := uint16(req[1])<<8 | uint16(req[2])
+	d, err := my.did(did, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Write(req[3:]); err != nil {
+		return nil, err
+	}
+	return req[1:3], nil
+}
+
+// did looks up a data identifier usable in the current state.
+func (my *Server) did(did uint16, write bool) (*DataIdentifier, error) {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	d, ok := my.dids[did]
+	if !ok || !contains(d.Sessions, my.session) || write && d.Write == nil || !write && d.Read == nil {
+		return nil, NRC(NRCRequestOutOfRange)
+	}
+	if write && d.Security != 0 && d.Security != my.security {
+		return nil, NRC(NRCSecurityAccessDenied)
+	}
+	return d, nil
+}
+
+// contains reports whether v is in list, an empty list contains everything.
+func contains(list []uint8, v uint8) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package uds
+
+import (
+	"fmt"
+	"time"
+)
+
+// Transport carries UDS messages, *isotp.Conn satisfies it.
+type Transport interface {
+	Send(data []byte) error
+	Receive(timeout time.Duration) ([]byte, error)
+}
+
+// Service identifiers.
+const (
+	SIDDiagnosticSessionControl   = 0x10
+	SIDECUReset                   = 0x11
+	SIDClearDiagnosticInformation = 0x14
+	SIDReadDTCInformation         = 0x19
+	SIDReadDataByIdentifier       = 0x22
+	SIDSecurityAccess             = 0x27
+	SIDWriteDataByIdentifier      = 0x2E
+	SIDRoutineControl             = 0x31
+	SIDRequestDownload            = 0x34
+	SIDTransferData               = 0x36
+	SIDRequestTransferExit        = 0x37
+	SIDTesterPresent              = 0x3E
+	SIDNegativeResponse           = 0x7F
+)
+
+// positiveResponse is the offset from a service identifier to its positive response.
+const positiveResponse = 0x40
+
+// suppressPositiveResponse is the bit a subfunction sets to ask for no positive response.
+const suppressPositiveResponse = 0x80
+
+// Diagnostic sessions.
+const (
+	SessionDefault     = 0x01
+	SessionProgramming = 0x02
+	SessionExtended    = 0x03
+)
+
+// ECU reset types.
+const (
+	ResetHard     = 0x01
+	ResetKeyOffOn = 0x02
+	ResetSoft     = 0x03
+)
+
+// Routine control types.
+const (
+	RoutineStart   = 0x01
+	RoutineStop    = 0x02
+	RoutineResults = 0x03
+)
+
+// ReadDTCInformation subfunctions.
+const (
+	ReportNumberOfDTCByStatusMask      = 0x01
+	ReportDTCByStatusMask              = 0x02
+	ReportDTCSnapshotRecordByDTCNumber = 0x04
+	ReportDTCExtDataRecordByDTCNumber  = 0x06
+	ReportSupportedDTC                 = 0x0A
+)
+
+// Negative response codes.
+const (
+	NRCGeneralReject                          = 0x10
+	NRCServiceNotSupported                    = 0x11
+	NRCSubFunctionNotSupported                = 0x12
+	NRCIncorrectMessageLengthOrInvalidFormat  = 0x13
+	NRCResponseTooLong                        = 0x14
+	NRCBusyRepeatRequest                      = 0x21
+	NRCConditionsNotCorrect                   = 0x22
+	NRCRequestSequenceError                   = 0x24
+	NRCRequestOutOfRange                      = 0x31
+	NRCSecurityAccessDenied                   = 0x33
+	NRCInvalidKey                             = 0x35
+	NRCExceededNumberOfAttempts               = 0x36
+	NRCRequiredTimeDelayNotExpired            = 0x37
+	NRCUploadDownloadNotAccepted              = 0x70
+	NRCTransferDataSuspended                  = 0x71
+	NRCGeneralProgrammingFailure              = 0x72
+	NRCWrongBlockSequenceCounter              = 0x73
+	NRCResponsePending                        = 0x78
+	NRCSubFunctionNotSupportedInActiveSession = 0x7E
+	NRCServiceNotSupportedInActiveSession     = 0x7F
+)
+
+var nrcNames = map[uint8]string{
+	NRCGeneralReject:                          "general reject",
+	NRCServiceNotSupported:                    "service not supported",
+	NRCSubFunctionNotSupported:                "sub-function not supported",
+	NRCIncorrectMessageLengthOrInvalidFormat:  "incorrect message length or invalid format",
+	NRCResponseTooLong:                        "response too long",
+	NRCBusyRepeatRequest:                      "busy, repeat request",
+	NRCConditionsNotCorrect:                   "conditions not correct",
+	NRCRequestSequenceError:                   "request sequence error",
+	NRCRequestOutOfRange:                      "request out of range",
+	NRCSecurityAccessDenied:                   "security access denied",
+	NRCInvalidKey:                             "invalid key",
+	NRCExceededNumberOfAttempts:               "exceeded number of attempts",
+	NRCRequiredTimeDelayNotExpired:            "required time delay not expired",
+	NRCUploadDownloadNotAccepted:              "upload/download not accepted",
+	NRCTransferDataSuspended:                  "transfer data suspended",
+	NRCGeneralProgrammingFailure:              "general programming failure",
+	NRCWrongBlockSequenceCounter:              "wrong block sequence counter",
+	NRCResponsePending:                        "response pending",
+	NRCSubFunctionNotSupportedInActiveSession: "sub-function not supported in active session",
+	NRCServiceNotSupportedInActiveSession:     "service not supported in active session",
+}
+
+// NegativeResponseError is a negative response (0x7F) from the server.
+type NegativeResponseError struct {
+	SID uint8
+	NRC uint8
+}
+
+func (e *NegativeResponseError) Error() string {
+	name, ok := nrcNames[e.NRC]
+	if !ok {
+		name = "unknown"
+	}
+	return fmt.Sprintf("negative response to service %#02x: %#02x (%s)", e.SID, e.NRC, name)
+}